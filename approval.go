@@ -0,0 +1,65 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// approverMentionField is a Discord mention (e.g. `<@&123>`) pinged when a Build awaits approval.
+	approverMentionField = "approverMention"
+
+	pendingColor  = 16705372
+	approvedColor = 5763719
+	rejectedColor = 15548997
+)
+
+// pendingEmbed asks for a decision on a Build awaiting approval, linking to its console page where
+// it can be approved or rejected.
+func (s *discordNotifier) pendingEmbed(build *cbpb.Build) embed {
+	e := embed{
+		Title:       s.text("title.awaitingApproval"),
+		Color:       pendingColor,
+		Description: s.buildDescription(build),
+	}
+	if build.LogUrl != "" {
+		e.Description += "\n[" + s.text("text.approveOrReject") + "](" + build.LogUrl + ")"
+	}
+	return e
+}
+
+// approvalOutcomeEmbed announces an approval decision: approved Builds are reported when they are queued
+// to run and rejected ones when Cloud Build cancels them. It returns false for other Builds.
+func (s *discordNotifier) approvalOutcomeEmbed(build *cbpb.Build) (embed, bool) {
+	var e embed
+	switch state := build.Approval.GetState(); {
+	case state == cbpb.BuildApproval_APPROVED && build.Status == cbpb.Build_QUEUED:
+		e = embed{Title: s.text("title.approved"), Color: approvedColor}
+	case state == cbpb.BuildApproval_REJECTED && build.Status == cbpb.Build_CANCELLED:
+		e = embed{Title: s.text("title.rejected"), Color: rejectedColor}
+	default:
+		return embed{}, false
+	}
+	e.Description = s.buildDescription(build)
+	result := build.Approval.GetResult()
+	if a := result.GetApproverAccount(); a != "" {
+		e.Description += "\n" + s.detail("label.approver", a)
+	}
+	if c := result.GetComment(); c != "" {
+		e.Description += "\n" + s.detail("label.comment", c)
+	}
+	return e, true
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestBuildMessageApproval(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{approverMentionField: "<@&42>"})
+	result := &cbpb.ApprovalResult{ApproverAccount: "lead@example.com", Comment: "ship it"}
+	for _, tc := range []struct {
+		name        string
+		status      cbpb.Build_Status
+		approval    *cbpb.BuildApproval
+		wantTitle   string
+		wantContent string
+		wantLine    string
+	}{{
+		name:        "awaiting approval",
+		status:      cbpb.Build_PENDING,
+		approval:    &cbpb.BuildApproval{State: cbpb.BuildApproval_PENDING},
+		wantTitle:   "✋ AWAITING APPROVAL",
+		wantContent: "<@&42>",
+		wantLine:    "\n[Approve or reject](https://some.example.com/log/url?foo=bar)",
+	}, {
+		name:      "approved",
+		status:    cbpb.Build_QUEUED,
+		approval:  &cbpb.BuildApproval{State: cbpb.BuildApproval_APPROVED, Result: result},
+		wantTitle: "👍 APPROVED",
+		wantLine:  "\nBy: lead@example.com\nComment: ship it",
+	}, {
+		name:      "rejected",
+		status:    cbpb.Build_CANCELLED,
+		approval:  &cbpb.BuildApproval{State: cbpb.BuildApproval_REJECTED, Result: result},
+		wantTitle: "👎 REJECTED",
+		wantLine:  "\nBy: lead@example.com\nComment: ship it",
+	}, {
+		name:      "cancelled without approval",
+		status:    cbpb.Build_CANCELLED,
+		wantTitle: "🚫 CANCELLED",
+	}} {
+		b := testBuild()
+		b.Status = tc.status
+		b.Approval = tc.approval
+		msg, err := n.buildMessage(b)
+		if err != nil {
+			t.Fatalf("%s: buildMessage failed: %v", tc.name, err)
+		}
+		if msg == nil {
+			t.Fatalf("%s: buildMessage got nil, want a %q embed", tc.name, tc.wantTitle)
+		}
+		if got := msg.Embeds[0]; got.Title != tc.wantTitle || !strings.Contains(got.Description, tc.wantLine) {
+			t.Errorf("%s: got embed %+v, want title %q with %q", tc.name, got, tc.wantTitle, tc.wantLine)
+		}
+		if msg.Content != tc.wantContent {
+			t.Errorf("%s: got content %q, want %q", tc.name, msg.Content, tc.wantContent)
+		}
+	}
+
+	// Queued Builds that weren't just approved still need notifyOnQueued.
+	b := testBuild()
+	b.Status = cbpb.Build_QUEUED
+	if msg, err := n.buildMessage(b); err != nil || msg != nil {
+		t.Errorf("buildMessage of a queued build got (%+v, %v), want (nil, nil)", msg, err)
+	}
+}
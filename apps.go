@@ -0,0 +1,164 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// webhookURLsField maps app names, as named by appNameKey, to the `secretRef` of their webhook.
+	webhookURLsField = "webhookUrls"
+	// webhookURLSecretField is a Secret Manager resource name with `${SUBSTITUTION}` references, e.g.
+	// `projects/p/secrets/discord-${_APP_NAME}/versions/latest`, resolved for each Build.
+	webhookURLSecretField = "webhookUrlSecret"
+)
+
+var (
+	// substitutionRef matches a `${NAME}` reference to a Build substitution.
+	substitutionRef = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+	// secretIDValue matches the characters allowed in a secret ID, so that a substitution can't
+	// point the template at a different resource.
+	secretIDValue = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// appSink routes each Build to the webhook of its app, so that one notifier can serve many teams
+// with their own channels. Builds of apps without a webhook go to the top-level webhookUrl, if any.
+type appSink struct {
+	n  *discordNotifier
+	sg notifiers.SecretGetter
+	// apps holds the webhooks from webhookURLsField by app name.
+	apps map[string]*discordSink
+	// secret is the webhookURLSecretField template.
+	secret string
+	// fallback receives Builds no other webhook is found for; it may be nil.
+	fallback *discordSink
+
+	mu sync.Mutex
+	// resolved caches the webhooks resolved from secret by resource name, keeping their threads and
+	// tracked messages between Builds.
+	resolved map[string]*discordSink
+}
+
+func (a *appSink) name() string {
+	return sinkTypeDiscord
+}
+
+func (a *appSink) send(ctx context.Context, build *cbpb.Build, msg *discordMessage) error {
+	d, err := a.sinkFor(ctx, build)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		// Failing would have Pub/Sub redeliver a Build that can never be routed.
+		buildLog(build).Warningf("no webhook found for app %q of Build %q, dropping the notification", a.n.appName(build), build.Id)
+		return nil
+	}
+	return d.send(ctx, build, msg)
+}
+
+// sinkFor returns the webhook for the Build's app, or nil if there is none.
+func (a *appSink) sinkFor(ctx context.Context, build *cbpb.Build) (*discordSink, error) {
+	if d, ok := a.apps[a.n.appName(build)]; ok {
+		return d, nil
+	}
+	if a.secret == "" {
+		return a.fallback, nil
+	}
+	resource, ok := expandSubstitutions(a.secret, build)
+	if !ok {
+		return a.fallback, nil
+	}
+	a.mu.Lock()
+	d, ok := a.resolved[resource]
+	a.mu.Unlock()
+	if ok {
+		return d, nil
+	}
+	u, err := a.sg.GetSecret(ctx, resource)
+	if err != nil {
+		if a.fallback == nil {
+			return nil, fmt.Errorf("failed to get webhook secret %q for Build %q: %w", resource, build.Id, err)
+		}
+		buildLog(build).Warningf("failed to get webhook secret %q, using the default webhook: %v", resource, err)
+		return a.fallback, nil
+	}
+	d = a.n.newDiscordSink(u)
+	a.mu.Lock()
+	// Only webhooks that were found are cached, so a secret created for a new app is picked up.
+	if prev, ok := a.resolved[resource]; ok {
+		d = prev
+	} else {
+		a.resolved[resource] = d
+	}
+	a.mu.Unlock()
+	return d, nil
+}
+
+// expandSubstitutions replaces the `${NAME}` references in tmpl with the Build's substitutions. It
+// reports false if any of them is unset or has characters a secret ID can't.
+func expandSubstitutions(tmpl string, build *cbpb.Build) (string, bool) {
+	ok := true
+	out := substitutionRef.ReplaceAllStringFunc(tmpl, func(ref string) string {
+		v := build.Substitutions[substitutionRef.FindStringSubmatch(ref)[1]]
+		if !secretIDValue.MatchString(v) {
+			ok = false
+		}
+		return v
+	})
+	return out, ok
+}
+
+// hasAppRouting reports whether the delivery config routes Builds to per-app webhooks.
+func hasAppRouting(delivery map[string]interface{}) bool {
+	_, apps := delivery[webhookURLsField]
+	_, secret := delivery[webhookURLSecretField]
+	return apps || secret
+}
+
+// setUpAppSink builds the per-app webhook routing from the given delivery config, with the optional
+// fallback for Builds of other apps.
+func (s *discordNotifier) setUpAppSink(ctx context.Context, cfg *notifiers.Config, sg notifiers.SecretGetter, delivery map[string]interface{}, fallback *discordSink) (*appSink, error) {
+	a := &appSink{n: s, sg: sg, fallback: fallback, resolved: make(map[string]*discordSink)}
+	if raw, ok := delivery[webhookURLsField]; ok {
+		apps, err := toStringMap(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid delivery config field %q: %w", webhookURLsField, err)
+		}
+		a.apps = make(map[string]*discordSink, len(apps))
+		for app := range apps {
+			u, err := getSecret(ctx, sg, cfg.Spec.Secrets, apps, app)
+			if err != nil {
+				return nil, fmt.Errorf("invalid delivery config field %q: %w", webhookURLsField, err)
+			}
+			a.apps[app] = s.newDiscordSink(u)
+		}
+	}
+	var err error
+	if a.secret, err = getString(delivery, webhookURLSecretField); err != nil {
+		return nil, err
+	}
+	if _, ok := delivery[webhookURLSecretField]; ok && (!strings.HasPrefix(a.secret, "projects/") || !substitutionRef.MatchString(a.secret)) {
+		return nil, fmt.Errorf("expected delivery config field %q to be a projects/... secret resource name with a ${SUBSTITUTION} reference, got %q", webhookURLSecretField, a.secret)
+	}
+	return a, nil
+}
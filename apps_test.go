@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+)
+
+func TestAppWebhooks(t *testing.T) {
+	defaultSrv, defaultReqs := recordingServer(t, http.StatusNoContent, "")
+	frontendSrv, frontendReqs := recordingServer(t, http.StatusNoContent, "")
+	backendSrv, backendReqs := recordingServer(t, http.StatusNoContent, "")
+
+	cfg := newTestConfig(map[string]interface{}{
+		webhookURLsField: map[interface{}]interface{}{
+			"frontend": map[interface{}]interface{}{"secretRef": "frontend-url"},
+		},
+		webhookURLSecretField: "projects/p/secrets/discord-${_APP_NAME}/versions/latest",
+	})
+	cfg.Spec.Secrets = append(cfg.Spec.Secrets, &notifiers.Secret{LocalName: "frontend-url", ResourceName: "projects/p/secrets/frontend/versions/latest"})
+	sg := fakeSecretGetter{
+		"projects/p/secrets/webhook-url/versions/latest":     defaultSrv.URL,
+		"projects/p/secrets/frontend/versions/latest":        frontendSrv.URL,
+		"projects/p/secrets/discord-backend/versions/latest": backendSrv.URL,
+	}
+	n := new(discordNotifier)
+	if err := n.SetUp(context.Background(), cfg, sg, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+
+	for _, app := range []string{"frontend", "backend", "backend", "mobile", "bad/app"} {
+		b := testBuild()
+		b.Substitutions["_APP_NAME"] = app
+		if err := n.SendNotification(context.Background(), b); err != nil {
+			t.Fatalf("SendNotification(%q) failed: %v", app, err)
+		}
+	}
+	for name, tc := range map[string]struct {
+		got  int
+		want int
+	}{
+		"frontend": {len(frontendReqs()), 1},
+		"backend":  {len(backendReqs()), 2},
+		"default":  {len(defaultReqs()), 2},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s webhook got %d requests, want %d", name, tc.got, tc.want)
+		}
+	}
+	if got := len(n.sinks[0].(*appSink).resolved); got != 1 {
+		t.Errorf("resolved %d templated webhooks, want 1 cached", got)
+	}
+}
+
+func TestAppWebhooksWithoutDefault(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusNoContent, "")
+	cfg := &notifiers.Config{Spec: &notifiers.Spec{Notification: &notifiers.Notification{Delivery: map[string]interface{}{
+		webhookURLSecretField: "projects/p/secrets/discord-${_APP_NAME}/versions/latest",
+	}}}}
+	n := new(discordNotifier)
+	if err := n.SetUp(context.Background(), cfg, fakeSecretGetter{"projects/p/secrets/discord-my-app/versions/latest": srv.URL}, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if len(reqs()) != 1 {
+		t.Errorf("got %d requests to the app webhook, want 1", len(reqs()))
+	}
+
+	b := testBuild()
+	b.Substitutions["_APP_NAME"] = "unknown"
+	if err := n.SendNotification(context.Background(), b); err == nil {
+		t.Error("SendNotification for an app without a secret succeeded, want the secret error")
+	}
+	delete(b.Substitutions, "_APP_NAME")
+	if err := n.SendNotification(context.Background(), b); err != nil {
+		t.Errorf("SendNotification without the substitution = %v, want it dropped", err)
+	}
+}
+
+func TestSetUpAppWebhooksErrors(t *testing.T) {
+	for name, delivery := range map[string]map[string]interface{}{
+		"not a resource":  {webhookURLSecretField: "discord-${_APP_NAME}"},
+		"no substitution": {webhookURLSecretField: "projects/p/secrets/discord/versions/latest"},
+		"not a map":       {webhookURLsField: []interface{}{"frontend"}},
+		"unknown secret":  {webhookURLsField: map[interface{}]interface{}{"frontend": map[interface{}]interface{}{"secretRef": "missing"}}},
+		"with a bot": {
+			webhookURLSecretField: "projects/p/secrets/discord-${_APP_NAME}/versions/latest",
+			botTokenSecretName:    map[interface{}]interface{}{"secretRef": "bot-token"},
+			channelIDField:        "1",
+		},
+	} {
+		cfg := &notifiers.Config{Spec: &notifiers.Spec{Notification: &notifiers.Notification{Delivery: delivery}}}
+		if err := new(discordNotifier).SetUp(context.Background(), cfg, fakeSecretGetter{}, nil); err == nil {
+			t.Errorf("%s: SetUp succeeded, want error", name)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// maxListedImages caps the number of pushed images listed in a success notification.
+const maxListedImages = 10
+
+// artifactLines lists the images a successful Build pushed, each as a copyable `name@digest`, followed
+// by where its artifacts were uploaded. It returns "" for Builds that produced neither.
+func (s *discordNotifier) artifactLines(build *cbpb.Build) string {
+	var lines []string
+	images := build.Results.GetImages()
+	if len(images) > 0 {
+		lines = append(lines, s.text("heading.images"))
+		for i, img := range images {
+			if i == maxListedImages {
+				lines = append(lines, s.textN("text.more", len(images)-maxListedImages))
+				break
+			}
+			ref := img.Name
+			if img.Digest != "" {
+				ref += "@" + img.Digest
+			}
+			lines = append(lines, "`"+ref+"`")
+		}
+	}
+	if loc := build.Artifacts.GetObjects().GetLocation(); loc != "" {
+		if n := build.Results.GetNumArtifacts(); n > 0 {
+			loc += " " + s.textN("text.files", int(n))
+		}
+		lines = append(lines, s.detail("label.artifacts", loc))
+	}
+	if m := build.Results.GetArtifactManifest(); m != "" {
+		lines = append(lines, s.detail("label.manifest", m))
+	}
+	return strings.Join(lines, "\n")
+}
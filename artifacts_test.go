@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestArtifactLines(t *testing.T) {
+	var many []*cbpb.BuiltImage
+	for i := 0; i < maxListedImages+2; i++ {
+		many = append(many, &cbpb.BuiltImage{Name: fmt.Sprintf("gcr.io/p/img%d", i)})
+	}
+	for _, tc := range []struct {
+		name  string
+		build *cbpb.Build
+		want  string
+	}{{
+		name:  "nothing produced",
+		build: &cbpb.Build{},
+	}, {
+		name: "images and artifacts",
+		build: &cbpb.Build{
+			Results: &cbpb.Results{
+				Images: []*cbpb.BuiltImage{
+					{Name: "gcr.io/p/app:latest", Digest: "sha256:abc"},
+					{Name: "gcr.io/p/worker"},
+				},
+				NumArtifacts:     3,
+				ArtifactManifest: "gs://bucket/out/artifacts-1.json",
+			},
+			Artifacts: &cbpb.Artifacts{Objects: &cbpb.Artifacts_ArtifactObjects{Location: "gs://bucket/out/", Paths: []string{"*.jar"}}},
+		},
+		want: "Images:\n`gcr.io/p/app:latest@sha256:abc`\n`gcr.io/p/worker`\nArtifacts: gs://bucket/out/ (3 files)\nManifest: gs://bucket/out/artifacts-1.json",
+	}} {
+		if got := new(discordNotifier).artifactLines(tc.build); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	got := new(discordNotifier).artifactLines(&cbpb.Build{Results: &cbpb.Results{Images: many}})
+	if !strings.HasSuffix(got, "\n… and 2 more") || strings.Count(got, "`gcr.io") != maxListedImages {
+		t.Errorf("got %q, want %d images and an `… and 2 more` line", got, maxListedImages)
+	}
+}
+
+func TestBuildMessageArtifacts(t *testing.T) {
+	b := testBuild()
+	b.Results = &cbpb.Results{Images: []*cbpb.BuiltImage{{Name: "gcr.io/p/app", Digest: "sha256:abc"}}}
+	msg, err := new(discordNotifier).buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if got, want := msg.Embeds[0].Description, "\nImages:\n`gcr.io/p/app@sha256:abc`"; !strings.Contains(got, want) {
+		t.Errorf("got description %q, want it to contain %q", got, want)
+	}
+
+	b.Status = cbpb.Build_FAILURE
+	if msg, err = new(discordNotifier).buildMessage(b); err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if got := msg.Embeds[0].Description; strings.Contains(got, "Images:") {
+		t.Errorf("got failure description %q, want no image list", got)
+	}
+}
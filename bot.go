@@ -0,0 +1,65 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// botTokenSecretName and channelIDField configure bot delivery as an alternative to webhookUrl.
+	botTokenSecretName = "botToken"
+	channelIDField     = "channelId"
+
+	// discordAPIBase is the Discord REST API that bot messages are posted to.
+	discordAPIBase = "https://discord.com/api/v10"
+)
+
+// botSink posts messages to a Discord channel as a bot, for teams that can't use incoming webhooks.
+type botSink struct {
+	n         *discordNotifier
+	token     string
+	channelID string
+	apiBase   string
+}
+
+func (s *discordNotifier) newBotSink(token, channelID string) *botSink {
+	return &botSink{n: s, token: token, channelID: channelID, apiBase: discordAPIBase}
+}
+
+func (b *botSink) name() string { return "bot" }
+
+func (b *botSink) send(ctx context.Context, build *cbpb.Build, msg *discordMessage) error {
+	m := withinLimits(msg)
+	if rows := b.n.components(build); rows != nil {
+		c := *m
+		c.Components = rows
+		m = &c
+	}
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal payload %w", err)
+	}
+	b.n.logPayload(build, fmt.Sprintf("sending bot payload to channel %q", b.channelID), payload)
+	header := http.Header{"Authorization": {"Bot " + b.token}}
+	_, err = b.n.sendJSON(ctx, build, http.MethodPost, b.apiBase+"/channels/"+url.PathEscape(b.channelID)+"/messages", nil, header, payload)
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+)
+
+func newBotTestConfig(delivery map[string]interface{}) *notifiers.Config {
+	return &notifiers.Config{
+		Spec: &notifiers.Spec{
+			Notification: &notifiers.Notification{Delivery: delivery},
+			Secrets: []*notifiers.Secret{
+				{LocalName: "bot-token", ResourceName: "projects/p/secrets/bot-token/versions/latest"},
+				{LocalName: "webhook-url", ResourceName: "projects/p/secrets/webhook-url/versions/latest"},
+			},
+		},
+	}
+}
+
+func TestBotDelivery(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusOK, `{"id": "1"}`)
+	cfg := newBotTestConfig(map[string]interface{}{
+		botTokenSecretName: map[interface{}]interface{}{"secretRef": "bot-token"},
+		channelIDField:     "123456789",
+	})
+	sg := fakeSecretGetter{"projects/p/secrets/bot-token/versions/latest": "s3cret"}
+	n := new(discordNotifier)
+	if err := n.SetUp(context.Background(), cfg, sg, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	bs, ok := n.sinks[0].(*botSink)
+	if !ok || len(n.sinks) != 1 {
+		t.Fatalf("got sinks %+v, want a single bot sink", n.sinks)
+	}
+	if bs.apiBase != discordAPIBase {
+		t.Errorf("got API base %q, want %q", bs.apiBase, discordAPIBase)
+	}
+	bs.apiBase = srv.URL
+
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	got := reqs()
+	if len(got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(got))
+	}
+	if got[0].method != http.MethodPost {
+		t.Errorf("got method %q, want POST", got[0].method)
+	}
+	if auth := got[0].header.Get("Authorization"); auth != "Bot s3cret" {
+		t.Errorf("got Authorization %q, want %q", auth, "Bot s3cret")
+	}
+	var msg discordMessage
+	if err := json.Unmarshal(got[0].body, &msg); err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	if len(msg.Embeds) != 1 || msg.Embeds[0].Title != "✅ SUCCESS" {
+		t.Errorf("got payload %+v, want the SUCCESS embed", msg)
+	}
+}
+
+func TestBotDeliveryPath(t *testing.T) {
+	var path string
+	n := &discordNotifier{client: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		path = r.URL.String()
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})}}
+	if err := n.newBotSink("token", "123456789").send(context.Background(), testBuild(), &discordMessage{}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if want := "https://discord.com/api/v10/channels/123456789/messages"; path != want {
+		t.Errorf("got request URL %q, want %q", path, want)
+	}
+}
+
+func TestSetUpDeliveryTargetExclusive(t *testing.T) {
+	sg := fakeSecretGetter{
+		"projects/p/secrets/bot-token/versions/latest":   "s3cret",
+		"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com",
+	}
+	webhook := map[interface{}]interface{}{"secretRef": "webhook-url"}
+	token := map[interface{}]interface{}{"secretRef": "bot-token"}
+	for name, delivery := range map[string]map[string]interface{}{
+		"neither":         {},
+		"both":            {webhookURLSecretName: webhook, botTokenSecretName: token, channelIDField: "123"},
+		"missing channel": {botTokenSecretName: token},
+		"missing token":   {channelIDField: "123"},
+	} {
+		if err := new(discordNotifier).SetUp(context.Background(), newBotTestConfig(delivery), sg, nil); err == nil {
+			t.Errorf("%s: SetUp succeeded, want error", name)
+		}
+	}
+}
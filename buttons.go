@@ -0,0 +1,363 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2/google"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// buttonsField lists the buttons added to bot messages: buttonRetry on failed Builds and buttonApprove
+	// on Builds awaiting approval. Webhook messages can't carry buttons.
+	buttonsField = "buttons"
+	// interactionPublicKeyField is the hex public key of the Discord application, used to verify clicks.
+	interactionPublicKeyField = "interactionPublicKey"
+	// buttonRolesField optionally restricts clicks to members with one of the listed Discord role IDs.
+	buttonRolesField = "buttonRoles"
+
+	buttonRetry   = "retry"
+	buttonApprove = "approve"
+
+	// interactionsPath is set as the Discord application's Interactions Endpoint URL.
+	interactionsPath = "/interactions"
+
+	interactionTimeout = 2500 * time.Millisecond
+
+	cloudBuildAPIBase  = "https://cloudbuild.googleapis.com"
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// Discord component and interaction types.
+const (
+	componentActionRow = 1
+	componentButton    = 2
+
+	buttonStylePrimary = 1
+	buttonStyleSuccess = 3
+
+	interactionPing             = 1
+	interactionMessageComponent = 3
+
+	responsePong           = 1
+	responseChannelMessage = 4
+
+	// messageFlagEphemeral shows an interaction response only to the member who clicked.
+	messageFlagEphemeral = 64
+)
+
+// actionRow holds a message's buttons.
+type actionRow struct {
+	Type       int      `json:"type"`
+	Components []button `json:"components"`
+}
+
+type button struct {
+	Type     int    `json:"type"`
+	Style    int    `json:"style"`
+	Label    string `json:"label"`
+	CustomID string `json:"custom_id"`
+}
+
+// buildRef identifies a Build on a button, within the 100 characters Discord allows for a custom_id.
+type buildRef struct {
+	project, region, id string
+}
+
+func refFor(build *cbpb.Build) buildRef {
+	return buildRef{project: build.ProjectId, region: buildRegion(build), id: build.Id}
+}
+
+// name returns the Build's resource name in the Cloud Build API.
+func (b buildRef) name() string {
+	if b.region != "" {
+		return "projects/" + b.project + "/locations/" + b.region + "/builds/" + b.id
+	}
+	return "projects/" + b.project + "/builds/" + b.id
+}
+
+// customID encodes the action and Build as `action:project:region:id`.
+func customID(action string, b buildRef) string {
+	return strings.Join([]string{action, b.project, b.region, b.id}, ":")
+}
+
+func parseCustomID(id string) (string, buildRef, error) {
+	parts := strings.Split(id, ":")
+	if len(parts) != 4 || parts[1] == "" || parts[3] == "" {
+		return "", buildRef{}, fmt.Errorf("malformed custom_id %q", id)
+	}
+	return parts[0], buildRef{project: parts[1], region: parts[2], id: parts[3]}, nil
+}
+
+// buildActions performs the actions behind the buttons.
+type buildActions interface {
+	retry(ctx context.Context, b buildRef) error
+	approve(ctx context.Context, b buildRef, comment string) error
+}
+
+// getButtons reads the button config from the given delivery config. Buttons need bot delivery, since
+// Discord drops components from ordinary webhook messages, and the application's public key.
+func (s *discordNotifier) getButtons(delivery map[string]interface{}) error {
+	names, err := getStringSlice(delivery, buttonsField)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	s.buttons = make(map[string]bool, len(names))
+	for _, name := range names {
+		switch name {
+		case buttonRetry, buttonApprove:
+			s.buttons[name] = true
+		default:
+			return fmt.Errorf("unknown button %q in delivery config field %q: expected %q or %q", name, buttonsField, buttonRetry, buttonApprove)
+		}
+	}
+	if _, ok := delivery[botTokenSecretName]; !ok {
+		return fmt.Errorf("delivery config field %q requires bot delivery with %q and %q", buttonsField, botTokenSecretName, channelIDField)
+	}
+	key, err := getString(delivery, interactionPublicKeyField)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return fmt.Errorf("delivery config field %q requires %q to be set", buttonsField, interactionPublicKeyField)
+	}
+	pk, err := hex.DecodeString(key)
+	if err != nil || len(pk) != ed25519.PublicKeySize {
+		return fmt.Errorf("expected delivery config field %q to be a hex Ed25519 public key", interactionPublicKeyField)
+	}
+	s.interactionKey = ed25519.PublicKey(pk)
+	if s.buttonRoles, err = getStringSlice(delivery, buttonRolesField); err != nil {
+		return err
+	}
+	if s.actions == nil {
+		s.actions = &cloudBuildREST{base: cloudBuildAPIBase}
+	}
+	return nil
+}
+
+// components returns the buttons for the Build, or nil if none apply.
+func (s *discordNotifier) components(build *cbpb.Build) []actionRow {
+	var buttons []button
+	if s.buttons[buttonRetry] && isFailureStatus(build.Status) {
+		buttons = append(buttons, button{Type: componentButton, Style: buttonStylePrimary, Label: s.text("button.retry"), CustomID: customID(buttonRetry, refFor(build))})
+	}
+	if s.buttons[buttonApprove] && build.Status == cbpb.Build_PENDING && build.Approval.GetState() == cbpb.BuildApproval_PENDING {
+		buttons = append(buttons, button{Type: componentButton, Style: buttonStyleSuccess, Label: s.text("button.approve"), CustomID: customID(buttonApprove, refFor(build))})
+	}
+	if len(buttons) == 0 {
+		return nil
+	}
+	return []actionRow{{Type: componentActionRow, Components: buttons}}
+}
+
+// interaction is the subset of a Discord interaction that button clicks need.
+type interaction struct {
+	Type int `json:"type"`
+	Data struct {
+		CustomID string `json:"custom_id"`
+	} `json:"data"`
+	Member *struct {
+		Roles []string     `json:"roles"`
+		User  *discordUser `json:"user"`
+	} `json:"member"`
+	User *discordUser `json:"user"`
+}
+
+type discordUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// clicker returns who clicked, preferring the guild member over the user of a DM.
+func (i *interaction) clicker() *discordUser {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	if i.User != nil {
+		return i.User
+	}
+	return &discordUser{}
+}
+
+type interactionResponse struct {
+	Type int                      `json:"type"`
+	Data *interactionResponseData `json:"data,omitempty"`
+}
+
+type interactionResponseData struct {
+	Content         string           `json:"content"`
+	Flags           int              `json:"flags"`
+	AllowedMentions *allowedMentions `json:"allowed_mentions"`
+}
+
+// interactionHandler serves the button clicks Discord sends to interactionsPath.
+type interactionHandler struct {
+	n notifierSource
+}
+
+func (h interactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := h.n.current()
+	if n.interactionKey == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read request", http.StatusBadRequest)
+		return
+	}
+	// Discord signs the timestamp and body, and disables the endpoint if unsigned requests are accepted.
+	sig, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || !ed25519.Verify(n.interactionKey, append([]byte(r.Header.Get("X-Signature-Timestamp")), body...), sig) {
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+	var in interaction
+	if err := json.Unmarshal(body, &in); err != nil {
+		http.Error(w, "invalid interaction", http.StatusBadRequest)
+		return
+	}
+	var resp interactionResponse
+	switch in.Type {
+	case interactionPing:
+		resp = interactionResponse{Type: responsePong}
+	case interactionMessageComponent:
+		resp = interactionResponse{Type: responseChannelMessage, Data: &interactionResponseData{
+			Content:         n.click(r.Context(), &in),
+			Flags:           messageFlagEphemeral,
+			AllowedMentions: &allowedMentions{Parse: []string{}},
+		}}
+	default:
+		http.Error(w, "unsupported interaction type", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// click performs the clicked button's action and returns the reply shown to whoever clicked.
+func (s *discordNotifier) click(ctx context.Context, in *interaction) string {
+	action, ref, err := parseCustomID(in.Data.CustomID)
+	if err != nil || !s.buttons[action] {
+		return s.text("reply.unknownButton")
+	}
+	if !s.mayClick(in) {
+		return s.text("reply.notAllowed")
+	}
+	user := in.clicker()
+	// Discord shows an error unless it hears back within 3 seconds.
+	ctx, cancel := context.WithTimeout(ctx, interactionTimeout)
+	defer cancel()
+	clickLog := fieldLogger{l: notifierLog, logFields: logFields{BuildID: ref.id, ProjectID: ref.project}}
+	switch action {
+	case buttonRetry:
+		if err := s.actions.retry(ctx, ref); err != nil {
+			clickLog.Errorf("failed to retry Build %q for %s (%s): %v", ref.id, user.Username, user.ID, err)
+			return s.text("reply.retryFailed", truncateError(err.Error()))
+		}
+		clickLog.Infof("retried Build %q for %s (%s)", ref.id, user.Username, user.ID)
+		return s.text("reply.retrying", ref.id)
+	default:
+		if err := s.actions.approve(ctx, ref, s.text("text.approvedBy", user.Username)); err != nil {
+			clickLog.Errorf("failed to approve Build %q for %s (%s): %v", ref.id, user.Username, user.ID, err)
+			return s.text("reply.approveFailed", truncateError(err.Error()))
+		}
+		clickLog.Infof("approved Build %q for %s (%s)", ref.id, user.Username, user.ID)
+		return s.text("reply.approved", ref.id)
+	}
+}
+
+// mayClick reports whether the member who clicked has one of the buttonRoles, if any are configured.
+func (s *discordNotifier) mayClick(in *interaction) bool {
+	if len(s.buttonRoles) == 0 {
+		return true
+	}
+	if in.Member == nil {
+		return false
+	}
+	for _, have := range in.Member.Roles {
+		for _, want := range s.buttonRoles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cloudBuildREST calls the Cloud Build REST API with the notifier's default credentials.
+type cloudBuildREST struct {
+	base string
+
+	once   sync.Once
+	client *http.Client
+	err    error
+}
+
+func (c *cloudBuildREST) retry(ctx context.Context, b buildRef) error {
+	return c.call(ctx, b, "retry", map[string]string{"name": b.name(), "projectId": b.project, "id": b.id})
+}
+
+func (c *cloudBuildREST) approve(ctx context.Context, b buildRef, comment string) error {
+	return c.call(ctx, b, "approve", map[string]interface{}{
+		"approvalResult": map[string]string{"decision": "APPROVED", "comment": comment},
+	})
+}
+
+func (c *cloudBuildREST) call(ctx context.Context, b buildRef, method string, body interface{}) error {
+	// The client is created on the first click so that SetUp doesn't need credentials.
+	c.once.Do(func() { c.client, c.err = google.DefaultClient(context.Background(), cloudPlatformScope) })
+	if c.err != nil {
+		return fmt.Errorf("failed to create Cloud Build API client: %w", c.err)
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %w", method, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+"/v1/"+b.name()+":"+method, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to %s Build %q: %w", method, b.id, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to %s Build %q: Cloud Build API returned status %d: %s", method, b.id, resp.StatusCode, truncateError(string(msg)))
+	}
+	return nil
+}
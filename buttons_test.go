@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+type fakeActions struct {
+	calls []string
+	err   error
+}
+
+func (f *fakeActions) retry(_ context.Context, b buildRef) error {
+	f.calls = append(f.calls, "retry "+b.name())
+	return f.err
+}
+
+func (f *fakeActions) approve(_ context.Context, b buildRef, comment string) error {
+	f.calls = append(f.calls, "approve "+b.name()+" "+comment)
+	return f.err
+}
+
+func TestComponents(t *testing.T) {
+	n := &discordNotifier{buttons: map[string]bool{buttonRetry: true, buttonApprove: true}}
+	failed := &cbpb.Build{Id: "b1", ProjectId: "p", Status: cbpb.Build_FAILURE, Name: "projects/p/locations/us-central1/builds/b1"}
+	pending := &cbpb.Build{Id: "b2", ProjectId: "p", Status: cbpb.Build_PENDING, Approval: &cbpb.BuildApproval{State: cbpb.BuildApproval_PENDING}}
+
+	want := []actionRow{{Type: componentActionRow, Components: []button{
+		{Type: componentButton, Style: buttonStylePrimary, Label: "Retry build", CustomID: "retry:p:us-central1:b1"},
+	}}}
+	if diff := cmp.Diff(want, n.components(failed)); diff != "" {
+		t.Errorf("failed Build components diff: (-want +got):\n%s", diff)
+	}
+	want = []actionRow{{Type: componentActionRow, Components: []button{
+		{Type: componentButton, Style: buttonStyleSuccess, Label: "Approve", CustomID: "approve:p::b2"},
+	}}}
+	if diff := cmp.Diff(want, n.components(pending)); diff != "" {
+		t.Errorf("pending Build components diff: (-want +got):\n%s", diff)
+	}
+	if got := n.components(testBuild()); got != nil {
+		t.Errorf("successful Build components = %+v, want none", got)
+	}
+	n.buttons = map[string]bool{buttonApprove: true}
+	if got := n.components(failed); got != nil {
+		t.Errorf("components without the retry button = %+v, want none", got)
+	}
+}
+
+func TestParseCustomID(t *testing.T) {
+	ref := buildRef{project: "p", region: "europe-west1", id: "b"}
+	action, got, err := parseCustomID(customID(buttonRetry, ref))
+	if err != nil || action != buttonRetry || got != ref {
+		t.Errorf("parseCustomID(customID(%q, %+v)) = %q, %+v, %v", buttonRetry, ref, action, got, err)
+	}
+	if got.name() != "projects/p/locations/europe-west1/builds/b" {
+		t.Errorf("name() = %q", got.name())
+	}
+	for _, id := range []string{"", "retry", "retry:p:b", "retry::r:b", "retry:p:r:"} {
+		if _, _, err := parseCustomID(id); err == nil {
+			t.Errorf("parseCustomID(%q) succeeded, want error", id)
+		}
+	}
+}
+
+func TestGetButtonsErrors(t *testing.T) {
+	key := strings.Repeat("ab", ed25519.PublicKeySize)
+	bot := map[interface{}]interface{}{"secretRef": "bot-token"}
+	for _, delivery := range []map[string]interface{}{
+		{buttonsField: []interface{}{"deploy"}, botTokenSecretName: bot, interactionPublicKeyField: key},
+		{buttonsField: []interface{}{buttonRetry}, interactionPublicKeyField: key},
+		{buttonsField: []interface{}{buttonRetry}, botTokenSecretName: bot},
+		{buttonsField: []interface{}{buttonRetry}, botTokenSecretName: bot, interactionPublicKeyField: "abcd"},
+	} {
+		if err := new(discordNotifier).getButtons(delivery); err == nil {
+			t.Errorf("getButtons(%v) succeeded, want error", delivery)
+		}
+	}
+}
+
+func TestBotDeliveryButtons(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusOK, `{"id": "1"}`)
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := newBotTestConfig(map[string]interface{}{
+		botTokenSecretName:        map[interface{}]interface{}{"secretRef": "bot-token"},
+		channelIDField:            "123456789",
+		buttonsField:              []interface{}{buttonRetry},
+		interactionPublicKeyField: hex.EncodeToString(pub),
+	})
+	n := new(discordNotifier)
+	if err := n.SetUp(context.Background(), cfg, fakeSecretGetter{"projects/p/secrets/bot-token/versions/latest": "s3cret"}, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	n.sinks[0].(*botSink).apiBase = srv.URL
+
+	build := testBuild()
+	build.Status = cbpb.Build_FAILURE
+	if err := n.SendNotification(context.Background(), build); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	var msg discordMessage
+	if err := json.Unmarshal(reqs()[0].body, &msg); err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	if len(msg.Components) != 1 || msg.Components[0].Components[0].CustomID != "retry:my-project-id::some-build-id" {
+		t.Errorf("got components %+v, want a retry button", msg.Components)
+	}
+}
+
+// signedInteraction returns a request carrying the interaction, signed with the key.
+func signedInteraction(t *testing.T, key ed25519.PrivateKey, in string) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, interactionsPath, strings.NewReader(in))
+	ts := "1600000000"
+	r.Header.Set("X-Signature-Timestamp", ts)
+	r.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(key, []byte(ts+in))))
+	return r
+}
+
+func TestInteractionHandler(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	click := func(id string, roles ...string) string {
+		in := map[string]interface{}{
+			"type":   interactionMessageComponent,
+			"data":   map[string]string{"custom_id": id},
+			"member": map[string]interface{}{"roles": roles, "user": map[string]string{"id": "42", "username": "alice"}},
+		}
+		b, _ := json.Marshal(in)
+		return string(b)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		roles     []string
+		messages  catalog
+		actionErr error
+		req       *http.Request
+		wantCode  int
+		wantReply string
+		wantCalls []string
+	}{{
+		name:     "ping",
+		req:      signedInteraction(t, priv, `{"type": 1}`),
+		wantCode: http.StatusOK,
+	}, {
+		name: "bad signature",
+		req: func() *http.Request {
+			r := signedInteraction(t, priv, `{"type": 1}`)
+			r.Header.Set("X-Signature-Timestamp", "1600000001")
+			return r
+		}(),
+		wantCode: http.StatusUnauthorized,
+	}, {
+		name:      "retry",
+		req:       signedInteraction(t, priv, click("retry:p::b")),
+		wantCode:  http.StatusOK,
+		wantReply: "🔁 Retrying build b.",
+		wantCalls: []string{"retry projects/p/builds/b"},
+	}, {
+		name:      "approve",
+		req:       signedInteraction(t, priv, click("approve:p:us-east1:b")),
+		wantCode:  http.StatusOK,
+		wantReply: "👍 Approved build b.",
+		wantCalls: []string{"approve projects/p/locations/us-east1/builds/b Approved from Discord by alice"},
+	}, {
+		name:      "approve in french",
+		messages:  french,
+		req:       signedInteraction(t, priv, click("approve:p:us-east1:b")),
+		wantCode:  http.StatusOK,
+		wantReply: "👍 Build b approuvé.",
+		wantCalls: []string{"approve projects/p/locations/us-east1/builds/b Approuvé depuis Discord par alice"},
+	}, {
+		name:      "action fails",
+		actionErr: errors.New("permission denied"),
+		req:       signedInteraction(t, priv, click("retry:p::b")),
+		wantCode:  http.StatusOK,
+		wantReply: "❌ Failed to retry the build: permission denied",
+		wantCalls: []string{"retry projects/p/builds/b"},
+	}, {
+		name:      "missing role",
+		roles:     []string{"100"},
+		req:       signedInteraction(t, priv, click("retry:p::b", "200")),
+		wantCode:  http.StatusOK,
+		wantReply: "🚫 You don't have a role allowed to use this button.",
+	}, {
+		name:      "allowed role",
+		roles:     []string{"100"},
+		req:       signedInteraction(t, priv, click("retry:p::b", "200", "100")),
+		wantCode:  http.StatusOK,
+		wantReply: "🔁 Retrying build b.",
+		wantCalls: []string{"retry projects/p/builds/b"},
+	}, {
+		name:      "disabled button",
+		req:       signedInteraction(t, priv, click("deploy:p::b")),
+		wantCode:  http.StatusOK,
+		wantReply: "⚠️ Unknown button.",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			actions := &fakeActions{err: tc.actionErr}
+			n := &discordNotifier{
+				buttons:        map[string]bool{buttonRetry: true, buttonApprove: true},
+				interactionKey: pub,
+				buttonRoles:    tc.roles,
+				actions:        actions,
+				messages:       tc.messages,
+			}
+			w := httptest.NewRecorder()
+			interactionHandler{n}.ServeHTTP(w, tc.req)
+			if w.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d", w.Code, tc.wantCode)
+			}
+			if diff := cmp.Diff(tc.wantCalls, actions.calls); diff != "" {
+				t.Errorf("actions diff: (-want +got):\n%s", diff)
+			}
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+			var resp interactionResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response %q: %v", w.Body.String(), err)
+			}
+			if tc.wantReply == "" {
+				if resp.Type != responsePong {
+					t.Errorf("got response %+v, want a pong", resp)
+				}
+				return
+			}
+			if resp.Type != responseChannelMessage || resp.Data == nil || resp.Data.Flags != messageFlagEphemeral {
+				t.Fatalf("got response %+v, want an ephemeral message", resp)
+			}
+			if resp.Data.Content != tc.wantReply {
+				t.Errorf("got reply %q, want %q", resp.Data.Content, tc.wantReply)
+			}
+		})
+	}
+}
+
+func TestInteractionHandlerUnconfigured(t *testing.T) {
+	w := httptest.NewRecorder()
+	interactionHandler{new(discordNotifier)}.ServeHTTP(w, httptest.NewRequest(http.MethodPost, interactionsPath, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want 404", w.Code)
+	}
+}
+
+func TestCloudBuildREST(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusOK, `{"name": "operations/1"}`)
+	c := &cloudBuildREST{base: srv.URL, client: http.DefaultClient}
+	c.once.Do(func() {})
+	ref := buildRef{project: "p", region: "us-east1", id: "b"}
+	if err := c.approve(context.Background(), ref, "ok"); err != nil {
+		t.Fatalf("approve failed: %v", err)
+	}
+	got := reqs()
+	if got[0].path != "/v1/projects/p/locations/us-east1/builds/b:approve" {
+		t.Errorf("got path %q", got[0].path)
+	}
+	if want := `{"approvalResult":{"comment":"ok","decision":"APPROVED"}}`; !bytes.Equal(got[0].body, []byte(want)) {
+		t.Errorf("got body %s, want %s", got[0].body, want)
+	}
+
+	srv, _ = recordingServer(t, http.StatusForbidden, `{"error": "denied"}`)
+	c.base = srv.URL
+	if err := c.retry(context.Background(), ref); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("retry error = %v, want the 403", err)
+	}
+}
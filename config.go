@@ -0,0 +1,198 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	"gopkg.in/yaml.v2"
+)
+
+// decodeConfig strictly decodes a notifier config YAML, as notifiers.Main does when it starts.
+func decodeConfig(r io.Reader) (*notifiers.Config, error) {
+	cfg := new(notifiers.Config)
+	dcd := yaml.NewDecoder(r)
+	dcd.SetStrict(true)
+	if err := dcd.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+	if cfg.Spec == nil || cfg.Spec.Notification == nil {
+		return nil, errors.New("expected config.spec.notification to be present")
+	}
+	return cfg, nil
+}
+
+// getDuration returns the optional duration field (e.g. `30s`) from the given delivery config.
+// A missing field yields a zero duration.
+func getDuration(delivery map[string]interface{}, field string) (time.Duration, error) {
+	v, ok := delivery[field]
+	if !ok {
+		return 0, nil
+	}
+	str, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("expected delivery config field %q to be a duration string, got %T", field, v)
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse delivery config field %q as a duration: %w", field, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("expected delivery config field %q to be non-negative, got %v", field, d)
+	}
+	return d, nil
+}
+
+// getBool returns the optional boolean field from the given delivery config.
+// A missing field yields false.
+func getBool(delivery map[string]interface{}, field string) (bool, error) {
+	v, ok := delivery[field]
+	if !ok {
+		return false, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected delivery config field %q to be a boolean, got %T", field, v)
+	}
+	return b, nil
+}
+
+// getInt returns the optional integer field from the given delivery config.
+// A missing field yields zero.
+func getInt(delivery map[string]interface{}, field string) (int, error) {
+	v, ok := delivery[field]
+	if !ok {
+		return 0, nil
+	}
+	i, ok := v.(int)
+	if !ok {
+		return 0, fmt.Errorf("expected delivery config field %q to be an integer, got %T", field, v)
+	}
+	return i, nil
+}
+
+// getString returns the optional string field from the given delivery config.
+// A missing field yields the empty string.
+func getString(delivery map[string]interface{}, field string) (string, error) {
+	v, ok := delivery[field]
+	if !ok {
+		return "", nil
+	}
+	str, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("expected delivery config field %q to be a string, got %T", field, v)
+	}
+	return str, nil
+}
+
+// getStringMap returns the optional string-to-string map field from the given delivery config.
+// A missing field yields a nil map.
+func getStringMap(delivery map[string]interface{}, field string) (map[string]string, error) {
+	v, ok := delivery[field]
+	if !ok {
+		return nil, nil
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected delivery config field %q to be a map, got %T", field, v)
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		ks, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected keys of delivery config field %q to be strings, got %T", field, k)
+		}
+		vs, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected value of %q in delivery config field %q to be a string, got %T", ks, field, v)
+		}
+		out[ks] = vs
+	}
+	return out, nil
+}
+
+// getSecret resolves the `secretRef` in the given config field to its secret value.
+func getSecret(ctx context.Context, sg notifiers.SecretGetter, secrets []*notifiers.Secret, delivery map[string]interface{}, field string) (string, error) {
+	ref, err := notifiers.GetSecretRef(delivery, field)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Secret ref from delivery config (%v) field %q: %w", delivery, field, err)
+	}
+	resource, err := notifiers.FindSecretResourceName(secrets, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to find Secret for ref %q: %w", ref, err)
+	}
+	val, err := sg.GetSecret(ctx, resource)
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s secret: %w", field, err)
+	}
+	return val, nil
+}
+
+// getSecretOrString returns the optional field, given either as a string or as a `secretRef` map
+// that is resolved to its secret value. A missing field yields the empty string.
+func getSecretOrString(ctx context.Context, sg notifiers.SecretGetter, secrets []*notifiers.Secret, delivery map[string]interface{}, field string) (string, error) {
+	v, ok := delivery[field]
+	if !ok {
+		return "", nil
+	}
+	if _, ok := v.(map[interface{}]interface{}); ok {
+		return getSecret(ctx, sg, secrets, delivery, field)
+	}
+	return getString(delivery, field)
+}
+
+// toStringMap converts a nested YAML map into the map[string]interface{} form of the delivery config.
+func toStringMap(v interface{}) (map[string]interface{}, error) {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a map, got %T", v)
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		ks, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected map keys to be strings, got %T", k)
+		}
+		out[ks] = v
+	}
+	return out, nil
+}
+
+// getStringSlice returns the optional list-of-strings field from the given delivery config.
+// A missing field yields a nil slice.
+func getStringSlice(delivery map[string]interface{}, field string) ([]string, error) {
+	v, ok := delivery[field]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected delivery config field %q to be a list, got %T", field, v)
+	}
+	out := make([]string, 0, len(list))
+	for i, item := range list {
+		str, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected %s[%d] to be a string, got %T", field, i, item)
+		}
+		out = append(out, str)
+	}
+	return out, nil
+}
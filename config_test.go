@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetDuration(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		delivery map[string]interface{}
+		want     time.Duration
+		wantErr  bool
+	}{{
+		name:     "missing",
+		delivery: map[string]interface{}{},
+	}, {
+		name:     "valid",
+		delivery: map[string]interface{}{"d": "1m30s"},
+		want:     90 * time.Second,
+	}, {
+		name:     "not a string",
+		delivery: map[string]interface{}{"d": 5},
+		wantErr:  true,
+	}, {
+		name:     "unparseable",
+		delivery: map[string]interface{}{"d": "five seconds"},
+		wantErr:  true,
+	}, {
+		name:     "negative",
+		delivery: map[string]interface{}{"d": "-1s"},
+		wantErr:  true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := getDuration(tc.delivery, "d")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("getDuration got error %v, want error: %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("getDuration got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetBool(t *testing.T) {
+	delivery := map[string]interface{}{"on": true, "off": false, "bad": "yes"}
+	for field, want := range map[string]bool{"on": true, "off": false, "missing": false} {
+		got, err := getBool(delivery, field)
+		if err != nil {
+			t.Errorf("getBool(%q) failed: %v", field, err)
+		}
+		if got != want {
+			t.Errorf("getBool(%q) got %v, want %v", field, got, want)
+		}
+	}
+	if _, err := getBool(delivery, "bad"); err == nil {
+		t.Error("getBool succeeded on a non-boolean field, want error")
+	}
+}
+
+func TestGetInt(t *testing.T) {
+	delivery := map[string]interface{}{"five": 5, "bad": "5"}
+	for field, want := range map[string]int{"five": 5, "missing": 0} {
+		got, err := getInt(delivery, field)
+		if err != nil {
+			t.Errorf("getInt(%q) failed: %v", field, err)
+		}
+		if got != want {
+			t.Errorf("getInt(%q) got %v, want %v", field, got, want)
+		}
+	}
+	if _, err := getInt(delivery, "bad"); err == nil {
+		t.Error("getInt succeeded on a non-integer field, want error")
+	}
+}
+
+func TestGetString(t *testing.T) {
+	delivery := map[string]interface{}{"s": "value", "bad": 42}
+	if got, err := getString(delivery, "s"); err != nil || got != "value" {
+		t.Errorf("getString got (%q, %v), want (%q, nil)", got, err, "value")
+	}
+	if got, err := getString(delivery, "missing"); err != nil || got != "" {
+		t.Errorf("getString got (%q, %v) for a missing field, want (\"\", nil)", got, err)
+	}
+	if _, err := getString(delivery, "bad"); err == nil {
+		t.Error("getString succeeded on a non-string field, want error")
+	}
+}
+
+func TestGetStringMap(t *testing.T) {
+	delivery := map[string]interface{}{
+		"m":        map[interface{}]interface{}{"a": "1", "b": "2"},
+		"notAMap":  "a=1",
+		"badKey":   map[interface{}]interface{}{1: "1"},
+		"badValue": map[interface{}]interface{}{"a": 1},
+	}
+	got, err := getStringMap(delivery, "m")
+	if err != nil {
+		t.Fatalf("getStringMap failed: %v", err)
+	}
+	if diff := cmp.Diff(map[string]string{"a": "1", "b": "2"}, got); diff != "" {
+		t.Errorf("getStringMap got unexpected diff: %s", diff)
+	}
+	if got, err := getStringMap(delivery, "missing"); err != nil || got != nil {
+		t.Errorf("getStringMap got (%v, %v) for a missing field, want (nil, nil)", got, err)
+	}
+	for _, field := range []string{"notAMap", "badKey", "badValue"} {
+		if _, err := getStringMap(delivery, field); err == nil {
+			t.Errorf("getStringMap(%q) succeeded, want error", field)
+		}
+	}
+}
+
+func TestGetStringSlice(t *testing.T) {
+	delivery := map[string]interface{}{
+		"l":       []interface{}{"a", "b"},
+		"notList": "a",
+		"badItem": []interface{}{"a", 1},
+	}
+	got, err := getStringSlice(delivery, "l")
+	if err != nil {
+		t.Fatalf("getStringSlice failed: %v", err)
+	}
+	if diff := cmp.Diff([]string{"a", "b"}, got); diff != "" {
+		t.Errorf("getStringSlice got unexpected diff: %s", diff)
+	}
+	if got, err := getStringSlice(delivery, "missing"); err != nil || got != nil {
+		t.Errorf("getStringSlice got (%v, %v) for a missing field, want (nil, nil)", got, err)
+	}
+	for _, field := range []string{"notList", "badItem"} {
+		if _, err := getStringSlice(delivery, field); err == nil {
+			t.Errorf("getStringSlice(%q) succeeded, want error", field)
+		}
+	}
+}
+
+func TestGetSecretOrString(t *testing.T) {
+	secrets := []*notifiers.Secret{{LocalName: "proxy", ResourceName: "projects/p/secrets/proxy/versions/latest"}}
+	sg := fakeSecretGetter{"projects/p/secrets/proxy/versions/latest": "http://proxy.example.com"}
+	for _, tc := range []struct {
+		name     string
+		delivery map[string]interface{}
+		want     string
+		wantErr  bool
+	}{
+		{name: "missing", delivery: map[string]interface{}{}},
+		{name: "inline", delivery: map[string]interface{}{"p": "http://inline.example.com"}, want: "http://inline.example.com"},
+		{name: "secret", delivery: map[string]interface{}{"p": map[interface{}]interface{}{"secretRef": "proxy"}}, want: "http://proxy.example.com"},
+		{name: "unknown secret", delivery: map[string]interface{}{"p": map[interface{}]interface{}{"secretRef": "other"}}, wantErr: true},
+		{name: "wrong type", delivery: map[string]interface{}{"p": 1}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := getSecretOrString(context.Background(), sg, secrets, tc.delivery, "p")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("getSecretOrString got error %v, want error: %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("getSecretOrString got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
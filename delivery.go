@@ -0,0 +1,313 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// defaultMaxAttempts is the number of times a webhook POST is tried before giving up,
+	// unless maxAttempts says otherwise.
+	defaultMaxAttempts = 3
+	// defaultRetryDelay is the pause before the first retry; it doubles on each later one.
+	defaultRetryDelay = 500 * time.Millisecond
+	// maxRetryDelay caps the exponential backoff between attempts.
+	maxRetryDelay = 5 * time.Second
+	// defaultHTTPTimeout bounds each HTTP request when httpTimeout is not configured.
+	defaultHTTPTimeout = 10 * time.Second
+	// maxErrorBodyLength caps how much of a failed response body is included in its error.
+	maxErrorBodyLength = 200
+	// maxRateLimitWait caps the total time spent honoring Discord rate limits for one message.
+	maxRateLimitWait = 30 * time.Second
+)
+
+// postWebhook POSTs the JSON payload to the webhook with the given query parameters
+// and returns the response body. Attempts rejected with a retryable outcome are retried.
+func (s *discordNotifier) postWebhook(ctx context.Context, build *cbpb.Build, webhookURL string, query url.Values, payload []byte) ([]byte, error) {
+	return s.sendJSON(ctx, build, http.MethodPost, webhookURL, query, nil, payload)
+}
+
+// sendJSON is postWebhook with another HTTP method, such as PATCH for message edits, and extra
+// request headers, such as bot authorization.
+func (s *discordNotifier) sendJSON(ctx context.Context, build *cbpb.Build, method, target string, query url.Values, header http.Header, payload []byte) ([]byte, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse webhook URL: %w", stripURL(err))
+	}
+	if len(query) > 0 {
+		q := u.Query()
+		for k, vs := range query {
+			for _, v := range vs {
+				q.Add(k, v)
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	ctx, span := s.startSpan(ctx, "webhook "+method, build)
+	defer span.End()
+	start := time.Now()
+	defer func() { notifierMetrics.observeDelivery(time.Since(start)) }()
+
+	var rateLimited time.Duration
+	for attempt := 1; ; attempt++ {
+		if err := s.limits.wait(ctx, target); err != nil {
+			if derr := s.deadlineError(ctx, build); derr != nil {
+				return nil, derr
+			}
+			return nil, err
+		}
+		status, respHeader, body, err := s.doRequest(ctx, build, method, u.String(), header, payload)
+		recordAttempt(span, attempt, status, s.redactError(build, err))
+		s.limits.update(target, respHeader)
+		if err != nil {
+			if derr := s.deadlineError(ctx, build); derr != nil {
+				return nil, derr
+			}
+		}
+		retry := s.shouldRetry(ctx, status, err)
+		if retry && attempt >= s.attempts() {
+			if err == nil {
+				err = fmt.Errorf("%w after %d attempts", statusError(status, body), attempt)
+			}
+			return body, err
+		}
+		if !retry {
+			if err == nil && (status < 200 || status > 299) {
+				err = statusError(status, body)
+			}
+			return body, err
+		}
+
+		wait := s.backoff(attempt)
+		if status == http.StatusTooManyRequests {
+			if ra, ok := retryAfter(respHeader, body); ok {
+				wait = ra
+			}
+			if rateLimited+wait > maxRateLimitWait {
+				return body, fmt.Errorf("webhook rate limited for Build %q: waiting %v would exceed the %v limit", build.Id, wait, maxRateLimitWait)
+			}
+			rateLimited += wait
+		}
+
+		buildLog(build).withResponseCode(status).Warningf("retrying webhook in %v after attempt %d (error: %v)", wait, attempt, s.redactError(build, err))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			if derr := s.deadlineError(ctx, build); derr != nil {
+				return nil, derr
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// statusError describes a non-2xx webhook response. Discord's JSON errors are summarized by their code,
+// message and invalid fields; other bodies are included as a truncated snippet.
+func statusError(status int, body []byte) error {
+	if msg := discordErrorMessage(body); msg != "" {
+		return fmt.Errorf("webhook returned status %d: %s", status, truncateError(msg))
+	}
+	return fmt.Errorf("webhook returned status %d: %q", status, truncateError(string(body)))
+}
+
+func truncateError(s string) string {
+	if len(s) > maxErrorBodyLength {
+		return strings.ToValidUTF8(s[:maxErrorBodyLength], "") + "…"
+	}
+	return s
+}
+
+// discordError is the JSON error object Discord returns, e.g. for an invalid embed:
+//
+//	{"code": 50035, "message": "Invalid Form Body", "errors": {"embeds": {"0": {"description": {"_errors": [...]}}}}}
+type discordError struct {
+	Code    int                    `json:"code"`
+	Message string                 `json:"message"`
+	Errors  map[string]interface{} `json:"errors"`
+}
+
+// discordErrorMessage renders a Discord error body as e.g.
+// `Discord error 50035: Invalid Form Body (embeds.0.description: Must be 4096 or fewer in length.)`,
+// or returns "" if the body isn't a Discord error.
+func discordErrorMessage(body []byte) string {
+	var de discordError
+	if err := json.Unmarshal(body, &de); err != nil || de.Message == "" {
+		return ""
+	}
+	msg := fmt.Sprintf("Discord error %d: %s", de.Code, de.Message)
+	var details []string
+	collectFieldErrors("", de.Errors, &details)
+	if len(details) > 0 {
+		msg += " (" + strings.Join(details, "; ") + ")"
+	}
+	return msg
+}
+
+// collectFieldErrors flattens Discord's nested field errors into `path: message` entries, sorted by path.
+func collectFieldErrors(path string, errs map[string]interface{}, out *[]string) {
+	keys := make([]string, 0, len(errs))
+	for k := range errs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "_errors" {
+			list, _ := errs[k].([]interface{})
+			for _, item := range list {
+				if m, ok := item.(map[string]interface{}); ok {
+					*out = append(*out, fmt.Sprintf("%s: %v", path, m["message"]))
+				}
+			}
+			continue
+		}
+		nested, ok := errs[k].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		p := k
+		if path != "" {
+			p = path + "." + k
+		}
+		collectFieldErrors(p, nested, out)
+	}
+}
+
+// deadlineError returns a descriptive error if the notificationTimeout deadline has passed.
+func (s *discordNotifier) deadlineError(ctx context.Context, build *cbpb.Build) error {
+	if s.notificationTimeout > 0 && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("notification for Build %q exceeded the %v timeout: %w", build.Id, s.notificationTimeout, ctx.Err())
+	}
+	return nil
+}
+
+// doRequest makes a single request with the payload and returns the response status code, headers and body.
+func (s *discordNotifier) doRequest(ctx context.Context, build *cbpb.Build, method, u string, header http.Header, payload []byte) (int, http.Header, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewBuffer(payload))
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("failed to create webhook request: %w", stripURL(err))
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		// The *url.Error names the URL, which carries the webhook token.
+		return 0, nil, nil, s.redactError(build, fmt.Errorf("webhook %s failed: %w", method, stripURL(err)))
+	}
+	defer resp.Body.Close()
+	notifierMetrics.response(resp.StatusCode)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, resp.Header, nil, fmt.Errorf("failed to read webhook response: %w", err)
+	}
+	// The URL is left out since webhook URLs embed their token.
+	buildLog(build).withResponseCode(resp.StatusCode).Infof("webhook %s returned status %d", method, resp.StatusCode)
+	s.logPayload(build, "webhook response", body)
+	return resp.StatusCode, resp.Header, body, nil
+}
+
+// retryAfter returns how long Discord asked us to wait before retrying a rate-limited request,
+// from the Retry-After or X-RateLimit-Reset-After header or, failing that, the `retry_after`
+// field (in seconds) of the JSON body.
+func retryAfter(header http.Header, body []byte) (time.Duration, bool) {
+	if v := header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs >= 0 {
+			return time.Duration(secs * float64(time.Second)), true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			if d := time.Until(t); d > 0 {
+				return d, true
+			}
+			return 0, true
+		}
+	}
+	if d, ok := resetAfter(header); ok {
+		return d, true
+	}
+	var rl struct {
+		RetryAfter *float64 `json:"retry_after"`
+	}
+	if err := json.Unmarshal(body, &rl); err == nil && rl.RetryAfter != nil && *rl.RetryAfter >= 0 {
+		return time.Duration(*rl.RetryAfter * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+// shouldRetry reports whether a webhook attempt with the given outcome may be safely retried.
+// Rate limits, server errors and failures to reach Discord mean it did not accept the message.
+// A client-side timeout is ambiguous (Discord may have posted it), so it is only retried when
+// retryOnTimeout is set.
+func (s *discordNotifier) shouldRetry(ctx context.Context, status int, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if status == http.StatusTooManyRequests || status >= 500 {
+		return true
+	}
+	if err == nil || status != 0 {
+		// Delivered, or rejected with a non-retryable status.
+		return false
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return s.retryOnTimeout
+	}
+	return true
+}
+
+// attempts returns the configured maximum number of attempts per message.
+func (s *discordNotifier) attempts() int {
+	if s.maxAttempts > 0 {
+		return s.maxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+// backoff returns the pause after the given attempt: retryDelay doubled for each earlier retry, capped at maxRetryDelay.
+func (s *discordNotifier) backoff(attempt int) time.Duration {
+	d := s.retryDelay
+	for i := 1; i < attempt && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d
+}
+
+func (s *discordNotifier) httpClient() *http.Client {
+	if s.client != nil {
+		return s.client
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func testBuild() *cbpb.Build {
+	return &cbpb.Build{
+		ProjectId:     "my-project-id",
+		Id:            "some-build-id",
+		Status:        cbpb.Build_SUCCESS,
+		LogUrl:        "https://some.example.com/log/url?foo=bar",
+		Substitutions: map[string]string{"_APP_NAME": "my-app"},
+	}
+}
+
+func testBuildWithoutApp() *cbpb.Build {
+	b := testBuild()
+	delete(b.Substitutions, "_APP_NAME")
+	return b
+}
+
+// sequenceServer replies to the nth request with the nth handler, repeating the last one.
+func sequenceServer(t *testing.T, handlers ...http.HandlerFunc) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		i := int(atomic.AddInt32(&calls, 1)) - 1
+		if i >= len(handlers) {
+			i = len(handlers) - 1
+		}
+		handlers[i](w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func respondWith(status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	}
+}
+
+func hang(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-r.Context().Done():
+	case <-time.After(5 * time.Second):
+	}
+}
+
+func TestRetryOnRateLimit(t *testing.T) {
+	srv, calls := sequenceServer(t, respondWith(http.StatusTooManyRequests), respondWith(http.StatusNoContent))
+	n := setUpTestNotifier(t, srv.URL, nil)
+	n.retryDelay = time.Millisecond
+
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Errorf("got %d webhook attempts, want 2", got)
+	}
+}
+
+func TestTimeoutNotRetriedByDefault(t *testing.T) {
+	srv, calls := sequenceServer(t, hang, respondWith(http.StatusNoContent))
+	n := setUpTestNotifier(t, srv.URL, nil)
+	n.retryDelay = time.Millisecond
+	n.client = &http.Client{Timeout: 50 * time.Millisecond}
+
+	if err := n.SendNotification(context.Background(), testBuild()); err == nil {
+		t.Error("SendNotification succeeded after a timeout, want error")
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("got %d webhook attempts, want 1", got)
+	}
+}
+
+func TestRetryOnTimeout(t *testing.T) {
+	srv, calls := sequenceServer(t, hang, respondWith(http.StatusNoContent))
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{retryOnTimeoutField: true})
+	n.retryDelay = time.Millisecond
+	n.client = &http.Client{Timeout: 50 * time.Millisecond}
+
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Errorf("got %d webhook attempts, want 2", got)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	srv, calls := sequenceServer(t, respondWith(http.StatusBadGateway))
+	n := setUpTestNotifier(t, srv.URL, nil)
+	n.retryDelay = time.Millisecond
+
+	if err := n.SendNotification(context.Background(), testBuild()); err == nil {
+		t.Error("SendNotification succeeded after every attempt returned 502, want error")
+	}
+	if got := atomic.LoadInt32(calls); got != defaultMaxAttempts {
+		t.Errorf("got %d webhook attempts, want %d", got, defaultMaxAttempts)
+	}
+}
+
+func TestRetryOnConnectionError(t *testing.T) {
+	dropConnection := func(w http.ResponseWriter, _ *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack connection: %v", err)
+			return
+		}
+		conn.Close()
+	}
+	srv, calls := sequenceServer(t, dropConnection, respondWith(http.StatusNoContent))
+	n := setUpTestNotifier(t, srv.URL, nil)
+	n.retryDelay = time.Millisecond
+
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Errorf("got %d webhook attempts, want 2", got)
+	}
+}
+
+func TestNoRetryOnSuccess(t *testing.T) {
+	srv, calls := sequenceServer(t, respondWith(http.StatusNoContent))
+	n := setUpTestNotifier(t, srv.URL, nil)
+
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("got %d webhook attempts, want 1", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	n := &discordNotifier{retryDelay: defaultRetryDelay}
+	for attempt, want := range map[int]time.Duration{
+		1:  500 * time.Millisecond,
+		2:  time.Second,
+		3:  2 * time.Second,
+		4:  4 * time.Second,
+		5:  maxRetryDelay,
+		20: maxRetryDelay,
+	} {
+		if got := n.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) got %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	rateLimited := func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "0.05")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.05, "global": false}`))
+	}
+	srv, calls := sequenceServer(t, rateLimited, respondWith(http.StatusNoContent))
+	n := setUpTestNotifier(t, srv.URL, nil)
+	// A long backoff shows the Retry-After value is used instead.
+	n.retryDelay = time.Minute
+
+	start := time.Now()
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Errorf("got %d webhook attempts, want 2", got)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("SendNotification took %v, want it to wait about 50ms", elapsed)
+	}
+}
+
+func TestRateLimitWaitCapped(t *testing.T) {
+	rateLimited := func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+	srv, calls := sequenceServer(t, rateLimited)
+	n := setUpTestNotifier(t, srv.URL, nil)
+
+	if err := n.SendNotification(context.Background(), testBuild()); err == nil {
+		t.Error("SendNotification succeeded, want an error for an excessive Retry-After")
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("got %d webhook attempts, want 1", got)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		header     string
+		resetAfter string
+		body       string
+		want       time.Duration
+		wantOK     bool
+	}{
+		{name: "header seconds", header: "2", want: 2 * time.Second, wantOK: true},
+		{name: "header fractional", header: "1.5", want: 1500 * time.Millisecond, wantOK: true},
+		{name: "body fallback", body: `{"retry_after": 0.25}`, want: 250 * time.Millisecond, wantOK: true},
+		{name: "bad header uses body", header: "soon", body: `{"retry_after": 1}`, want: time.Second, wantOK: true},
+		{name: "reset after", resetAfter: "0.5", want: 500 * time.Millisecond, wantOK: true},
+		{name: "none", body: `{"message": "nope"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := http.Header{}
+			if tc.header != "" {
+				h.Set("Retry-After", tc.header)
+			}
+			if tc.resetAfter != "" {
+				h.Set("X-RateLimit-Reset-After", tc.resetAfter)
+			}
+			got, ok := retryAfter(h, []byte(tc.body))
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("retryAfter got (%v, %v), want (%v, %v)", got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestSetUpHTTPTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		delivery map[string]interface{}
+		want     time.Duration
+	}{
+		{name: "default", want: defaultHTTPTimeout},
+		{name: "configured", delivery: map[string]interface{}{httpTimeoutField: "3s"}, want: 3 * time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := setUpTestNotifier(t, "https://discord.example.com", tc.delivery)
+			if n.client == nil || n.client.Timeout != tc.want {
+				t.Errorf("got client %+v, want one with a %v timeout", n.client, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiscordErrorMessage(t *testing.T) {
+	for _, tc := range []struct {
+		body string
+		want string
+	}{
+		{body: `{"message": "Unknown Webhook", "code": 10015}`, want: "Discord error 10015: Unknown Webhook"},
+		{
+			body: `{"code": 50035, "message": "Invalid Form Body", "errors": {` +
+				`"username": {"_errors": [{"code": "USERNAME_INVALID", "message": "Username cannot contain \"discord\""}]},` +
+				`"embeds": {"1": {"title": {"_errors": [{"message": "Must be 256 or fewer in length."}]}}}}}`,
+			want: `Discord error 50035: Invalid Form Body (embeds.1.title: Must be 256 or fewer in length.; username: Username cannot contain "discord")`,
+		},
+		{body: `upstream connect error`, want: ""},
+		{body: `{"id": "1"}`, want: ""},
+	} {
+		if got := discordErrorMessage([]byte(tc.body)); got != tc.want {
+			t.Errorf("discordErrorMessage(%s) = %q, want %q", tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestNonSuccessStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{name: "no content", status: http.StatusNoContent},
+		{name: "ok", status: http.StatusOK, body: `{"id": "1"}`},
+		{name: "not found", status: http.StatusNotFound, body: `{"message": "Unknown Webhook", "code": 10015}`, wantErr: "status 404"},
+		{name: "bad request", status: http.StatusBadRequest, body: strings.Repeat("x", 1000), wantErr: "status 400"},
+		{
+			name:    "invalid embed",
+			status:  http.StatusBadRequest,
+			body:    `{"code": 50035, "message": "Invalid Form Body", "errors": {"embeds": {"0": {"description": {"_errors": [{"code": "BASE_TYPE_MAX_LENGTH", "message": "Must be 4096 or fewer in length."}]}}}}}`,
+			wantErr: "status 400: Discord error 50035: Invalid Form Body (embeds.0.description: Must be 4096 or fewer in length.)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, calls := sequenceServer(t, func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			})
+			n := setUpTestNotifier(t, srv.URL, nil)
+
+			err := n.SendNotification(context.Background(), testBuild())
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("SendNotification failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("SendNotification got error %v, want one containing %q", err, tc.wantErr)
+			}
+			if len(err.Error()) > 2*maxErrorBodyLength {
+				t.Errorf("got a %d byte error, want the body snippet truncated", len(err.Error()))
+			}
+			if got := atomic.LoadInt32(calls); got != 1 {
+				t.Errorf("got %d webhook attempts, want 1", got)
+			}
+		})
+	}
+}
+
+func TestConfiguredRetries(t *testing.T) {
+	srv, calls := sequenceServer(t, respondWith(http.StatusBadGateway))
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{
+		maxAttemptsField: 5,
+		retryDelayField:  "1ms",
+	})
+	if n.retryDelay != time.Millisecond {
+		t.Errorf("got retry delay %v, want 1ms", n.retryDelay)
+	}
+
+	if err := n.SendNotification(context.Background(), testBuild()); err == nil {
+		t.Error("SendNotification succeeded after every attempt returned 502, want error")
+	}
+	if got := atomic.LoadInt32(calls); got != 5 {
+		t.Errorf("got %d webhook attempts, want 5", got)
+	}
+}
+
+func TestSetUpInvalidMaxAttempts(t *testing.T) {
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	for name, v := range map[string]interface{}{"negative": -1, "not an int": "3"} {
+		cfg := newTestConfig(map[string]interface{}{maxAttemptsField: v})
+		if err := new(discordNotifier).SetUp(context.Background(), cfg, sg, nil); err == nil {
+			t.Errorf("%s: SetUp succeeded, want error", name)
+		}
+	}
+}
+
+func TestRateLimitBucketExhausted(t *testing.T) {
+	var first time.Time
+	exhausted := func(w http.ResponseWriter, _ *http.Request) {
+		first = time.Now()
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset-After", "0.1")
+		w.WriteHeader(http.StatusNoContent)
+	}
+	var second time.Time
+	ok := func(w http.ResponseWriter, _ *http.Request) {
+		second = time.Now()
+		w.WriteHeader(http.StatusNoContent)
+	}
+	srv, calls := sequenceServer(t, exhausted, ok)
+	n := setUpTestNotifier(t, srv.URL, nil)
+
+	for i := 0; i < 2; i++ {
+		if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+			t.Fatalf("SendNotification failed: %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Fatalf("got %d webhook requests, want 2", got)
+	}
+	if gap := second.Sub(first); gap < 90*time.Millisecond {
+		t.Errorf("second request came %v after the bucket was exhausted, want it to wait for the reset", gap)
+	}
+}
+
+func TestRateLimitBucketHoldsConcurrentSends(t *testing.T) {
+	var r rateLimits
+	h := http.Header{}
+	h.Set("X-RateLimit-Remaining", "0")
+	h.Set("X-RateLimit-Reset-After", "0.1")
+	r.update("https://discord.example.com", h)
+
+	start := time.Now()
+	waited := make(chan time.Duration, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			if err := r.wait(context.Background(), "https://discord.example.com"); err != nil {
+				t.Errorf("wait failed: %v", err)
+			}
+			waited <- time.Since(start)
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if d := <-waited; d < 90*time.Millisecond {
+			t.Errorf("send went out %v after the bucket was exhausted, want it to wait for the reset", d)
+		}
+	}
+	if err := r.wait(context.Background(), "https://discord.example.com"); err != nil {
+		t.Errorf("wait after the reset failed: %v", err)
+	}
+	if _, ok := r.until["https://discord.example.com"]; ok {
+		t.Error("bucket entry kept after the reset passed")
+	}
+}
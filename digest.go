@@ -0,0 +1,389 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	"google.golang.org/api/iterator"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// digestField enables recording finished Builds for a summary posted when digestPath is requested.
+	digestField = "digest"
+	// digestWindowField is how far back a digest looks; it defaults to defaultDigestWindow.
+	digestWindowField = "window"
+	// digestBucketField is an optional `gs://bucket/prefix` location shared by notifier instances.
+	digestBucketField = "bucket"
+	// digestWebhookURLField is an optional `secretRef` to the webhook receiving digests instead of webhookUrl.
+	digestWebhookURLField = "webhookUrl"
+
+	// digestPath is requested on a schedule, e.g. by Cloud Scheduler, to post a digest.
+	digestPath = "/digest"
+
+	defaultDigestWindow = 24 * time.Hour
+	// maxDigestEntries bounds how many Builds the in-memory digest remembers.
+	maxDigestEntries = 10000
+	// maxDigestApps caps the number of per-app lines in a digest.
+	maxDigestApps = 15
+
+	digestColor = 3447003
+)
+
+// digestEntry is the outcome of a finished Build.
+type digestEntry struct {
+	App      string            `json:"app"`
+	Status   cbpb.Build_Status `json:"status"`
+	Duration time.Duration     `json:"duration"`
+	Time     time.Time         `json:"time"`
+}
+
+// digestStore records finished Builds for digests. Entries are keyed by Build event so that a
+// redelivered event is only counted once.
+type digestStore interface {
+	record(ctx context.Context, key string, e digestEntry) error
+	// since returns the entries recorded at or after t, forgetting older ones.
+	since(ctx context.Context, t time.Time) ([]digestEntry, error)
+}
+
+// digest posts periodic summaries of finished Builds.
+type digest struct {
+	window     time.Duration
+	webhookURL string
+	store      digestStore
+}
+
+// getDigest returns the digest configured in the optional digestField map, or nil if it is unset.
+func (s *discordNotifier) getDigest(ctx context.Context, sg notifiers.SecretGetter, secrets []*notifiers.Secret, delivery map[string]interface{}) (*digest, error) {
+	raw, ok := delivery[digestField]
+	if !ok {
+		return nil, nil
+	}
+	cfg, err := toStringMap(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delivery config field %q: %w", digestField, err)
+	}
+	for k := range cfg {
+		switch k {
+		case digestWindowField, digestBucketField, digestWebhookURLField:
+		default:
+			return nil, fmt.Errorf("unknown field %q in delivery config field %q", k, digestField)
+		}
+	}
+
+	d := &digest{webhookURL: s.webhookURL}
+	if d.window, err = getDuration(cfg, digestWindowField); err != nil {
+		return nil, err
+	}
+	if d.window == 0 {
+		d.window = defaultDigestWindow
+	}
+	if _, ok := cfg[digestWebhookURLField]; ok {
+		if d.webhookURL, err = getSecret(ctx, sg, secrets, cfg, digestWebhookURLField); err != nil {
+			return nil, err
+		}
+	}
+	if d.webhookURL == "" {
+		return nil, fmt.Errorf("delivery config field %q requires %q to be set when %q is not", digestField, digestWebhookURLField, webhookURLSecretName)
+	}
+
+	bucket, err := getString(cfg, digestBucketField)
+	if err != nil {
+		return nil, err
+	}
+	if bucket == "" {
+		d.store = newMemoryDigestStore(maxDigestEntries)
+		return d, nil
+	}
+	sc, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client for the digest: %w", err)
+	}
+	if d.store, err = newGCSDigestStore(sc, bucket); err != nil {
+		return nil, fmt.Errorf("invalid delivery config field %q: %w", digestField+"."+digestBucketField, err)
+	}
+	return d, nil
+}
+
+// recordDigest adds a finished Build to the digest. Failures are logged since they shouldn't hold up
+// the Build's own notification.
+func (s *discordNotifier) recordDigest(ctx context.Context, build *cbpb.Build) {
+	if s.digest == nil || !isDoneStatus(build.Status) {
+		return
+	}
+	e := digestEntry{App: s.appName(build), Status: build.Status, Time: s.clock()}
+	if build.StartTime != nil && build.FinishTime != nil {
+		e.Duration = build.FinishTime.AsTime().Sub(build.StartTime.AsTime())
+	}
+	if err := s.digest.store.record(ctx, eventKey(build), e); err != nil {
+		buildLog(build).Warningf("failed to record Build %q for the digest: %v", build.Id, err)
+	}
+}
+
+// postDigest sends a summary of the Builds finished within the digest window.
+func (s *discordNotifier) postDigest(ctx context.Context) error {
+	now := s.clock()
+	entries, err := s.digest.store.since(ctx, now.Add(-s.digest.window))
+	if err != nil {
+		return fmt.Errorf("failed to read digest entries: %w", err)
+	}
+	msg := &discordMessage{
+		Embeds: []embed{{
+			Title:       s.text("title.digest"),
+			Color:       digestColor,
+			Description: s.digestDescription(s.digest.window, entries),
+			Timestamp:   now.UTC().Format(time.RFC3339),
+		}},
+		AvatarURL: s.avatarURL,
+	}
+	payload, err := json.Marshal(withinLimits(msg))
+	if err != nil {
+		return fmt.Errorf("Unable to marshal payload %w", err)
+	}
+	_, err = s.postWebhook(ctx, &cbpb.Build{Id: "digest"}, s.digest.webhookURL, nil, payload)
+	return err
+}
+
+// digestTally counts the outcomes of a group of Builds.
+type digestTally struct {
+	builds, succeeded, failed int
+	slowest                   digestEntry
+}
+
+func (t *digestTally) add(e digestEntry) {
+	t.builds++
+	switch {
+	case e.Status == cbpb.Build_SUCCESS:
+		t.succeeded++
+	case isFailureStatus(e.Status):
+		t.failed++
+	}
+	if e.Duration > t.slowest.Duration {
+		t.slowest = e
+	}
+}
+
+// tally renders the counts of a digestTally, e.g. `42 builds, 38 ✅, 4 ❌`.
+func (s *discordNotifier) tally(t *digestTally) string {
+	return s.textN("digest.counts", t.builds, t.succeeded, t.failed)
+}
+
+// digestDescription summarizes the entries overall and per app, e.g.
+// `Last 24h: 42 builds, 38 ✅, 4 ❌, slowest: backend 12m`.
+func (s *discordNotifier) digestDescription(window time.Duration, entries []digestEntry) string {
+	if len(entries) == 0 {
+		return s.text("digest.none", windowLabel(window))
+	}
+	var total digestTally
+	apps := make(map[string]*digestTally)
+	for _, e := range entries {
+		total.add(e)
+		t, ok := apps[e.App]
+		if !ok {
+			t = new(digestTally)
+			apps[e.App] = t
+		}
+		t.add(e)
+	}
+
+	var b strings.Builder
+	b.WriteString(s.text("digest.summary", windowLabel(window), s.tally(&total)))
+	if total.slowest.Duration > 0 {
+		b.WriteString(s.text("digest.slowest", total.slowest.App, formatDuration(total.slowest.Duration)))
+	}
+	if len(apps) < 2 {
+		return b.String()
+	}
+
+	names := make([]string, 0, len(apps))
+	for name := range apps {
+		names = append(names, name)
+	}
+	// Busiest apps first, then by name.
+	sort.Slice(names, func(i, j int) bool {
+		if apps[names[i]].builds != apps[names[j]].builds {
+			return apps[names[i]].builds > apps[names[j]].builds
+		}
+		return names[i] < names[j]
+	})
+	b.WriteString("\n")
+	for i, name := range names {
+		if i == maxDigestApps {
+			b.WriteString("\n" + s.textN("text.more", len(names)-maxDigestApps))
+			break
+		}
+		b.WriteString("\n" + s.text("detail", name, s.tally(apps[name])))
+	}
+	return b.String()
+}
+
+// windowLabel renders a digest window, using days for multi-day windows, e.g. `24h` or `7d`.
+func windowLabel(window time.Duration) string {
+	day := 24 * time.Hour
+	if window > day && window%day == 0 {
+		return fmt.Sprintf("%dd", window/day)
+	}
+	return formatDuration(window)
+}
+
+// digestHandler posts a digest when requested, so a scheduler can drive it.
+type digestHandler struct {
+	n notifierSource
+}
+
+func (h digestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := h.n.current()
+	if n.digest == nil {
+		http.Error(w, "digest is not configured", http.StatusNotFound)
+		return
+	}
+	if err := n.postDigest(r.Context()); err != nil {
+		notifierLog.Errorf("failed to post digest: %v", err)
+		http.Error(w, "failed to post digest", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// memoryDigestStore keeps digest entries in memory, which is enough when a single notifier instance
+// receives every event and outlives the digest window.
+type memoryDigestStore struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]digestEntry
+	order   []string
+}
+
+func newMemoryDigestStore(max int) *memoryDigestStore {
+	return &memoryDigestStore{max: max, entries: make(map[string]digestEntry)}
+}
+
+func (m *memoryDigestStore) record(_ context.Context, key string, e digestEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[key]; ok {
+		return nil
+	}
+	if len(m.order) >= m.max {
+		delete(m.entries, m.order[0])
+		m.order = m.order[1:]
+	}
+	m.entries[key] = e
+	m.order = append(m.order, key)
+	return nil
+}
+
+func (m *memoryDigestStore) since(_ context.Context, t time.Time) ([]digestEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Entries are recorded in time order, so the expired ones lead.
+	i := 0
+	for ; i < len(m.order) && m.entries[m.order[i]].Time.Before(t); i++ {
+		delete(m.entries, m.order[i])
+	}
+	m.order = m.order[i:]
+	out := make([]digestEntry, 0, len(m.order))
+	for _, k := range m.order {
+		out = append(out, m.entries[k])
+	}
+	return out, nil
+}
+
+// gcsDigestStore keeps each digest entry as a JSON object in a GCS bucket, so that every notifier
+// instance sharing the bucket contributes to a single digest.
+type gcsDigestStore struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+// newGCSDigestStore returns a store writing under a `gs://bucket/prefix` location.
+func newGCSDigestStore(client *storage.Client, location string) (*gcsDigestStore, error) {
+	bucket, prefix, err := parseGCSLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsDigestStore{bucket: client.Bucket(bucket), prefix: prefix}, nil
+}
+
+func (g *gcsDigestStore) record(ctx context.Context, key string, e digestEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal digest entry %q: %w", key, err)
+	}
+	w := g.bucket.Object(g.prefix + key).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	w.ContentType = "application/json"
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write digest entry %q: %w", key, err)
+	}
+	if err := w.Close(); err != nil && !isPreconditionFailed(err) {
+		return fmt.Errorf("failed to write digest entry %q: %w", key, err)
+	}
+	return nil
+}
+
+func (g *gcsDigestStore) since(ctx context.Context, t time.Time) ([]digestEntry, error) {
+	var out []digestEntry
+	it := g.bucket.Objects(ctx, &storage.Query{Prefix: g.prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list digest entries: %w", err)
+		}
+		if attrs.Created.Before(t) {
+			if err := g.bucket.Object(attrs.Name).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+				notifierLog.Warningf("failed to delete expired digest entry %q: %v", attrs.Name, err)
+			}
+			continue
+		}
+		e, err := g.read(ctx, attrs.Name)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func (g *gcsDigestStore) read(ctx context.Context, name string) (digestEntry, error) {
+	var e digestEntry
+	r, err := g.bucket.Object(name).NewReader(ctx)
+	if err != nil {
+		return e, fmt.Errorf("failed to read digest entry %q: %w", name, err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return e, fmt.Errorf("failed to read digest entry %q: %w", name, err)
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		return e, fmt.Errorf("failed to parse digest entry %q: %w", name, err)
+	}
+	return e, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestDigestDescription(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		window  time.Duration
+		entries []digestEntry
+		want    string
+	}{{
+		name:   "no builds",
+		window: 24 * time.Hour,
+		want:   "Last 24h: no builds",
+	}, {
+		name:   "single app",
+		window: 7 * 24 * time.Hour,
+		entries: []digestEntry{
+			{App: "backend", Status: cbpb.Build_SUCCESS, Duration: 2 * time.Minute},
+			{App: "backend", Status: cbpb.Build_TIMEOUT, Duration: 12 * time.Minute},
+		},
+		want: "Last 7d: 2 builds, 1 ✅, 1 ❌, slowest: backend 12m",
+	}, {
+		name:   "several apps",
+		window: 24 * time.Hour,
+		entries: []digestEntry{
+			{App: "frontend", Status: cbpb.Build_SUCCESS, Duration: time.Minute},
+			{App: "backend", Status: cbpb.Build_SUCCESS, Duration: 12 * time.Minute},
+			{App: "backend", Status: cbpb.Build_FAILURE},
+			{App: "worker", Status: cbpb.Build_CANCELLED},
+		},
+		want: `Last 24h: 4 builds, 2 ✅, 1 ❌, slowest: backend 12m
+
+backend: 2 builds, 1 ✅, 1 ❌
+frontend: 1 builds, 1 ✅, 0 ❌
+worker: 1 builds, 0 ✅, 0 ❌`,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, new(discordNotifier).digestDescription(tc.window, tc.entries)); diff != "" {
+				t.Errorf("digestDescription got unexpected diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestMemoryDigestStore(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	m := newMemoryDigestStore(2)
+	m.record(ctx, "old/SUCCESS", digestEntry{App: "old", Time: start})
+	m.record(ctx, "a/SUCCESS", digestEntry{App: "a", Time: start.Add(time.Hour)})
+	m.record(ctx, "a/SUCCESS", digestEntry{App: "a-redelivered", Time: start.Add(time.Hour)})
+
+	got, err := m.since(ctx, start.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("since failed: %v", err)
+	}
+	want := []digestEntry{{App: "a", Time: start.Add(time.Hour)}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("since got unexpected diff: %s", diff)
+	}
+
+	m.record(ctx, "b/SUCCESS", digestEntry{App: "b", Time: start.Add(2 * time.Hour)})
+	m.record(ctx, "c/SUCCESS", digestEntry{App: "c", Time: start.Add(3 * time.Hour)})
+	got, _ = m.since(ctx, start)
+	want = []digestEntry{{App: "b", Time: start.Add(2 * time.Hour)}, {App: "c", Time: start.Add(3 * time.Hour)}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("since after eviction got unexpected diff: %s", diff)
+	}
+}
+
+func TestPostDigest(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusNoContent, "")
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{
+		digestField:         map[interface{}]interface{}{digestWindowField: "24h"},
+		notifyStatusesField: []interface{}{"FAILURE"},
+	})
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+
+	ok := testBuild()
+	ok.StartTime = timestamppb.New(now.Add(-5 * time.Minute))
+	ok.FinishTime = timestamppb.New(now)
+	failed := testBuild()
+	failed.Id = "failed-build-id"
+	failed.Status = cbpb.Build_FAILURE
+	working := testBuild()
+	working.Id = "working-build-id"
+	working.Status = cbpb.Build_WORKING
+	for _, b := range []*cbpb.Build{ok, failed, working} {
+		if err := n.SendNotification(context.Background(), b); err != nil {
+			t.Fatalf("SendNotification failed: %v", err)
+		}
+	}
+	if got := len(reqs()); got != 1 {
+		t.Fatalf("got %d webhook requests before the digest, want 1", got)
+	}
+
+	rec := httptest.NewRecorder()
+	digestHandler{n}.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, digestPath, nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("got digest status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	got := reqs()
+	if len(got) != 2 {
+		t.Fatalf("got %d webhook requests, want 2", len(got))
+	}
+	var msg discordMessage
+	if err := json.Unmarshal(got[1].body, &msg); err != nil {
+		t.Fatalf("failed to unmarshal digest: %v", err)
+	}
+	want := []embed{{
+		Title:       "📊 BUILD DIGEST",
+		Color:       digestColor,
+		Description: "Last 24h: 2 builds, 1 ✅, 1 ❌, slowest: my-app 5m",
+		Timestamp:   "2021-09-01T12:00:00Z",
+	}}
+	if diff := cmp.Diff(want, msg.Embeds); diff != "" {
+		t.Errorf("got unexpected digest diff: %s", diff)
+	}
+}
+
+func TestDigestHandlerUnconfigured(t *testing.T) {
+	rec := httptest.NewRecorder()
+	digestHandler{new(discordNotifier)}.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, digestPath, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestSetUpInvalidDigest(t *testing.T) {
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	for _, tc := range []struct {
+		name   string
+		digest interface{}
+	}{
+		{name: "not a map", digest: "daily"},
+		{name: "unknown field", digest: map[interface{}]interface{}{"schedule": "0 9 * * *"}},
+		{name: "invalid window", digest: map[interface{}]interface{}{digestWindowField: "daily"}},
+		{name: "invalid bucket", digest: map[interface{}]interface{}{digestBucketField: "my-bucket"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := new(discordNotifier)
+			if err := n.SetUp(context.Background(), newTestConfig(map[string]interface{}{digestField: tc.digest}), sg, nil); err == nil {
+				t.Error("SetUp succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+)
+
+var (
+	dryRunFlag = flag.Bool("dry-run", false, "If true, the Build read from -build is run through the notifier configured by -config and the Discord payload is printed instead of posted.")
+	configFlag = flag.String("config", "", "Path to the notifier configuration YAML used by -dry-run.")
+	buildFlag  = flag.String("build", "-", "Path to the Build, as JSON or text proto, used by -dry-run. Reads stdin if \"-\".")
+)
+
+// dryRunSecretGetter stands in for Secret Manager in a dry run, so no credentials are needed to render a message.
+type dryRunSecretGetter struct{}
+
+func (dryRunSecretGetter) GetSecret(_ context.Context, name string) (string, error) {
+	return fmt.Sprintf("[SECRET VALUE FOR %q]", name), nil
+}
+
+// runDryRun is the -dry-run mode of main.
+func runDryRun(ctx context.Context) error {
+	if *configFlag == "" {
+		return fmt.Errorf("-dry-run requires -config")
+	}
+	cf, err := os.Open(*configFlag)
+	if err != nil {
+		return fmt.Errorf("failed to open config: %w", err)
+	}
+	defer cf.Close()
+	bf := os.Stdin
+	if *buildFlag != "-" && *buildFlag != "" {
+		if bf, err = os.Open(*buildFlag); err != nil {
+			return fmt.Errorf("failed to open Build: %w", err)
+		}
+		defer bf.Close()
+	}
+	return dryRun(ctx, cf, bf, os.Stdout)
+}
+
+// dryRun sets up a notifier from the config, renders the Discord message for the Build and writes it to w,
+// or why the Build would be skipped. Nothing is posted, and neither hooks nor log lookups are run.
+func dryRun(ctx context.Context, config, build io.Reader, w io.Writer) error {
+	cfg, err := decodeConfig(config)
+	if err != nil {
+		return err
+	}
+	b, err := readBuild(build)
+	if err != nil {
+		return err
+	}
+
+	n := new(discordNotifier)
+	if err := n.SetUp(ctx, cfg, dryRunSecretGetter{}, nil); err != nil {
+		return fmt.Errorf("failed to set up notifier: %w", err)
+	}
+	if n.filter != nil && n.filter.Apply(ctx, b) {
+		_, err := fmt.Fprintf(w, "would be skipped: %s\n", skipFiltered)
+		return err
+	}
+	if reason := n.gate(b); reason != "" {
+		_, err := fmt.Fprintf(w, "would be skipped: %s\n", reason)
+		return err
+	}
+	msg, err := n.messageFormatter().format(b)
+	if err != nil {
+		return fmt.Errorf("failed to write discord message: %w", err)
+	}
+	if msg == nil {
+		_, err := fmt.Fprintf(w, "would be skipped: %s\n", skipUnhandledStatus)
+		return err
+	}
+	out, err := json.MarshalIndent(withinLimits(msg), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+// readBuild parses a Build from its JSON form, as published to the cloud-builds topic, or its text proto form.
+func readBuild(r io.Reader) (*cbpb.Build, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Build: %w", err)
+	}
+	b := new(cbpb.Build)
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := (protojson.UnmarshalOptions{AllowPartial: true, DiscardUnknown: true}).Unmarshal(trimmed, b); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal Build JSON: %w", err)
+		}
+		return b, nil
+	}
+	if err := (prototext.UnmarshalOptions{AllowPartial: true, DiscardUnknown: true}).Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Build text proto: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const dryRunConfig = `apiVersion: cloud-build-notifiers/v1
+kind: DiscordNotifier
+metadata:
+  name: example-discord-notifier
+spec:
+  notification:
+    filter: build.status == Build.Status.WORKING
+    delivery:
+      webhookUrl:
+        secretRef: webhook-url
+      requireSubstitution: _APP_NAME
+  secrets:
+  - name: webhook-url
+    value: projects/p/secrets/webhook-url/versions/latest
+`
+
+func TestDryRun(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		build string
+		want  string
+	}{{
+		name:  "skipped by filter",
+		build: `{"id": "b", "projectId": "p", "status": "WORKING", "substitutions": {"_APP_NAME": "my-app"}}`,
+		want:  "would be skipped: FILTERED\n",
+	}, {
+		name:  "skipped by gate",
+		build: `{"id": "b", "projectId": "p", "status": "SUCCESS"}`,
+		want:  "would be skipped: MISSING_SUBSTITUTION\n",
+	}, {
+		name:  "unknown fields are ignored",
+		build: `{"id": "b", "projectId": "p", "status": "SUCCESS", "someNewField": 1}`,
+		want:  "would be skipped: MISSING_SUBSTITUTION\n",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := dryRun(context.Background(), strings.NewReader(dryRunConfig), strings.NewReader(tc.build), &out); err != nil {
+				t.Fatalf("dryRun failed: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, out.String()); diff != "" {
+				t.Errorf("dryRun output diff: (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDryRunPrintsPayload(t *testing.T) {
+	for _, build := range []string{
+		`{"id": "some-build-id", "projectId": "my-project-id", "status": "SUCCESS", "substitutions": {"_APP_NAME": "my-app"}}`,
+		`id: "some-build-id" project_id: "my-project-id" status: SUCCESS substitutions { key: "_APP_NAME" value: "my-app" }`,
+	} {
+		var out bytes.Buffer
+		if err := dryRun(context.Background(), strings.NewReader(dryRunConfig), strings.NewReader(build), &out); err != nil {
+			t.Fatalf("dryRun(%s) failed: %v", build, err)
+		}
+		got := new(discordMessage)
+		if err := json.Unmarshal(out.Bytes(), got); err != nil {
+			t.Fatalf("failed to unmarshal dry run output %q: %v", out.String(), err)
+		}
+		want, err := (&discordNotifier{}).buildMessage(testBuild())
+		if err != nil {
+			t.Fatalf("buildMessage failed: %v", err)
+		}
+		// The dry run builds have no log URL.
+		want.Embeds[0].Description = got.Embeds[0].Description
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("dryRun(%s) payload diff: (-want +got):\n%s", build, diff)
+		}
+		if !strings.Contains(got.Embeds[0].Description, "my-app") {
+			t.Errorf("dryRun(%s) description = %q, want it to name the app", build, got.Embeds[0].Description)
+		}
+	}
+}
+
+func TestDryRunErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		build  string
+	}{{
+		name:   "unknown config field",
+		config: dryRunConfig + "bogus: true\n",
+		build:  `{"id": "b"}`,
+	}, {
+		name:   "missing spec",
+		config: "apiVersion: cloud-build-notifiers/v1\n",
+		build:  `{"id": "b"}`,
+	}, {
+		name:   "bad build",
+		config: dryRunConfig,
+		build:  `{"id": `,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := dryRun(context.Background(), strings.NewReader(tc.config), strings.NewReader(tc.build), new(bytes.Buffer)); err == nil {
+				t.Error("dryRun succeeded, want error")
+			}
+		})
+	}
+}
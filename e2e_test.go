@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/discord/internal/discordtest"
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// setUpE2E starts a fake Discord webhook and a notifier delivering to it with the given delivery config.
+func setUpE2E(t *testing.T, delivery map[string]interface{}) (*discordtest.Server, *discordNotifier) {
+	t.Helper()
+	srv := discordtest.NewServer()
+	t.Cleanup(srv.Close)
+	if delivery == nil {
+		delivery = map[string]interface{}{}
+	}
+	if _, ok := delivery[retryDelayField]; !ok {
+		delivery[retryDelayField] = "1ms"
+	}
+	return srv, setUpTestNotifier(t, srv.WebhookURL(), delivery)
+}
+
+// sentMessages decodes the messages the fake webhook received.
+func sentMessages(t *testing.T, srv *discordtest.Server) []discordtest.Message {
+	t.Helper()
+	var out []discordtest.Message
+	for _, r := range srv.Requests() {
+		m, err := r.Message()
+		if err != nil {
+			t.Fatalf("failed to decode request body %q: %v", r.Body, err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestEndToEndStatuses(t *testing.T) {
+	want := map[cbpb.Build_Status]string{
+		cbpb.Build_PENDING:        "✋ AWAITING APPROVAL",
+		cbpb.Build_WORKING:        "🔨 BUILDING",
+		cbpb.Build_SUCCESS:        "✅ SUCCESS",
+		cbpb.Build_FAILURE:        "❌ ERROR - FAILURE",
+		cbpb.Build_INTERNAL_ERROR: "❌ ERROR - INTERNAL_ERROR",
+		cbpb.Build_TIMEOUT:        "⏱ TIMEOUT",
+		cbpb.Build_CANCELLED:      "🚫 CANCELLED",
+		cbpb.Build_EXPIRED:        "⌛ EXPIRED",
+	}
+	for _, status := range discordtest.Statuses {
+		t.Run(status.String(), func(t *testing.T) {
+			srv, n := setUpE2E(t, nil)
+			if err := n.SendNotification(context.Background(), discordtest.Build(status)); err != nil {
+				t.Fatalf("SendNotification failed: %v", err)
+			}
+			msgs := sentMessages(t, srv)
+			title, ok := want[status]
+			if !ok {
+				if len(msgs) != 0 {
+					t.Fatalf("got %d messages for a %s Build, want none", len(msgs), status)
+				}
+				return
+			}
+			if len(msgs) != 1 {
+				t.Fatalf("got %d messages, want 1", len(msgs))
+			}
+			if len(msgs[0].Embeds) != 1 {
+				t.Fatalf("got %d embeds, want 1", len(msgs[0].Embeds))
+			}
+			e := msgs[0].Embeds[0]
+			if e.Title != title {
+				t.Errorf("got title %q, want %q", e.Title, title)
+			}
+			if !strings.HasPrefix(e.Description, "Build ID: 0f1e2d3c-4b5a-6978-8695-a4b3c2d1e0f9\nService: my-app\n") {
+				t.Errorf("got description %q, want it to start with the Build ID and service", e.Description)
+			}
+			if e.Footer == nil || e.Footer.Text != "my-project • deploy-my-app" {
+				t.Errorf("got footer %+v, want my-project • deploy-my-app", e.Footer)
+			}
+			if e.Timestamp == "" || e.Color == 0 {
+				t.Errorf("got timestamp %q and color %d, want both set", e.Timestamp, e.Color)
+			}
+		})
+	}
+}
+
+func TestEndToEndOptInStatuses(t *testing.T) {
+	srv, n := setUpE2E(t, map[string]interface{}{notifyOnQueuedField: true, notifyOnUnhandledField: true})
+	for _, status := range []cbpb.Build_Status{cbpb.Build_QUEUED, cbpb.Build_STATUS_UNKNOWN} {
+		if err := n.SendNotification(context.Background(), discordtest.Build(status)); err != nil {
+			t.Fatalf("SendNotification failed: %v", err)
+		}
+	}
+	var got []string
+	for _, m := range sentMessages(t, srv) {
+		got = append(got, m.Embeds[0].Title)
+	}
+	if diff := cmp.Diff([]string{"⏳ QUEUED", "ℹ️ STATUS_UNKNOWN"}, got); diff != "" {
+		t.Errorf("got unexpected titles diff: %s", diff)
+	}
+}
+
+func TestEndToEndPayload(t *testing.T) {
+	srv, n := setUpE2E(t, map[string]interface{}{usernameField: "CI for $PROJECT_ID"})
+	if err := n.SendNotification(context.Background(), discordtest.Build(cbpb.Build_FAILURE)); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	reqs := srv.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests, want 1", len(reqs))
+	}
+	r := reqs[0]
+	if r.Method != "POST" || r.Path != discordtest.WebhookPath {
+		t.Errorf("got %s %s, want POST %s", r.Method, r.Path, discordtest.WebhookPath)
+	}
+	if got := r.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", got)
+	}
+	m, err := r.Message()
+	if err != nil {
+		t.Fatalf("failed to decode message: %v", err)
+	}
+	if m.Username != "CI for my-project" {
+		t.Errorf("got username %q, want CI for my-project", m.Username)
+	}
+	want := discordtest.Embed{
+		Title: "❌ ERROR - FAILURE",
+		Description: "Build ID: 0f1e2d3c-4b5a-6978-8695-a4b3c2d1e0f9\n" +
+			"Service: my-app\n" +
+			"Environment: " + n.environmentLabel(discordtest.Build(cbpb.Build_FAILURE)) + "\n" +
+			"Links: [Build](https://console.cloud.google.com/cloud-build/builds/0f1e2d3c-4b5a-6978-8695-a4b3c2d1e0f9?project=my-project) • " +
+			"[Logs](https://console.cloud.google.com/cloud-build/builds/0f1e2d3c-4b5a-6978-8695-a4b3c2d1e0f9?project=123) • " +
+			"[Trigger](https://console.cloud.google.com/cloud-build/triggers/edit/trigger-id?project=my-project) • " +
+			"[Commit](https://source.cloud.google.com/my-project/my-repo/+/0123456789abcdef0123456789abcdef01234567)\n" +
+			"Failed step: test (FAILURE after 1m)\n" +
+			"`test ./...`\n" +
+			"Duration: 4m, Queued: 1m\n" +
+			"Repository: my-repo\n" +
+			"Ref: main @ [0123456](https://source.cloud.google.com/my-project/my-repo/+/0123456789abcdef0123456789abcdef01234567)",
+		Color:     14177041,
+		Timestamp: "2021-09-01T12:05:00Z",
+	}
+	want.Footer = m.Embeds[0].Footer
+	if diff := cmp.Diff([]discordtest.Embed{want}, m.Embeds); diff != "" {
+		t.Errorf("got unexpected embeds diff: %s", diff)
+	}
+}
+
+func TestEndToEndRateLimited(t *testing.T) {
+	srv, n := setUpE2E(t, nil)
+	srv.Enqueue(discordtest.RateLimited(0.01))
+	if err := n.SendNotification(context.Background(), discordtest.Build(cbpb.Build_SUCCESS)); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	reqs := srv.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests, want the rate limited one and its retry", len(reqs))
+	}
+	if diff := cmp.Diff(string(reqs[0].Body), string(reqs[1].Body)); diff != "" {
+		t.Errorf("retry sent a different payload: %s", diff)
+	}
+}
+
+func TestEndToEndBadRequest(t *testing.T) {
+	srv, n := setUpE2E(t, nil)
+	srv.Enqueue(discordtest.BadRequest("embeds.0.description", "Must be 4096 or fewer in length."))
+	err := n.SendNotification(context.Background(), discordtest.Build(cbpb.Build_SUCCESS))
+	if err == nil {
+		t.Fatal("SendNotification succeeded, want error")
+	}
+	want := "webhook returned status 400: Discord error 50035: Invalid Form Body (embeds.0.description: Must be 4096 or fewer in length.)"
+	if err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+	if got := len(srv.Requests()); got != 1 {
+		t.Errorf("got %d requests, want 1 since a 400 isn't retried", got)
+	}
+}
+
+func TestEndToEndRetriesExhausted(t *testing.T) {
+	srv, n := setUpE2E(t, map[string]interface{}{maxAttemptsField: 2})
+	srv.Enqueue(discordtest.ServerError(502), discordtest.ServerError(503))
+	err := n.SendNotification(context.Background(), discordtest.Build(cbpb.Build_FAILURE))
+	if err == nil || !strings.Contains(err.Error(), "after 2 attempts") {
+		t.Errorf("got error %v, want it to give up after 2 attempts", err)
+	}
+	if got := len(srv.Requests()); got != 2 {
+		t.Errorf("got %d requests, want 2", got)
+	}
+}
+
+func TestEndToEndSkips(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		delivery map[string]interface{}
+		build    *cbpb.Build
+		reason   skipReason
+	}{{
+		name:     "status disabled",
+		delivery: map[string]interface{}{notifyStatusesField: []interface{}{"FAILURE"}},
+		build:    discordtest.Build(cbpb.Build_SUCCESS),
+		reason:   skipStatusDisabled,
+	}, {
+		name:     "missing substitution",
+		delivery: map[string]interface{}{requireSubstitutionField: "_DEPLOY"},
+		build:    discordtest.Build(cbpb.Build_SUCCESS),
+		reason:   skipMissingSubstitution,
+	}, {
+		name:   "unhandled status",
+		build:  discordtest.Build(cbpb.Build_QUEUED),
+		reason: skipUnhandledStatus,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, n := setUpE2E(t, tc.delivery)
+			buf := captureLog(t, levelInfo)
+			if err := n.SendNotification(context.Background(), tc.build); err != nil {
+				t.Fatalf("SendNotification failed: %v", err)
+			}
+			if got := len(srv.Requests()); got != 0 {
+				t.Errorf("got %d requests, want none", got)
+			}
+			if !strings.Contains(buf.String(), "reason="+string(tc.reason)) {
+				t.Errorf("got log %q, want skip reason %s", buf.String(), tc.reason)
+			}
+		})
+	}
+}
+
+func TestEndToEndWithinLimits(t *testing.T) {
+	srv, n := setUpE2E(t, nil)
+	b := discordtest.Build(cbpb.Build_SUCCESS)
+	b.Substitutions["_APP_NAME"] = strings.Repeat("a", 5000)
+	if err := n.SendNotification(context.Background(), b); err != nil {
+		t.Fatalf("SendNotification of an oversized message failed: %v", err)
+	}
+	if got := len(srv.Requests()); got != 1 {
+		t.Errorf("got %d requests, want 1", got)
+	}
+}
+
+func TestEndToEndEditInPlace(t *testing.T) {
+	srv, n := setUpE2E(t, map[string]interface{}{editInPlaceField: true})
+	for _, status := range []cbpb.Build_Status{cbpb.Build_WORKING, cbpb.Build_SUCCESS} {
+		if err := n.SendNotification(context.Background(), discordtest.Build(status)); err != nil {
+			t.Fatalf("SendNotification failed: %v", err)
+		}
+	}
+	var got []string
+	for _, r := range srv.Requests() {
+		got = append(got, r.Method+" "+strings.TrimPrefix(r.Path, discordtest.WebhookPath)+" wait="+r.Query.Get("wait"))
+	}
+	if diff := cmp.Diff([]string{"POST  wait=true", "PATCH /messages/1 wait="}, got); diff != "" {
+		t.Errorf("got unexpected requests diff: %s", diff)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// editCard replaces the content and embeds of the Build's existing message with msg.
+// The message is forgotten once the Build reaches a final status.
+func (d *discordSink) editCard(ctx context.Context, build *cbpb.Build, msg *discordMessage, id string) error {
+	// Only content and embeds can be changed when editing a webhook message.
+	payload, err := json.Marshal(discordMessage{Content: msg.Content, Embeds: msg.Embeds})
+	if err != nil {
+		return fmt.Errorf("Unable to marshal payload %w", err)
+	}
+	u, err := url.Parse(d.url)
+	if err != nil {
+		return fmt.Errorf("failed to parse webhook URL: %w", stripURL(err))
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/messages/" + id
+	d.n.logPayload(build, fmt.Sprintf("editing message %q", id), payload)
+	if _, err := d.n.sendJSON(ctx, build, http.MethodPatch, u.String(), nil, nil, payload); err != nil {
+		return err
+	}
+	if isDoneStatus(build.Status) {
+		d.cards.delete(build.Id)
+	}
+	return nil
+}
+
+// storeCard records the message created by a `?wait=true` webhook response for later edits.
+func storeCard(cards *idStore, build *cbpb.Build, body []byte) {
+	var m webhookMessage
+	if err := json.Unmarshal(body, &m); err != nil || m.ID == "" {
+		buildLog(build).Warningf("failed to read message ID from webhook response %q: %v", body, err)
+		return
+	}
+	cards.set(build.Id, m.ID)
+}
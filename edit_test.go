@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestEditInPlace(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusOK, `{"id": "msg-1", "channel_id": "chan-1"}`)
+	n := setUpTestNotifier(t, srv.URL+"/api/webhooks/1/token", map[string]interface{}{editInPlaceField: true})
+
+	for _, status := range []cbpb.Build_Status{cbpb.Build_WORKING, cbpb.Build_WORKING, cbpb.Build_SUCCESS, cbpb.Build_SUCCESS} {
+		b := testBuild()
+		b.Status = status
+		if err := n.SendNotification(context.Background(), b); err != nil {
+			t.Fatalf("SendNotification failed: %v", err)
+		}
+	}
+
+	got := reqs()
+	want := []struct{ method, path string }{
+		{http.MethodPost, "/api/webhooks/1/token"},
+		{http.MethodPatch, "/api/webhooks/1/token/messages/msg-1"},
+		{http.MethodPatch, "/api/webhooks/1/token/messages/msg-1"},
+		// The card is forgotten once the Build finishes, so a redelivery posts anew.
+		{http.MethodPost, "/api/webhooks/1/token"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].method != w.method || got[i].path != w.path {
+			t.Errorf("request %d: got %s %s, want %s %s", i, got[i].method, got[i].path, w.method, w.path)
+		}
+	}
+	if got[0].query["wait"] == nil {
+		t.Errorf("got WORKING post query %v, want wait=true to capture the message ID", got[0].query)
+	}
+	var edit discordMessage
+	if err := json.Unmarshal(got[2].body, &edit); err != nil {
+		t.Fatalf("failed to unmarshal edit payload: %v", err)
+	}
+	if edit.Embeds[0].Title != "✅ SUCCESS" {
+		t.Errorf("got edited title %q, want the SUCCESS embed", edit.Embeds[0].Title)
+	}
+}
+
+func TestEditInPlaceFailedEditPostsNew(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusOK, `{"id": "msg-1"}`)
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{editInPlaceField: true})
+	n.sinks[0].(*discordSink).cards.set("some-build-id", "deleted")
+	n.client = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method == http.MethodPatch {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: http.NoBody}, nil
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := reqs(); len(got) != 1 || got[0].method != http.MethodPost {
+		t.Errorf("got requests %+v, want a new message posted after the edit failed", got)
+	}
+}
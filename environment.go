@@ -0,0 +1,143 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// environmentsField maps an environment name or project ID to the theme of its Builds' messages.
+	environmentsField = "environments"
+	// environmentKeyField names the substitution holding a Build's environment; it defaults to
+	// defaultEnvSubstitution.
+	environmentKeyField = "environmentKey"
+)
+
+// environmentTheme styles every message of an environment's Builds.
+type environmentTheme struct {
+	color    int
+	hasColor bool
+	// emoji is prefixed to the embed title.
+	emoji string
+	// mute drops all pings, including the configured failure and approver mentions.
+	mute bool
+}
+
+// apply prefixes the embed's title with the theme's emoji and overrides its color.
+func (t environmentTheme) apply(e *embed) {
+	if t.emoji != "" {
+		e.Title = t.emoji + " " + e.Title
+	}
+	if t.hasColor {
+		e.Color = t.color
+	}
+}
+
+// parseEnvironmentTheme reads a theme from a `{color: ..., emoji: ..., mention: ...}` config map.
+func parseEnvironmentTheme(v interface{}) (environmentTheme, error) {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return environmentTheme{}, fmt.Errorf("expected a map with color, emoji and/or mention, got %T", v)
+	}
+	var t environmentTheme
+	for k, v := range m {
+		switch k {
+		case "color":
+			c, err := parseColor(v)
+			if err != nil {
+				return environmentTheme{}, err
+			}
+			t.color, t.hasColor = c, true
+		case "emoji":
+			em, ok := v.(string)
+			if !ok {
+				return environmentTheme{}, fmt.Errorf("expected emoji to be a string, got %T", v)
+			}
+			t.emoji = em
+		case "mention":
+			b, ok := v.(bool)
+			if !ok {
+				return environmentTheme{}, fmt.Errorf("expected mention to be a boolean, got %T", v)
+			}
+			t.mute = !b
+		default:
+			return environmentTheme{}, fmt.Errorf("unknown environment field %v", k)
+		}
+	}
+	return t, nil
+}
+
+// getEnvironmentThemes returns the optional map of environment name or project ID to theme from the
+// given delivery config.
+func getEnvironmentThemes(delivery map[string]interface{}) (map[string]environmentTheme, error) {
+	v, ok := delivery[environmentsField]
+	if !ok {
+		return nil, nil
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected delivery config field %q to be a map, got %T", environmentsField, v)
+	}
+	out := make(map[string]environmentTheme, len(m))
+	for k, v := range m {
+		ks, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected keys of delivery config field %q to be strings, got %T", environmentsField, k)
+		}
+		t, err := parseEnvironmentTheme(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid environment %q in delivery config field %q: %w", ks, environmentsField, err)
+		}
+		out[ks] = t
+	}
+	return out, nil
+}
+
+// environmentName returns the Build's environment substitution, or "" if it doesn't set one.
+func (s *discordNotifier) environmentName(build *cbpb.Build) string {
+	key := s.environmentKey
+	if key == "" {
+		key = defaultEnvSubstitution
+	}
+	return build.Substitutions[key]
+}
+
+// environmentLabel describes where the Build ran, e.g. `prod (my-project)`, or just the project ID when
+// the Build doesn't name an environment.
+func (s *discordNotifier) environmentLabel(build *cbpb.Build) string {
+	env := s.environmentName(build)
+	if env == "" || env == build.ProjectId {
+		return build.ProjectId
+	}
+	if build.ProjectId == "" {
+		return env
+	}
+	return env + " (" + build.ProjectId + ")"
+}
+
+// environmentTheme returns the theme for the Build's environment, falling back to one keyed by its
+// project ID.
+func (s *discordNotifier) environmentTheme(build *cbpb.Build) (environmentTheme, bool) {
+	if env := s.environmentName(build); env != "" {
+		if t, ok := s.environments[env]; ok {
+			return t, true
+		}
+	}
+	t, ok := s.environments[build.ProjectId]
+	return t, ok
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestEnvironmentThemes(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{
+		mentionRolesOnFailureField: []interface{}{"123456789012345678"},
+		environmentsField: map[interface{}]interface{}{
+			"prod":       map[interface{}]interface{}{"emoji": "🔴"},
+			"dev":        map[interface{}]interface{}{"color": "#95A5A6", "mention": false},
+			"my-sandbox": map[interface{}]interface{}{"emoji": "🧪", "mention": false},
+		},
+	})
+
+	for _, tc := range []struct {
+		name        string
+		env         string
+		project     string
+		wantTitle   string
+		wantColor   int
+		wantEnv     string
+		wantContent string
+		wantAllowed *allowedMentions
+	}{{
+		name:        "prod",
+		env:         "prod",
+		project:     "my-project-id",
+		wantTitle:   "🔴 ❌ ERROR - FAILURE",
+		wantColor:   14177041,
+		wantEnv:     "Environment: prod (my-project-id)",
+		wantContent: "<@&123456789012345678>",
+		wantAllowed: &allowedMentions{Parse: []string{}, Roles: []string{"123456789012345678"}},
+	}, {
+		name:        "muted dev",
+		env:         "dev",
+		project:     "my-project-id",
+		wantTitle:   "❌ ERROR - FAILURE",
+		wantColor:   0x95A5A6,
+		wantEnv:     "Environment: dev (my-project-id)",
+		wantAllowed: &allowedMentions{Parse: []string{}},
+	}, {
+		name:        "by project ID",
+		project:     "my-sandbox",
+		wantTitle:   "🧪 ❌ ERROR - FAILURE",
+		wantColor:   14177041,
+		wantEnv:     "Environment: my-sandbox",
+		wantAllowed: &allowedMentions{Parse: []string{}},
+	}, {
+		name:        "unthemed",
+		env:         "staging",
+		project:     "my-project-id",
+		wantTitle:   "❌ ERROR - FAILURE",
+		wantColor:   14177041,
+		wantEnv:     "Environment: staging (my-project-id)",
+		wantContent: "<@&123456789012345678>",
+		wantAllowed: &allowedMentions{Parse: []string{}, Roles: []string{"123456789012345678"}},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := testBuild()
+			b.Status = cbpb.Build_FAILURE
+			b.ProjectId = tc.project
+			if tc.env != "" {
+				b.Substitutions["_ENV"] = tc.env
+			}
+			msg, err := n.buildMessage(b)
+			if err != nil {
+				t.Fatalf("buildMessage failed: %v", err)
+			}
+			if got := msg.Embeds[0].Title; got != tc.wantTitle {
+				t.Errorf("got title %q, want %q", got, tc.wantTitle)
+			}
+			if got := msg.Embeds[0].Color; got != tc.wantColor {
+				t.Errorf("got color %d, want %d", got, tc.wantColor)
+			}
+			if !strings.Contains(msg.Embeds[0].Description, tc.wantEnv+"\n") {
+				t.Errorf("got description %q, want it to contain %q", msg.Embeds[0].Description, tc.wantEnv)
+			}
+			if msg.Content != tc.wantContent {
+				t.Errorf("got content %q, want %q", msg.Content, tc.wantContent)
+			}
+			if diff := cmp.Diff(tc.wantAllowed, msg.AllowedMentions); diff != "" {
+				t.Errorf("got unexpected allowed mentions diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestEnvironmentKey(t *testing.T) {
+	n := &discordNotifier{
+		environmentKey: "_STAGE",
+		environments:   map[string]environmentTheme{"prod": {emoji: "🔴"}},
+	}
+	b := testBuild()
+	b.Substitutions["_STAGE"] = "prod"
+	b.Substitutions["_ENV"] = "dev"
+	if got, want := n.environmentLabel(b), "prod (my-project-id)"; got != want {
+		t.Errorf("environmentLabel got %q, want %q", got, want)
+	}
+	if _, ok := n.environmentTheme(b); !ok {
+		t.Error("environmentTheme found no theme for the _STAGE environment")
+	}
+}
+
+func TestSetUpInvalidEnvironments(t *testing.T) {
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	for _, tc := range []struct {
+		name string
+		envs interface{}
+	}{
+		{name: "not a map", envs: []interface{}{"prod"}},
+		{name: "theme not a map", envs: map[interface{}]interface{}{"prod": "red"}},
+		{name: "unknown field", envs: map[interface{}]interface{}{"prod": map[interface{}]interface{}{"title": "PROD"}}},
+		{name: "invalid color", envs: map[interface{}]interface{}{"prod": map[interface{}]interface{}{"color": "red"}}},
+		{name: "invalid mention", envs: map[interface{}]interface{}{"prod": map[interface{}]interface{}{"mention": "yes"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := new(discordNotifier)
+			if err := n.SetUp(context.Background(), newTestConfig(map[string]interface{}{environmentsField: tc.envs}), sg, nil); err == nil {
+				t.Error("SetUp succeeded, want error")
+			}
+		})
+	}
+}
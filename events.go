@@ -0,0 +1,176 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// maxSeenEvents bounds how many Build events are remembered for deduplication.
+// The oldest event is forgotten once the cap is reached.
+const maxSeenEvents = 1000
+
+// eventStore records notified Build events so that redelivered ones can be skipped.
+type eventStore interface {
+	// add records the event, reporting false if it was already recorded.
+	add(ctx context.Context, key string) (bool, error)
+	// remove forgets the event so it can be retried, e.g. after a failed delivery.
+	remove(ctx context.Context, key string) error
+}
+
+func eventKey(build *cbpb.Build) string {
+	return build.Id + "/" + build.Status.String()
+}
+
+// seenEvents remembers recently notified (Build ID, status) pairs in memory, which is enough when a
+// single notifier instance receives every event.
+type seenEvents struct {
+	mu  sync.Mutex
+	max int
+	// window is how long an event is remembered; zero remembers it until it is evicted.
+	window time.Duration
+	now    func() time.Time
+	keys   map[string]time.Time
+	order  []string
+}
+
+func newSeenEvents(max int, window time.Duration, now func() time.Time) *seenEvents {
+	return &seenEvents{max: max, window: window, now: now, keys: make(map[string]time.Time)}
+}
+
+func (e *seenEvents) add(_ context.Context, key string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.now()
+	if at, ok := e.keys[key]; ok {
+		if e.window <= 0 || now.Sub(at) < e.window {
+			return false, nil
+		}
+		e.removeLocked(key)
+	}
+	if len(e.order) >= e.max {
+		delete(e.keys, e.order[0])
+		e.order = e.order[1:]
+	}
+	e.keys[key] = now
+	e.order = append(e.order, key)
+	return true, nil
+}
+
+func (e *seenEvents) remove(_ context.Context, key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.removeLocked(key)
+	return nil
+}
+
+func (e *seenEvents) removeLocked(key string) {
+	if _, ok := e.keys[key]; !ok {
+		return
+	}
+	delete(e.keys, key)
+	for i, k := range e.order {
+		if k == key {
+			e.order = append(e.order[:i], e.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// gcsEventStore records events as empty objects in a GCS bucket, so that every notifier instance
+// sharing the bucket skips an event any of them has notified. Objects are created only if absent,
+// which makes concurrent deliveries of the same event race for a single notification.
+type gcsEventStore struct {
+	bucket *storage.BucketHandle
+	prefix string
+	// window is how long an event is remembered; zero remembers it for as long as its object exists.
+	window time.Duration
+	now    func() time.Time
+}
+
+// newGCSEventStore returns a store writing under a `gs://bucket/prefix` location.
+func newGCSEventStore(client *storage.Client, location string, window time.Duration, now func() time.Time) (*gcsEventStore, error) {
+	bucket, prefix, err := parseGCSLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsEventStore{bucket: client.Bucket(bucket), prefix: prefix, window: window, now: now}, nil
+}
+
+// parseGCSLocation splits a `gs://bucket/prefix` location into its bucket and object prefix, which is
+// empty or ends with a slash.
+func parseGCSLocation(location string) (bucket, prefix string, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(location, "gs://"), "/", 2)
+	if !strings.HasPrefix(location, "gs://") || parts[0] == "" {
+		return "", "", fmt.Errorf("expected a gs://bucket/prefix location, got %q", location)
+	}
+	if len(parts) == 2 && parts[1] != "" {
+		prefix = strings.TrimSuffix(parts[1], "/") + "/"
+	}
+	return parts[0], prefix, nil
+}
+
+func (g *gcsEventStore) add(ctx context.Context, key string) (bool, error) {
+	obj := g.bucket.Object(g.prefix + key)
+	err := obj.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx).Close()
+	if err == nil {
+		return true, nil
+	}
+	if !isPreconditionFailed(err) {
+		return false, fmt.Errorf("failed to record event %q: %w", key, err)
+	}
+	if g.window <= 0 {
+		return false, nil
+	}
+
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to read event %q: %w", key, err)
+	}
+	if g.now().Sub(attrs.Updated) < g.window {
+		return false, nil
+	}
+	// The event is older than the window, so claim it again unless another instance just did.
+	err = obj.If(storage.Conditions{GenerationMatch: attrs.Generation}).NewWriter(ctx).Close()
+	if isPreconditionFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to record event %q: %w", key, err)
+	}
+	return true, nil
+}
+
+func (g *gcsEventStore) remove(ctx context.Context, key string) error {
+	if err := g.bucket.Object(g.prefix + key).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+		return fmt.Errorf("failed to forget event %q: %w", key, err)
+	}
+	return nil
+}
+
+func isPreconditionFailed(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestDedupeEvents(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusNoContent, "")
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{dedupeEventsField: true})
+	n.skipMetrics = true
+	before := skipCount(skipDuplicateEvent)
+
+	for i := 0; i < 2; i++ {
+		if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+			t.Fatalf("SendNotification failed: %v", err)
+		}
+	}
+	if got := len(reqs()); got != 1 {
+		t.Errorf("got %d webhook requests for a repeated event, want 1", got)
+	}
+	if got := skipCount(skipDuplicateEvent) - before; got != 1 {
+		t.Errorf("got %d DUPLICATE_EVENT skips, want 1", got)
+	}
+
+	failed := testBuild()
+	failed.Status = cbpb.Build_FAILURE
+	if err := n.SendNotification(context.Background(), failed); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := len(reqs()); got != 2 {
+		t.Errorf("got %d webhook requests after a new status, want 2", got)
+	}
+}
+
+func TestDedupeEventsRetriesFailedDelivery(t *testing.T) {
+	srv, calls := sequenceServer(t, respondWith(http.StatusNotFound), respondWith(http.StatusNoContent))
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{dedupeEventsField: true})
+
+	if err := n.SendNotification(context.Background(), testBuild()); err == nil {
+		t.Fatal("SendNotification succeeded, want the 404 to fail it")
+	}
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Errorf("got %d webhook attempts, want the redelivered event to be sent again", got)
+	}
+}
+
+func TestSeenEventsEviction(t *testing.T) {
+	ctx := context.Background()
+	e := newSeenEvents(2, 0, time.Now)
+	for _, k := range []string{"a", "b", "c"} {
+		if ok, _ := e.add(ctx, k); !ok {
+			t.Errorf("add(%q) got false for a new key, want true", k)
+		}
+	}
+	if len(e.keys) != 2 || len(e.order) != 2 {
+		t.Errorf("got %d keys and %d ordered, want the set capped at 2", len(e.keys), len(e.order))
+	}
+	if ok, _ := e.add(ctx, "a"); !ok {
+		t.Error(`add("a") got false after eviction, want true`)
+	}
+	if ok, _ := e.add(ctx, "c"); ok {
+		t.Error(`add("c") got true for a remembered key, want false`)
+	}
+}
+
+func TestSeenEventsWindow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	e := newSeenEvents(maxSeenEvents, 10*time.Minute, func() time.Time { return now })
+	if ok, _ := e.add(ctx, "a"); !ok {
+		t.Fatal(`add("a") got false for a new key, want true`)
+	}
+	now = now.Add(9 * time.Minute)
+	if ok, _ := e.add(ctx, "a"); ok {
+		t.Error(`add("a") got true within the window, want false`)
+	}
+	now = now.Add(2 * time.Minute)
+	if ok, _ := e.add(ctx, "a"); !ok {
+		t.Error(`add("a") got false after the window, want true`)
+	}
+	if len(e.order) != 1 {
+		t.Errorf("got %d ordered keys, want the expired entry replaced", len(e.order))
+	}
+}
+
+func TestSetUpDedupeEvents(t *testing.T) {
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	for name, delivery := range map[string]map[string]interface{}{
+		"window without dedupeEvents": {dedupeEventsWindowField: "10m"},
+		"bucket without dedupeEvents": {dedupeEventsBucketField: "gs://events"},
+		"negative window":             {dedupeEventsField: true, dedupeEventsWindowField: "-1m"},
+	} {
+		if err := new(discordNotifier).SetUp(context.Background(), newTestConfig(delivery), sg, nil); err == nil {
+			t.Errorf("%s: SetUp succeeded, want error", name)
+		}
+	}
+
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{
+		dedupeEventsField:       true,
+		dedupeEventsWindowField: "1h",
+	})
+	if e, ok := n.events.(*seenEvents); !ok || e.window != time.Hour {
+		t.Errorf("got event store %+v, want an in-memory one with a 1h window", n.events)
+	}
+}
+
+func TestNewGCSEventStoreLocation(t *testing.T) {
+	for _, tc := range []struct {
+		location   string
+		wantPrefix string
+		wantErr    bool
+	}{
+		{location: "gs://events", wantPrefix: ""},
+		{location: "gs://events/discord/", wantPrefix: "discord/"},
+		{location: "events", wantErr: true},
+		{location: "gs://", wantErr: true},
+	} {
+		g, err := newGCSEventStore(new(storage.Client), tc.location, 0, time.Now)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("newGCSEventStore(%q) got error %v, want error %v", tc.location, err, tc.wantErr)
+			continue
+		}
+		if err == nil && g.prefix != tc.wantPrefix {
+			t.Errorf("newGCSEventStore(%q) got prefix %q, want %q", tc.location, g.prefix, tc.wantPrefix)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// branchesField, projectsField and statusesField restrict notifications to Builds of matching
+// branches, projects and statuses.
+const (
+	branchesField = "branches"
+	projectsField = "projects"
+	statusesField = "statuses"
+)
+
+// configFilter compiles the branches, projects and statuses delivery fields into a CEL expression
+// matching the Builds to notify, or returns "" if none is set. Branch patterns may use `*` and `?`
+// globs and are matched against the BRANCH_NAME substitution.
+func configFilter(delivery map[string]interface{}) (string, error) {
+	branches, err := getStringSlice(delivery, branchesField)
+	if err != nil {
+		return "", err
+	}
+	projects, err := getStringSlice(delivery, projectsField)
+	if err != nil {
+		return "", err
+	}
+	statuses, err := getStringSlice(delivery, statusesField)
+	if err != nil {
+		return "", err
+	}
+
+	var clauses []string
+	if len(branches) > 0 {
+		patterns := make([]string, 0, len(branches))
+		for _, b := range branches {
+			patterns = append(patterns, globPattern(b))
+		}
+		clauses = append(clauses, fmt.Sprintf(`("BRANCH_NAME" in build.substitutions && build.substitutions["BRANCH_NAME"].matches(%s))`,
+			strconv.Quote(strings.Join(patterns, "|"))))
+	}
+	if len(projects) > 0 {
+		quoted := make([]string, 0, len(projects))
+		for _, p := range projects {
+			quoted = append(quoted, strconv.Quote(p))
+		}
+		clauses = append(clauses, "build.project_id in ["+strings.Join(quoted, ", ")+"]")
+	}
+	if len(statuses) > 0 {
+		names := make([]string, 0, len(statuses))
+		for _, st := range statuses {
+			if _, ok := cbpb.Build_Status_value[st]; !ok {
+				return "", fmt.Errorf("unknown build status %q in delivery config field %q", st, statusesField)
+			}
+			names = append(names, "Build.Status."+st)
+		}
+		clauses = append(clauses, "build.status in ["+strings.Join(names, ", ")+"]")
+	}
+	return strings.Join(clauses, " && "), nil
+}
+
+// globPattern converts a glob such as `release/*` into an anchored regular expression.
+func globPattern(glob string) string {
+	p := regexp.QuoteMeta(glob)
+	p = strings.ReplaceAll(p, `\*`, ".*")
+	p = strings.ReplaceAll(p, `\?`, ".")
+	return "^(" + p + ")$"
+}
+
+// combineFilters merges the raw Notification filter, which skips the Builds it matches, with the
+// expression compiled from the config fields, which matches the Builds to notify, into one expression
+// with the raw filter's semantics.
+func combineFilters(raw, notify string) string {
+	switch {
+	case notify == "":
+		return raw
+	case raw == "":
+		return "!(" + notify + ")"
+	}
+	return "(" + raw + ") || !(" + notify + ")"
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestConfigFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		raw       string
+		branch    string
+		project   string
+		status    cbpb.Build_Status
+		wantAllow bool
+	}{
+		{name: "matching branch and project", branch: "main", project: "prod", wantAllow: true},
+		{name: "matching glob", branch: "release/1.2", project: "staging", wantAllow: true},
+		{name: "glob is anchored", branch: "old-release/1.2", project: "prod"},
+		{name: "other branch", branch: "feature/x", project: "prod"},
+		{name: "no branch", project: "prod"},
+		{name: "other project", branch: "main", project: "dev"},
+		{name: "matching status", branch: "main", project: "prod", status: cbpb.Build_FAILURE, wantAllow: true},
+		{name: "other status", branch: "main", project: "prod", status: cbpb.Build_TIMEOUT},
+		{name: "raw filter still skips", raw: `build.substitutions["_APP_NAME"] == "my-app"`, branch: "main", project: "prod"},
+		{name: "raw filter not matching", raw: `build.substitutions["_APP_NAME"] == "other"`, branch: "main", project: "prod", wantAllow: true},
+	} {
+		cfg := newTestConfig(map[string]interface{}{
+			branchesField: []interface{}{"main", "release/*"},
+			projectsField: []interface{}{"prod", "staging"},
+			statusesField: []interface{}{"SUCCESS", "FAILURE"},
+		})
+		cfg.Spec.Notification.Filter = tc.raw
+		n := new(discordNotifier)
+		sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+		if err := n.SetUp(context.Background(), cfg, sg, nil); err != nil {
+			t.Fatalf("%s: SetUp failed: %v", tc.name, err)
+		}
+
+		b := testBuild()
+		b.ProjectId = tc.project
+		if tc.status != cbpb.Build_STATUS_UNKNOWN {
+			b.Status = tc.status
+		}
+		if tc.branch != "" {
+			b.Substitutions["BRANCH_NAME"] = tc.branch
+		}
+		if got := !n.filter.Apply(context.Background(), b); got != tc.wantAllow {
+			t.Errorf("%s: got notified %v, want %v", tc.name, got, tc.wantAllow)
+		}
+	}
+}
+
+func TestConfigFilterUnset(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", nil)
+	if n.filter != nil {
+		t.Errorf("got filter %v without branches, projects, statuses or a raw filter, want none", n.filter)
+	}
+
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	bad := newTestConfig(map[string]interface{}{branchesField: "main"})
+	if err := new(discordNotifier).SetUp(context.Background(), bad, sg, nil); err == nil {
+		t.Error("SetUp succeeded with a non-list branches field, want error")
+	}
+	bad = newTestConfig(map[string]interface{}{statusesField: []interface{}{"EXPLODED"}})
+	if err := new(discordNotifier).SetUp(context.Background(), bad, sg, nil); err == nil {
+		t.Error("SetUp succeeded with an unknown status in statuses, want error")
+	}
+}
+
+func TestGlobPattern(t *testing.T) {
+	for glob, want := range map[string]string{
+		"main":      `^(main)$`,
+		"release/*": `^(release/.*)$`,
+		"v1.?":      `^(v1\..)$`,
+	} {
+		if got := globPattern(glob); got != want {
+			t.Errorf("globPattern(%q) = %q, want %q", glob, got, want)
+		}
+	}
+}
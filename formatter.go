@@ -0,0 +1,105 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// formatField selects the messageFormatter by name; see formatters.
+const formatField = "format"
+
+// messageFormatter renders the Discord message for a Build, or nil if the Build isn't notified.
+type messageFormatter interface {
+	format(build *cbpb.Build) (*discordMessage, error)
+}
+
+// formatters maps the supported formatField values to their constructors.
+var formatters = map[string]func(*discordNotifier) messageFormatter{
+	"detailed": func(s *discordNotifier) messageFormatter { return detailedFormatter{s} },
+	"compact":  func(s *discordNotifier) messageFormatter { return compactFormatter{s} },
+}
+
+// getFormatter returns the messageFormatter named by the optional format field, defaulting to detailed.
+func (s *discordNotifier) getFormatter(delivery map[string]interface{}) (messageFormatter, error) {
+	name, err := getString(delivery, formatField)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = "detailed"
+	}
+	newFormatter, ok := formatters[name]
+	if !ok {
+		return nil, fmt.Errorf("expected delivery config field %q to be \"compact\" or \"detailed\", got %q", formatField, name)
+	}
+	return newFormatter(s), nil
+}
+
+// messageFormatter returns the configured formatter, defaulting to detailed for notifiers built without SetUp.
+func (s *discordNotifier) messageFormatter() messageFormatter {
+	if s.formatter != nil {
+		return s.formatter
+	}
+	return detailedFormatter{s}
+}
+
+// detailedFormatter renders the multi-line embeds built by buildMessage.
+type detailedFormatter struct {
+	n *discordNotifier
+}
+
+func (f detailedFormatter) format(build *cbpb.Build) (*discordMessage, error) {
+	return f.n.buildMessage(build)
+}
+
+// compactFormatter collapses the detailed message into a single embed with a one-line summary, e.g.
+// `✅ SUCCESS • my-app • main @ 0123456 • Duration: 4m32s • [Logs](...)`, for busy channels.
+// The detailed message's color, content and identity are kept.
+type compactFormatter struct {
+	n *discordNotifier
+}
+
+func (f compactFormatter) format(build *cbpb.Build) (*discordMessage, error) {
+	msg, err := f.n.buildMessage(build)
+	if err != nil || msg == nil {
+		return msg, err
+	}
+	first := msg.Embeds[0]
+	parts := []string{first.Title}
+	if app := f.n.appName(build); app != "" {
+		parts = append(parts, app)
+	}
+	if r := f.n.source(build).refText(); r != "" {
+		parts = append(parts, r)
+	}
+	if isDoneStatus(build.Status) {
+		if d := f.n.durationLine(build); d != "" {
+			parts = append(parts, d)
+		}
+	}
+	if row := linkRow(f.n.links(build, f.n.source(build))); row != "" {
+		parts = append(parts, row)
+	}
+	msg.Embeds = []embed{{
+		Color:       first.Color,
+		Description: strings.Join(parts, " • "),
+		Timestamp:   first.Timestamp,
+	}}
+	return msg, nil
+}
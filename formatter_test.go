@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestCompactFormatter(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{formatField: "compact"})
+	start := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	b := testBuild()
+	b.Substitutions["BRANCH_NAME"] = "main"
+	b.StartTime = timestamppb.New(start)
+	b.FinishTime = timestamppb.New(start.Add(4*time.Minute + 32*time.Second))
+
+	msg, err := n.messageFormatter().format(b)
+	if err != nil {
+		t.Fatalf("format failed: %v", err)
+	}
+	want := []embed{{
+		Color: 1127128,
+		Description: "✅ SUCCESS • my-app • main • Duration: 4m32s • " +
+			"[Build](https://console.cloud.google.com/cloud-build/builds/some-build-id?project=my-project-id) • " +
+			"[Logs](https://some.example.com/log/url?foo=bar)",
+		Timestamp: "2021-02-01T12:04:32Z",
+	}}
+	if diff := cmp.Diff(want, msg.Embeds); diff != "" {
+		t.Errorf("got unexpected embeds (-want +got):\n%s", diff)
+	}
+
+	b.Status = cbpb.Build_STATUS_UNKNOWN
+	if msg, err = n.messageFormatter().format(b); err != nil || msg != nil {
+		t.Errorf("format of an unhandled status got (%+v, %v), want (nil, nil)", msg, err)
+	}
+}
+
+func TestDetailedFormatterIsDefault(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", nil)
+	if _, ok := n.messageFormatter().(detailedFormatter); !ok {
+		t.Errorf("got formatter %T, want detailedFormatter", n.messageFormatter())
+	}
+	if _, ok := new(discordNotifier).messageFormatter().(detailedFormatter); !ok {
+		t.Error("notifier without SetUp doesn't default to detailedFormatter")
+	}
+
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	bad := newTestConfig(map[string]interface{}{formatField: "verbose"})
+	if err := new(discordNotifier).SetUp(context.Background(), bad, sg, nil); err == nil {
+		t.Error("SetUp succeeded with an unknown format, want error")
+	}
+}
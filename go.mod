@@ -3,10 +3,19 @@ module github.com/GoogleCloudPlatform/cloud-build-notifiers/discord
 go 1.14
 
 require (
+	cloud.google.com/go/storage v1.13.0
 	github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers v0.0.0-20210205212514-9176fa6ca224
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
-	github.com/google/go-cmp v0.5.4
+	github.com/google/go-cmp v0.5.5
 	github.com/pkg/errors v0.9.1 // indirect
+	go.opentelemetry.io/otel v0.20.0
+	go.opentelemetry.io/otel/exporters/stdout v0.20.0
+	go.opentelemetry.io/otel/sdk v0.20.0
+	go.opentelemetry.io/otel/trace v0.20.0
+	golang.org/x/oauth2 v0.0.0-20210201163806-010130855d6c
 	golang.org/x/text v0.3.5 // indirect
-	google.golang.org/genproto v0.0.0-20210204154452-deb828366460
+	google.golang.org/api v0.39.0
+	google.golang.org/genproto v0.0.0-20210903162649-d08c68adba83
+	google.golang.org/protobuf v1.27.1
+	gopkg.in/yaml.v2 v2.4.0
 )
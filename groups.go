@@ -0,0 +1,122 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// groupEmbedsByField selects how a Build deploying several services is split into one embed per service:
+	// groupByApp, groupByImage, groupByNone or the name of a substitution listing the services.
+	groupEmbedsByField = "groupEmbedsBy"
+	// groupByApp splits a comma-separated app name, e.g. `frontend,backend`. It is the default.
+	groupByApp = "app"
+	// groupByImage shows each image the Build pushes as its own service.
+	groupByImage = "image"
+	// groupByNone always renders a single embed.
+	groupByNone = "none"
+)
+
+// substitutionNamePattern matches substitution names, such as `_SERVICES` or `REPO_NAME`.
+var substitutionNamePattern = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+// getGroupEmbedsBy returns the optional groupEmbedsByField, defaulting to groupByApp.
+func getGroupEmbedsBy(delivery map[string]interface{}) (string, error) {
+	g, err := getString(delivery, groupEmbedsByField)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case g == "":
+		return groupByApp, nil
+	case g == groupByApp, g == groupByImage, g == groupByNone, substitutionNamePattern.MatchString(g):
+		return g, nil
+	}
+	return "", fmt.Errorf("expected delivery config field %q to be %q, %q, %q or a substitution name, got %q", groupEmbedsByField, groupByApp, groupByImage, groupByNone, g)
+}
+
+// groups splits the Build into one copy per service, each with the service as its app name, or
+// returns nil if the Build has fewer than two services.
+func (s *discordNotifier) groups(build *cbpb.Build) []*cbpb.Build {
+	var out []*cbpb.Build
+	switch s.groupEmbedsBy {
+	case groupByNone:
+		return nil
+	case groupByImage:
+		if images := build.Results.GetImages(); len(images) > 1 {
+			for _, img := range images {
+				b := s.serviceBuild(build, imageName(img.Name))
+				b.Results.Images = []*cbpb.BuiltImage{img}
+				out = append(out, b)
+			}
+			return out
+		}
+		// Images are only pushed once the Build succeeds, so earlier notifications are split by the
+		// images it is going to push.
+		if len(build.Images) > 1 {
+			for _, name := range build.Images {
+				b := s.serviceBuild(build, imageName(name))
+				b.Images = []string{name}
+				out = append(out, b)
+			}
+		}
+		return out
+	case "", groupByApp:
+		for _, svc := range s.services(build) {
+			out = append(out, s.serviceBuild(build, svc))
+		}
+	default:
+		for _, svc := range strings.Split(build.Substitutions[s.groupEmbedsBy], ",") {
+			if svc = strings.TrimSpace(svc); svc != "" {
+				out = append(out, s.serviceBuild(build, svc))
+			}
+		}
+	}
+	if len(out) < 2 {
+		return nil
+	}
+	return out
+}
+
+// serviceBuild copies the Build with its app name set to the service.
+func (s *discordNotifier) serviceBuild(build *cbpb.Build, svc string) *cbpb.Build {
+	b := proto.Clone(build).(*cbpb.Build)
+	if b.Substitutions == nil {
+		b.Substitutions = make(map[string]string)
+	}
+	b.Substitutions[s.appNameKey()] = svc
+	return b
+}
+
+// imageName returns the last path element of an image without its tag or digest, e.g. `frontend`
+// for `us-docker.pkg.dev/my-project/images/frontend:v1`.
+func imageName(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, "/"); i >= 0 {
+		image = image[i+1:]
+	}
+	if i := strings.Index(image, ":"); i >= 0 {
+		image = image[:i]
+	}
+	return image
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestGroups(t *testing.T) {
+	images := &cbpb.Results{Images: []*cbpb.BuiltImage{
+		{Name: "us-docker.pkg.dev/my-project/images/frontend:v1", Digest: "sha256:aaa"},
+		{Name: "gcr.io/my-project/backend", Digest: "sha256:bbb"},
+	}}
+	for _, tc := range []struct {
+		name    string
+		groupBy string
+		build   func(*cbpb.Build)
+		want    []string
+	}{{
+		name:  "comma-separated app name by default",
+		build: func(b *cbpb.Build) { b.Substitutions["_APP_NAME"] = "frontend, backend" },
+		want:  []string{"frontend", "backend"},
+	}, {
+		name:  "single app",
+		build: func(b *cbpb.Build) {},
+	}, {
+		name:    "none",
+		groupBy: groupByNone,
+		build:   func(b *cbpb.Build) { b.Substitutions["_APP_NAME"] = "frontend,backend" },
+	}, {
+		name:    "pushed images",
+		groupBy: groupByImage,
+		build:   func(b *cbpb.Build) { b.Results = images },
+		want:    []string{"frontend", "backend"},
+	}, {
+		name:    "images to push",
+		groupBy: groupByImage,
+		build: func(b *cbpb.Build) {
+			b.Status = cbpb.Build_WORKING
+			b.Images = []string{"gcr.io/my-project/worker@sha256:ccc", "gcr.io/my-project/api:latest"}
+		},
+		want: []string{"worker", "api"},
+	}, {
+		name:    "single image",
+		groupBy: groupByImage,
+		build:   func(b *cbpb.Build) { b.Images = []string{"gcr.io/my-project/api"} },
+	}, {
+		name:    "substitution",
+		groupBy: "_SERVICES",
+		build:   func(b *cbpb.Build) { b.Substitutions["_SERVICES"] = "api,,worker" },
+		want:    []string{"api", "worker"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := testBuild()
+			tc.build(b)
+			n := &discordNotifier{groupEmbedsBy: tc.groupBy}
+			var got []string
+			for _, g := range n.groups(b) {
+				got = append(got, g.Substitutions["_APP_NAME"])
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("groups got unexpected diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestGroupsByImageKeepOwnImage(t *testing.T) {
+	b := testBuild()
+	b.Results = &cbpb.Results{Images: []*cbpb.BuiltImage{
+		{Name: "gcr.io/my-project/frontend", Digest: "sha256:aaa"},
+		{Name: "gcr.io/my-project/backend", Digest: "sha256:bbb"},
+	}}
+	msg, err := (&discordNotifier{groupEmbedsBy: groupByImage}).buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if len(msg.Embeds) != 2 {
+		t.Fatalf("got %d embeds, want 2", len(msg.Embeds))
+	}
+	for i, want := range []string{"frontend@sha256:aaa", "backend@sha256:bbb"} {
+		d := msg.Embeds[i].Description
+		if !strings.Contains(d, want) || strings.Count(d, "gcr.io/") != 1 {
+			t.Errorf("embed %d: got description %q, want only image %q", i, d, want)
+		}
+	}
+	if len(b.Results.Images) != 2 {
+		t.Error("buildMessage modified the Build's images")
+	}
+}
+
+func TestGroupsWithoutSubstitutions(t *testing.T) {
+	b := &cbpb.Build{Id: "some-build-id", Status: cbpb.Build_SUCCESS, Images: []string{"gcr.io/p/a", "gcr.io/p/b"}}
+	if got := len((&discordNotifier{groupEmbedsBy: groupByImage}).groups(b)); got != 2 {
+		t.Errorf("got %d groups, want 2", got)
+	}
+}
+
+func TestImageName(t *testing.T) {
+	for in, want := range map[string]string{
+		"frontend":                         "frontend",
+		"gcr.io/p/frontend:v1":             "frontend",
+		"gcr.io/p/frontend@sha256:abc":     "frontend",
+		"localhost:5000/p/frontend:v1":     "frontend",
+		"us-docker.pkg.dev/p/r/a/frontend": "frontend",
+	} {
+		if got := imageName(in); got != want {
+			t.Errorf("imageName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSetUpGroupEmbedsBy(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{groupEmbedsByField: "_SERVICES"})
+	if n.groupEmbedsBy != "_SERVICES" {
+		t.Errorf("got groupEmbedsBy %q, want _SERVICES", n.groupEmbedsBy)
+	}
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	for _, v := range []interface{}{"services", "images", 1} {
+		if err := new(discordNotifier).SetUp(context.Background(), newTestConfig(map[string]interface{}{groupEmbedsByField: v}), sg, nil); err == nil {
+			t.Errorf("SetUp with %s %v succeeded, want error", groupEmbedsByField, v)
+		}
+	}
+}
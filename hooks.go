@@ -0,0 +1,168 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// postHooksField lists endpoints called after a notification, each gated by an optional CEL condition.
+const postHooksField = "postHooks"
+
+// postHook is an endpoint called after a notification when its condition matches the Build.
+type postHook struct {
+	url    string
+	method string
+	// body renders the JSON request body from the Build; a nil body sends none.
+	body *template.Template
+	// condition selects the Builds that fire the hook; a nil condition fires for every Build.
+	condition notifiers.EventFilter
+}
+
+// getPostHooks returns the optional list of post-notification hooks from the given delivery config.
+// A hook's url is either a string or a `secretRef` to a secret holding it.
+func getPostHooks(ctx context.Context, sg notifiers.SecretGetter, secrets []*notifiers.Secret, delivery map[string]interface{}) ([]postHook, error) {
+	v, ok := delivery[postHooksField]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected delivery config field %q to be a list, got %T", postHooksField, v)
+	}
+	hooks := make([]postHook, 0, len(list))
+	for i, item := range list {
+		m, err := toStringMap(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s[%d]: %w", postHooksField, i, err)
+		}
+		var u string
+		if _, ok := m["url"].(map[interface{}]interface{}); ok {
+			u, err = getSecret(ctx, sg, secrets, m, "url")
+		} else {
+			u, err = getString(m, "url")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s[%d]: %w", postHooksField, i, err)
+		}
+		if u == "" {
+			return nil, fmt.Errorf("expected %s[%d] to have a url", postHooksField, i)
+		}
+		method, err := getString(m, "method")
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s[%d]: %w", postHooksField, i, err)
+		}
+		if method == "" {
+			method = http.MethodGet
+		}
+		h := postHook{url: u, method: strings.ToUpper(method)}
+
+		body, err := getString(m, "body")
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s[%d]: %w", postHooksField, i, err)
+		}
+		if h.body, err = parseTemplate(fmt.Sprintf("%s[%d].body", postHooksField, i), body); err != nil {
+			return nil, err
+		}
+
+		cond, err := getString(m, "condition")
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s[%d]: %w", postHooksField, i, err)
+		}
+		if cond != "" {
+			if h.condition, err = notifiers.MakeCELPredicate(cond); err != nil {
+				return nil, fmt.Errorf("failed to make a CEL predicate for %s[%d]: %w", postHooksField, i, err)
+			}
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// runPostHooks calls every post-notification hook whose condition matches the Build.
+func (s *discordNotifier) runPostHooks(ctx context.Context, build *cbpb.Build) {
+	for i, h := range s.postHooks {
+		if h.condition != nil && !h.condition.Apply(ctx, build) {
+			continue
+		}
+		var body []byte
+		if h.body != nil {
+			b, err := executeTemplate(h.body, build)
+			if err != nil {
+				buildLog(build).Errorf("Failed to render post hook body for Build %q: %v", build.Id, err)
+				continue
+			}
+			body = []byte(b)
+		}
+		// The URL may come from a secret, so it is left out of the logs.
+		status, err := s.request(ctx, h.method, h.url, body)
+		if err != nil {
+			buildLog(build).withResponseCode(status).Errorf("Failed to call post hook %d (%s) for Build %q: %v", i, h.method, build.Id, err)
+			continue
+		}
+		buildLog(build).withResponseCode(status).Infof("Called post hook %d (%s) for Build %q (status: %d)", i, h.method, build.Id, status)
+	}
+}
+
+// runSuccessHooks calls the post-success endpoint configured for the Build's app.
+func (s *discordNotifier) runSuccessHooks(ctx context.Context, build *cbpb.Build) {
+	if build.Status != cbpb.Build_SUCCESS {
+		return
+	}
+	app := s.appName(build)
+	if u, ok := s.successHooks[app]; ok {
+		s.callHook(ctx, build, app, u)
+	}
+}
+
+// callHook fires a GET at the hook endpoint, logging rather than returning failures.
+func (s *discordNotifier) callHook(ctx context.Context, build *cbpb.Build, app, hookURL string) {
+	status, err := s.request(ctx, http.MethodGet, hookURL, nil)
+	if err != nil {
+		buildLog(build).withResponseCode(status).Errorf("Failed to call success hook for %q: %v", app, err)
+		return
+	}
+	buildLog(build).withResponseCode(status).Infof("Successfully called success hook for %q (status: %d)", app, status)
+}
+
+// request makes a request through the notifier's HTTP client and returns the response status code,
+// failing on a non-2xx status. A non-nil body is sent as JSON.
+func (s *discordNotifier) request(ctx context.Context, method, u string, body []byte) (int, error) {
+	// Errors leave out the URL, which may come from a secret.
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", stripURL(err))
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s request failed: %w", method, stripURL(err))
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestSuccessHooksPerApp(t *testing.T) {
+	webhook, _ := recordingServer(t, http.StatusNoContent, "")
+	backend, backendReqs := recordingServer(t, http.StatusOK, "")
+	frontend, frontendReqs := recordingServer(t, http.StatusOK, "")
+
+	n := setUpTestNotifier(t, webhook.URL, map[string]interface{}{
+		successHooksField: map[interface{}]interface{}{
+			"backend":  backend.URL,
+			"frontend": frontend.URL,
+		},
+	})
+
+	send := func(app string, status cbpb.Build_Status) {
+		b := testBuild()
+		b.Status = status
+		b.Substitutions["_APP_NAME"] = app
+		if err := n.SendNotification(context.Background(), b); err != nil {
+			t.Fatalf("SendNotification failed: %v", err)
+		}
+	}
+	send("backend", cbpb.Build_SUCCESS)
+	send("frontend", cbpb.Build_SUCCESS)
+	send("frontend", cbpb.Build_FAILURE)
+	send("worker", cbpb.Build_SUCCESS)
+
+	if got := backendReqs(); len(got) != 1 || got[0].method != http.MethodGet {
+		t.Errorf("got backend hook requests %+v, want one GET", got)
+	}
+	if got := frontendReqs(); len(got) != 1 {
+		t.Errorf("got %d frontend hook requests, want 1 (successes only)", len(got))
+	}
+}
+
+func TestSuccessHookUsesClient(t *testing.T) {
+	webhook, _ := recordingServer(t, http.StatusNoContent, "")
+	n := setUpTestNotifier(t, webhook.URL, map[string]interface{}{
+		successHooksField: map[interface{}]interface{}{"my-app": "https://hooks.example.com/deployed"},
+	})
+	var hooked []string
+	transport := http.DefaultTransport
+	n.client = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method == http.MethodGet {
+			hooked = append(hooked, r.URL.String())
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		}
+		return transport.RoundTrip(r)
+	})}
+
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if len(hooked) != 1 || hooked[0] != "https://hooks.example.com/deployed" {
+		t.Errorf("got hook requests %v through the notifier client, want one", hooked)
+	}
+}
+
+func TestPostHooks(t *testing.T) {
+	webhook, _ := recordingServer(t, http.StatusNoContent, "")
+	dojo, dojoReqs := recordingServer(t, http.StatusOK, "")
+	audit, auditReqs := recordingServer(t, http.StatusOK, "")
+
+	n := setUpTestNotifier(t, webhook.URL, map[string]interface{}{
+		postHooksField: []interface{}{
+			map[interface{}]interface{}{
+				"url":       dojo.URL,
+				"condition": `build.status == Build.Status.SUCCESS && build.substitutions["_APP_NAME"].contains("backend")`,
+			},
+			map[interface{}]interface{}{
+				"url":    audit.URL,
+				"method": "post",
+			},
+		},
+	})
+
+	for _, tc := range []struct {
+		app    string
+		status cbpb.Build_Status
+	}{
+		{app: "payments-backend", status: cbpb.Build_SUCCESS},
+		{app: "payments-backend", status: cbpb.Build_FAILURE},
+		{app: "frontend", status: cbpb.Build_SUCCESS},
+	} {
+		b := testBuild()
+		b.Status = tc.status
+		b.Substitutions["_APP_NAME"] = tc.app
+		if err := n.SendNotification(context.Background(), b); err != nil {
+			t.Fatalf("SendNotification failed: %v", err)
+		}
+	}
+
+	if got := dojoReqs(); len(got) != 1 || got[0].method != http.MethodGet {
+		t.Errorf("got dojo hook requests %+v, want one GET for the successful backend build", got)
+	}
+	got := auditReqs()
+	if len(got) != 3 {
+		t.Fatalf("got %d unconditional hook requests, want 3", len(got))
+	}
+	if got[0].method != http.MethodPost {
+		t.Errorf("got unconditional hook method %q, want POST", got[0].method)
+	}
+}
+
+func TestPostHookSecretURLAndBody(t *testing.T) {
+	webhook, _ := recordingServer(t, http.StatusNoContent, "")
+	tracker, trackerReqs := recordingServer(t, http.StatusOK, "")
+
+	cfg := newTestConfig(map[string]interface{}{
+		postHooksField: []interface{}{
+			map[interface{}]interface{}{
+				"url":    map[interface{}]interface{}{"secretRef": "tracker-url"},
+				"method": "POST",
+				"body":   `{"service": "{{index .Substitutions "_APP_NAME"}}", "build": "{{.Id}}"}`,
+			},
+		},
+	})
+	cfg.Spec.Secrets = append(cfg.Spec.Secrets, &notifiers.Secret{LocalName: "tracker-url", ResourceName: "projects/p/secrets/tracker-url/versions/latest"})
+	sg := fakeSecretGetter{
+		"projects/p/secrets/webhook-url/versions/latest": webhook.URL,
+		"projects/p/secrets/tracker-url/versions/latest": tracker.URL + "/deployments",
+	}
+	n := new(discordNotifier)
+	if err := n.SetUp(context.Background(), cfg, sg, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+
+	got := trackerReqs()
+	if len(got) != 1 {
+		t.Fatalf("got %d tracker hook requests, want 1", len(got))
+	}
+	if got[0].method != http.MethodPost || got[0].path != "/deployments" {
+		t.Errorf("got %s %s, want POST /deployments", got[0].method, got[0].path)
+	}
+	if ct := got[0].header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", ct)
+	}
+	if want := `{"service": "my-app", "build": "some-build-id"}`; string(got[0].body) != want {
+		t.Errorf("got body %s, want %s", got[0].body, want)
+	}
+}
+
+func TestSetUpInvalidPostHooks(t *testing.T) {
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	for name, hooks := range map[string]interface{}{
+		"not a list":     "https://hooks.example.com",
+		"missing url":    []interface{}{map[interface{}]interface{}{"method": "GET"}},
+		"bad condition":  []interface{}{map[interface{}]interface{}{"url": "https://hooks.example.com", "condition": "build.status =="}},
+		"bad body":       []interface{}{map[interface{}]interface{}{"url": "https://hooks.example.com", "body": "{{.Id"}},
+		"missing secret": []interface{}{map[interface{}]interface{}{"url": map[interface{}]interface{}{"secretRef": "nope"}}},
+	} {
+		delivery := map[string]interface{}{postHooksField: hooks}
+		if err := new(discordNotifier).SetUp(context.Background(), newTestConfig(delivery), sg, nil); err == nil {
+			t.Errorf("%s: SetUp succeeded, want error", name)
+		}
+	}
+}
+
+func TestHookErrorStatus(t *testing.T) {
+	webhook, _ := recordingServer(t, http.StatusNoContent, "")
+	hook, hookReqs := recordingServer(t, http.StatusInternalServerError, "")
+	n := setUpTestNotifier(t, webhook.URL, map[string]interface{}{
+		successHooksField: map[interface{}]interface{}{"my-app": hook.URL},
+		postHooksField:    []interface{}{map[interface{}]interface{}{"url": hook.URL}},
+	})
+
+	buf := captureLog(t, levelInfo)
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := len(hookReqs()); got != 2 {
+		t.Fatalf("got %d hook requests, want 2", got)
+	}
+	var failures []string
+	for _, l := range logLines(t, buf) {
+		if strings.Contains(l.Message, " hook ") {
+			if l.Severity != "ERROR" || l.ResponseCode != http.StatusInternalServerError || !strings.Contains(l.Message, "status 500") {
+				t.Errorf("got hook log %+v, want an ERROR with status 500", l)
+			}
+			failures = append(failures, l.Message)
+		}
+	}
+	if len(failures) != 2 {
+		t.Errorf("got hook logs %q, want a failure for each hook", failures)
+	}
+}
+
+func TestHookErrorHidesURL(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const token = "s3cr3t-hook-token"
+	hookURL := "http://" + l.Addr().String() + "/hooks/" + token
+	l.Close()
+
+	webhook, _ := recordingServer(t, http.StatusNoContent, "")
+	n := setUpTestNotifier(t, webhook.URL, map[string]interface{}{
+		successHooksField: map[interface{}]interface{}{"my-app": hookURL},
+		postHooksField:    []interface{}{map[interface{}]interface{}{"url": hookURL}},
+	})
+	buf := captureLog(t, levelInfo)
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	failures := 0
+	for _, l := range logLines(t, buf) {
+		if strings.Contains(l.Message, token) {
+			t.Errorf("got log %q, want the hook URL left out", l.Message)
+		}
+		if strings.HasPrefix(l.Message, "Failed to call") {
+			failures++
+		}
+	}
+	if failures != 2 {
+		t.Errorf("got %d hook failures logged, want 2", failures)
+	}
+}
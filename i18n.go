@@ -0,0 +1,431 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// localeField selects the built-in message catalog, one of the keys of bundles.
+	localeField   = "locale"
+	defaultLocale = "en"
+	// translationsField is a local path or `gs://bucket/object` of a YAML file mapping message IDs to
+	// text, overriding messages of the locale.
+	translationsField = "translations"
+
+	// singularSuffix marks the variant of a counted message used when the count is 1.
+	singularSuffix = ".one"
+)
+
+// catalog maps message IDs to their text. Text may hold fmt verbs, including explicit argument
+// indexes (e.g. `%[2]d`) so translations can reorder or leave out arguments.
+type catalog map[string]string
+
+// bundles are the built-in catalogs by locale.
+var bundles = map[string]catalog{
+	"en": english,
+	"fr": french,
+	"es": spanish,
+}
+
+// english is the default catalog, which other catalogs fall back to for missing messages.
+var english = catalog{
+	"title.building":         "🔨 BUILDING",
+	"title.success":          "✅ SUCCESS",
+	"title.fixed":            "❌→✅ FIXED",
+	"title.timeout":          "⏱ TIMEOUT",
+	"title.error":            "❌ ERROR - %s",
+	"title.cancelled":        "🚫 CANCELLED",
+	"title.expired":          "⌛ EXPIRED",
+	"title.queued":           "⏳ QUEUED",
+	"title.unhandled":        "ℹ️ %s",
+	"title.awaitingApproval": "✋ AWAITING APPROVAL",
+	"title.approved":         "👍 APPROVED",
+	"title.rejected":         "👎 REJECTED",
+	"title.digest":           "📊 BUILD DIGEST",
+	"title.logTail":          "📜 Last %d log lines",
+	"title.quietHours":       "🌙 %d builds during quiet hours",
+	"title.quietHours.one":   "🌙 %d build during quiet hours",
+	"title.throttled":        "➕ %d more builds",
+	"title.throttled.one":    "➕ %d more build",
+
+	// detail renders a label and its value on a description line.
+	"detail":            "%s: %s",
+	"label.buildId":     "Build ID",
+	"label.service":     "Service",
+	"label.environment": "Environment",
+	"label.links":       "Links",
+	"label.access":      "Access",
+	"label.timeout":     "Timeout",
+	"label.incident":    "Incident",
+	"label.repository":  "Repository",
+	"label.ref":         "Ref",
+	"label.source":      "Source",
+	"label.duration":    "Duration",
+	"label.queued":      "Queued",
+	"label.artifacts":   "Artifacts",
+	"label.manifest":    "Manifest",
+	"label.approver":    "By",
+	"label.comment":     "Comment",
+	"heading.steps":     "Steps:",
+	"heading.images":    "Images:",
+
+	"link.build":     "Build",
+	"link.logs":      "Logs",
+	"link.trigger":   "Trigger",
+	"link.commit":    "Commit",
+	"link.artifacts": "Artifacts",
+
+	"text.runningFor":       "Running for %s",
+	"text.progress":         "Progress: %s %d/%d steps",
+	"text.failedStep":       "Failed step: %s (%s)",
+	"text.stepFailedAfter":  "%s after %s",
+	"text.more":             "… and %d more",
+	"text.moreFailedSteps":  "… and %d more failed steps",
+	"text.files":            "(%d files)",
+	"text.approveOrReject":  "Approve or reject",
+	"text.fixedAfter":       "Fixed after %d failed builds",
+	"text.fixedAfter.one":   "Fixed after %d failed build",
+	"text.stillFailing":     "Still failing (%[1]s consecutive)",
+	"thread.failures":       "%s failures",
+	"thread.build":          "%s build %s",
+	"digest.none":           "Last %s: no builds",
+	"digest.summary":        "Last %s: %s",
+	"digest.counts":         "%d builds, %d ✅, %d ❌",
+	"digest.slowest":        ", slowest: %s %s",
+	"button.retry":          "Retry build",
+	"button.approve":        "Approve",
+	"reply.unknownButton":   "⚠️ Unknown button.",
+	"reply.notAllowed":      "🚫 You don't have a role allowed to use this button.",
+	"reply.retryFailed":     "❌ Failed to retry the build: %s",
+	"reply.retrying":        "🔁 Retrying build %s.",
+	"reply.approveFailed":   "❌ Failed to approve the build: %s",
+	"reply.approved":        "👍 Approved build %s.",
+	"text.approvedBy":       "Approved from Discord by %s",
+	"status.STATUS_UNKNOWN": "STATUS_UNKNOWN",
+	"status.PENDING":        "PENDING",
+	"status.QUEUED":         "QUEUED",
+	"status.WORKING":        "WORKING",
+	"status.SUCCESS":        "SUCCESS",
+	"status.FAILURE":        "FAILURE",
+	"status.INTERNAL_ERROR": "INTERNAL_ERROR",
+	"status.TIMEOUT":        "TIMEOUT",
+	"status.CANCELLED":      "CANCELLED",
+	"status.EXPIRED":        "EXPIRED",
+}
+
+var french = catalog{
+	"title.building":         "🔨 EN COURS",
+	"title.success":          "✅ RÉUSSI",
+	"title.fixed":            "❌→✅ CORRIGÉ",
+	"title.timeout":          "⏱ DÉLAI DÉPASSÉ",
+	"title.error":            "❌ ERREUR - %s",
+	"title.cancelled":        "🚫 ANNULÉ",
+	"title.expired":          "⌛ EXPIRÉ",
+	"title.queued":           "⏳ EN FILE D'ATTENTE",
+	"title.unhandled":        "ℹ️ %s",
+	"title.awaitingApproval": "✋ EN ATTENTE D'APPROBATION",
+	"title.approved":         "👍 APPROUVÉ",
+	"title.rejected":         "👎 REFUSÉ",
+	"title.digest":           "📊 RÉCAPITULATIF DES BUILDS",
+	"title.logTail":          "📜 %d dernières lignes du journal",
+	"title.logTail.one":      "📜 Dernière ligne du journal",
+	"title.quietHours":       "🌙 %d builds pendant les heures calmes",
+	"title.quietHours.one":   "🌙 %d build pendant les heures calmes",
+	"title.throttled":        "➕ %d builds de plus",
+	"title.throttled.one":    "➕ %d build de plus",
+
+	"detail":            "%s : %s",
+	"label.buildId":     "ID du build",
+	"label.service":     "Service",
+	"label.environment": "Environnement",
+	"label.links":       "Liens",
+	"label.access":      "Accès",
+	"label.timeout":     "Délai",
+	"label.incident":    "Incident",
+	"label.repository":  "Dépôt",
+	"label.ref":         "Réf.",
+	"label.source":      "Source",
+	"label.duration":    "Durée",
+	"label.queued":      "En attente",
+	"label.artifacts":   "Artefacts",
+	"label.manifest":    "Manifeste",
+	"label.approver":    "Par",
+	"label.comment":     "Commentaire",
+	"heading.steps":     "Étapes :",
+	"heading.images":    "Images :",
+
+	"link.build":     "Build",
+	"link.logs":      "Journaux",
+	"link.trigger":   "Déclencheur",
+	"link.commit":    "Commit",
+	"link.artifacts": "Artefacts",
+
+	"text.runningFor":          "En cours depuis %s",
+	"text.progress":            "Progression : %s %d/%d étapes",
+	"text.failedStep":          "Étape en échec : %s (%s)",
+	"text.stepFailedAfter":     "%s après %s",
+	"text.more":                "… et %d de plus",
+	"text.moreFailedSteps":     "… et %d autres étapes en échec",
+	"text.moreFailedSteps.one": "… et %d autre étape en échec",
+	"text.files":               "(%d fichiers)",
+	"text.files.one":           "(%d fichier)",
+	"text.approveOrReject":     "Approuver ou refuser",
+	"text.fixedAfter":          "Corrigé après %d builds en échec",
+	"text.fixedAfter.one":      "Corrigé après %d build en échec",
+	"text.stillFailing":        "Toujours en échec (%[2]d échecs consécutifs)",
+	"thread.failures":          "Échecs de %s",
+	"thread.build":             "Build %[2]s de %[1]s",
+	"digest.none":              "Sur %s : aucun build",
+	"digest.summary":           "Sur %s : %s",
+	"digest.counts":            "%d builds, %d ✅, %d ❌",
+	"digest.counts.one":        "%d build, %d ✅, %d ❌",
+	"digest.slowest":           ", le plus lent : %s %s",
+	"button.retry":             "Relancer le build",
+	"button.approve":           "Approuver",
+	"reply.unknownButton":      "⚠️ Bouton inconnu.",
+	"reply.notAllowed":         "🚫 Vous n'avez pas de rôle autorisé à utiliser ce bouton.",
+	"reply.retryFailed":        "❌ Impossible de relancer le build : %s",
+	"reply.retrying":           "🔁 Relance du build %s.",
+	"reply.approveFailed":      "❌ Impossible d'approuver le build : %s",
+	"reply.approved":           "👍 Build %s approuvé.",
+	"text.approvedBy":          "Approuvé depuis Discord par %s",
+	"status.STATUS_UNKNOWN":    "INCONNU",
+	"status.PENDING":           "EN ATTENTE D'APPROBATION",
+	"status.QUEUED":            "EN FILE D'ATTENTE",
+	"status.WORKING":           "EN COURS",
+	"status.SUCCESS":           "RÉUSSI",
+	"status.FAILURE":           "ÉCHEC",
+	"status.INTERNAL_ERROR":    "ERREUR INTERNE",
+	"status.TIMEOUT":           "DÉLAI DÉPASSÉ",
+	"status.CANCELLED":         "ANNULÉ",
+	"status.EXPIRED":           "EXPIRÉ",
+}
+
+var spanish = catalog{
+	"title.building":         "🔨 EN CURSO",
+	"title.success":          "✅ CORRECTA",
+	"title.fixed":            "❌→✅ CORREGIDA",
+	"title.timeout":          "⏱ TIEMPO AGOTADO",
+	"title.error":            "❌ ERROR - %s",
+	"title.cancelled":        "🚫 CANCELADA",
+	"title.expired":          "⌛ CADUCADA",
+	"title.queued":           "⏳ EN COLA",
+	"title.unhandled":        "ℹ️ %s",
+	"title.awaitingApproval": "✋ PENDIENTE DE APROBACIÓN",
+	"title.approved":         "👍 APROBADA",
+	"title.rejected":         "👎 RECHAZADA",
+	"title.digest":           "📊 RESUMEN DE COMPILACIONES",
+	"title.logTail":          "📜 Últimas %d líneas del registro",
+	"title.logTail.one":      "📜 Última línea del registro",
+	"title.quietHours":       "🌙 %d compilaciones durante las horas de silencio",
+	"title.quietHours.one":   "🌙 %d compilación durante las horas de silencio",
+	"title.throttled":        "➕ %d compilaciones más",
+	"title.throttled.one":    "➕ %d compilación más",
+
+	"detail":            "%s: %s",
+	"label.buildId":     "ID de compilación",
+	"label.service":     "Servicio",
+	"label.environment": "Entorno",
+	"label.links":       "Enlaces",
+	"label.access":      "Acceso",
+	"label.timeout":     "Tiempo límite",
+	"label.incident":    "Incidente",
+	"label.repository":  "Repositorio",
+	"label.ref":         "Ref.",
+	"label.source":      "Origen",
+	"label.duration":    "Duración",
+	"label.queued":      "En cola",
+	"label.artifacts":   "Artefactos",
+	"label.manifest":    "Manifiesto",
+	"label.approver":    "Por",
+	"label.comment":     "Comentario",
+	"heading.steps":     "Pasos:",
+	"heading.images":    "Imágenes:",
+
+	"link.build":     "Compilación",
+	"link.logs":      "Registros",
+	"link.trigger":   "Activador",
+	"link.commit":    "Commit",
+	"link.artifacts": "Artefactos",
+
+	"text.runningFor":          "En curso desde hace %s",
+	"text.progress":            "Progreso: %s %d/%d pasos",
+	"text.failedStep":          "Paso fallido: %s (%s)",
+	"text.stepFailedAfter":     "%s tras %s",
+	"text.more":                "… y %d más",
+	"text.moreFailedSteps":     "… y %d pasos fallidos más",
+	"text.moreFailedSteps.one": "… y %d paso fallido más",
+	"text.files":               "(%d archivos)",
+	"text.files.one":           "(%d archivo)",
+	"text.approveOrReject":     "Aprobar o rechazar",
+	"text.fixedAfter":          "Corregida tras %d compilaciones fallidas",
+	"text.fixedAfter.one":      "Corregida tras %d compilación fallida",
+	"text.stillFailing":        "Sigue fallando (%[2]d.º fallo consecutivo)",
+	"thread.failures":          "Fallos de %s",
+	"thread.build":             "Compilación %[2]s de %[1]s",
+	"digest.none":              "En %s: sin compilaciones",
+	"digest.summary":           "En %s: %s",
+	"digest.counts":            "%d compilaciones, %d ✅, %d ❌",
+	"digest.counts.one":        "%d compilación, %d ✅, %d ❌",
+	"digest.slowest":           ", la más lenta: %s %s",
+	"button.retry":             "Reintentar compilación",
+	"button.approve":           "Aprobar",
+	"reply.unknownButton":      "⚠️ Botón desconocido.",
+	"reply.notAllowed":         "🚫 No tienes un rol autorizado para usar este botón.",
+	"reply.retryFailed":        "❌ No se pudo reintentar la compilación: %s",
+	"reply.retrying":           "🔁 Reintentando la compilación %s.",
+	"reply.approveFailed":      "❌ No se pudo aprobar la compilación: %s",
+	"reply.approved":           "👍 Compilación %s aprobada.",
+	"text.approvedBy":          "Aprobada desde Discord por %s",
+	"status.STATUS_UNKNOWN":    "DESCONOCIDO",
+	"status.PENDING":           "PENDIENTE",
+	"status.QUEUED":            "EN COLA",
+	"status.WORKING":           "EN CURSO",
+	"status.SUCCESS":           "CORRECTA",
+	"status.FAILURE":           "FALLIDA",
+	"status.INTERNAL_ERROR":    "ERROR INTERNO",
+	"status.TIMEOUT":           "TIEMPO AGOTADO",
+	"status.CANCELLED":         "CANCELADA",
+	"status.EXPIRED":           "CADUCADA",
+}
+
+// getMessages returns the catalog for the configured locale with any translations file applied.
+func getMessages(ctx context.Context, delivery map[string]interface{}) (catalog, error) {
+	locale, err := getString(delivery, localeField)
+	if err != nil {
+		return nil, err
+	}
+	if locale == "" {
+		locale = defaultLocale
+	}
+	bundle, ok := bundles[locale]
+	if !ok {
+		locales := make([]string, 0, len(bundles))
+		for l := range bundles {
+			locales = append(locales, l)
+		}
+		sort.Strings(locales)
+		return nil, fmt.Errorf("unknown %s %q: expected one of %s", localeField, locale, strings.Join(locales, ", "))
+	}
+	out := make(catalog, len(bundle))
+	for id, text := range bundle {
+		out[id] = text
+	}
+
+	path, err := getString(delivery, translationsField)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return out, nil
+	}
+	data, err := readTranslations(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]string
+	if err := yaml.UnmarshalStrict(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to decode translations %q: %w", path, err)
+	}
+	for id, text := range overrides {
+		if _, ok := english[strings.TrimSuffix(id, singularSuffix)]; !ok {
+			return nil, fmt.Errorf("unknown message %q in translations %q", id, path)
+		}
+		out[id] = text
+	}
+	return out, nil
+}
+
+// readTranslations reads a translations file from GCS or the local filesystem.
+func readTranslations(ctx context.Context, path string) ([]byte, error) {
+	if !strings.HasPrefix(path, "gs://") {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read translations: %w", err)
+		}
+		return data, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, "gs://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("expected delivery config field %q to be of the form gs://bucket/object, got %q", translationsField, path)
+	}
+	sc, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client for translations: %w", err)
+	}
+	defer sc.Close()
+	r, err := sc.Bucket(parts[0]).Object(parts[1]).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read translations %q: %w", path, err)
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// lookup returns the message text for the ID, falling back to the English catalog.
+func (s *discordNotifier) lookup(id string) (string, bool) {
+	if text, ok := s.messages[id]; ok {
+		return text, true
+	}
+	text, ok := english[id]
+	return text, ok
+}
+
+// text renders the message with the given arguments. Text without verbs is returned as is, so
+// translations may leave out arguments they don't need.
+func (s *discordNotifier) text(id string, args ...interface{}) string {
+	text, ok := s.lookup(id)
+	if !ok {
+		return id
+	}
+	if len(args) == 0 || !strings.Contains(text, "%") {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// textN renders a counted message, passing n as its first argument and using the message's
+// singularSuffix variant, if there is one, when n is 1.
+func (s *discordNotifier) textN(id string, n int, args ...interface{}) string {
+	if n == 1 {
+		if _, ok := s.lookup(id + singularSuffix); ok {
+			id += singularSuffix
+		}
+	}
+	return s.text(id, append([]interface{}{n}, args...)...)
+}
+
+// detail renders a labelled description line, e.g. `Build ID: 1234`.
+func (s *discordNotifier) detail(label, value string) string {
+	return s.text("detail", s.text(label), value)
+}
+
+// statusName returns the localized name of the status, or its enum name if the catalog has none.
+func (s *discordNotifier) statusName(status cbpb.Build_Status) string {
+	if text, ok := s.lookup("status." + status.String()); ok {
+		return text
+	}
+	return status.String()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestBundlesMatchEnglish(t *testing.T) {
+	for locale, bundle := range bundles {
+		for id := range english {
+			if strings.HasSuffix(id, singularSuffix) {
+				continue
+			}
+			if _, ok := bundle[id]; !ok {
+				t.Errorf("locale %q has no message %q", locale, id)
+			}
+		}
+		for id := range bundle {
+			if _, ok := english[strings.TrimSuffix(id, singularSuffix)]; !ok {
+				t.Errorf("locale %q has message %q, which English doesn't", locale, id)
+			}
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	fr := &discordNotifier{messages: french}
+	for _, tc := range []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "english default", got: new(discordNotifier).text("title.success"), want: "✅ SUCCESS"},
+		{name: "arguments", got: fr.text("title.error", fr.statusName(cbpb.Build_FAILURE)), want: "❌ ERREUR - ÉCHEC"},
+		{name: "plural", got: fr.textN("text.files", 3), want: "(3 fichiers)"},
+		{name: "singular", got: fr.textN("text.files", 1), want: "(1 fichier)"},
+		{name: "english without a singular", got: new(discordNotifier).textN("text.files", 1), want: "(1 files)"},
+		{name: "singular without verbs", got: fr.textN("title.logTail", 1), want: "📜 Dernière ligne du journal"},
+		{name: "reordered arguments", got: fr.text("text.stillFailing", ordinal(3), 3), want: "Toujours en échec (3 échecs consécutifs)"},
+		{name: "english ordinal", got: new(discordNotifier).text("text.stillFailing", ordinal(3), 3), want: "Still failing (3rd consecutive)"},
+		{name: "falls back to english", got: (&discordNotifier{messages: catalog{}}).text("label.buildId"), want: "Build ID"},
+		{name: "unknown message", got: fr.text("label.nope"), want: "label.nope"},
+		{name: "unnamed status", got: fr.statusName(cbpb.Build_Status(99)), want: "99"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.got != tc.want {
+				t.Errorf("got %q, want %q", tc.got, tc.want)
+			}
+		})
+	}
+}
+
+func writeTranslations(t *testing.T, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "translations")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "translations.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write translations: %v", err)
+	}
+	return path
+}
+
+func TestGetMessages(t *testing.T) {
+	path := writeTranslations(t, `
+title.success: "✅ DÉPLOYÉ"
+text.files.one: "(un fichier)"
+`)
+	messages, err := getMessages(context.Background(), map[string]interface{}{localeField: "fr", translationsField: path})
+	if err != nil {
+		t.Fatalf("getMessages failed: %v", err)
+	}
+	s := &discordNotifier{messages: messages}
+	for id, want := range map[string]string{
+		"title.success":   "✅ DÉPLOYÉ",
+		"text.files.one":  "(un fichier)",
+		"title.cancelled": "🚫 ANNULÉ",
+	} {
+		if got := s.text(id); got != want {
+			t.Errorf("text(%q) = %q, want %q", id, got, want)
+		}
+	}
+	if french["title.success"] != "✅ RÉUSSI" {
+		t.Error("translations modified the built-in French catalog")
+	}
+}
+
+func TestGetMessagesErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		delivery map[string]interface{}
+	}{
+		{name: "unknown locale", delivery: map[string]interface{}{localeField: "de"}},
+		{name: "locale not a string", delivery: map[string]interface{}{localeField: 1}},
+		{name: "missing file", delivery: map[string]interface{}{translationsField: "/does/not/exist.yaml"}},
+		{name: "unknown message", delivery: map[string]interface{}{translationsField: writeTranslations(t, "title.sucess: OK\n")}},
+		{name: "not a map", delivery: map[string]interface{}{translationsField: writeTranslations(t, "- title.success\n")}},
+		{name: "invalid gcs path", delivery: map[string]interface{}{translationsField: "gs://bucket-only"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := getMessages(context.Background(), tc.delivery); err == nil {
+				t.Error("getMessages succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestLocalizedMessage(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{localeField: "es"})
+	b := testBuild()
+	b.Status = cbpb.Build_FAILURE
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	want := "ID de compilación: some-build-id\n" +
+		"Servicio: my-app\n" +
+		"Entorno: " + n.environmentLabel(b) + "\n" +
+		"Enlaces: [Compilación](" + buildPageURL(b) + ") • [Registros](https://some.example.com/log/url?foo=bar)"
+	if diff := cmp.Diff("❌ ERROR - FALLIDA", msg.Embeds[0].Title); diff != "" {
+		t.Errorf("got unexpected title diff: %s", diff)
+	}
+	if diff := cmp.Diff(want, msg.Embeds[0].Description); diff != "" {
+		t.Errorf("got unexpected description diff: %s", diff)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// incidentIDLength is the number of hex characters of the Build ID digest used in an incident ID.
+const incidentIDLength = 6
+
+// validateIncidentIDFormat checks that a non-empty format has exactly one `%s` verb and no others.
+func validateIncidentIDFormat(format string) error {
+	if format == "" {
+		return nil
+	}
+	if strings.Count(format, "%") != 1 || !strings.Contains(format, "%s") {
+		return fmt.Errorf("expected %q to contain exactly one %%s verb, got %q", incidentIDFormatField, format)
+	}
+	return nil
+}
+
+// incidentID derives a short incident reference from the Build ID.
+// The same Build always yields the same ID, so redelivered notifications agree.
+func incidentID(format, buildID string) string {
+	sum := sha256.Sum256([]byte(buildID))
+	return fmt.Sprintf(format, strings.ToUpper(hex.EncodeToString(sum[:])[:incidentIDLength]))
+}
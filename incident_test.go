@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestIncidentID(t *testing.T) {
+	a := incidentID("INC-%s", "some-build-id")
+	if b := incidentID("INC-%s", "some-build-id"); a != b {
+		t.Errorf("incidentID is not stable: got %q then %q", a, b)
+	}
+	if !strings.HasPrefix(a, "INC-") || len(a) != len("INC-")+incidentIDLength {
+		t.Errorf("incidentID got %q, want INC- followed by %d characters", a, incidentIDLength)
+	}
+	if c := incidentID("INC-%s", "other-build-id"); c == a {
+		t.Errorf("incidentID got %q for two different builds", c)
+	}
+}
+
+func TestValidateIncidentIDFormat(t *testing.T) {
+	for format, wantErr := range map[string]bool{
+		"":          false,
+		"INC-%s":    false,
+		"INC":       true,
+		"INC-%d":    true,
+		"%s-%s":     true,
+		"100%-%s":   true,
+		"[#%s] ops": false,
+	} {
+		if err := validateIncidentIDFormat(format); (err != nil) != wantErr {
+			t.Errorf("validateIncidentIDFormat(%q) got error %v, want error: %v", format, err, wantErr)
+		}
+	}
+}
+
+func TestBuildMessageIncidentID(t *testing.T) {
+	n := &discordNotifier{incidentIDFormat: "INC-%s"}
+	want := "Incident: " + incidentID("INC-%s", "some-build-id")
+
+	for status, wantIncident := range map[cbpb.Build_Status]bool{
+		cbpb.Build_FAILURE: true,
+		cbpb.Build_TIMEOUT: true,
+		cbpb.Build_SUCCESS: false,
+		cbpb.Build_WORKING: false,
+	} {
+		b := &cbpb.Build{
+			Id:            "some-build-id",
+			Status:        status,
+			Substitutions: map[string]string{"_APP_NAME": "my-app"},
+		}
+		// Build the message twice to check retries share the same ID.
+		for i := 0; i < 2; i++ {
+			msg, err := n.buildMessage(b)
+			if err != nil {
+				t.Fatalf("buildMessage failed: %v", err)
+			}
+			if got := strings.Contains(msg.Embeds[0].Description, want); got != wantIncident {
+				t.Errorf("status %v: description %q contains %q = %v, want %v", status, msg.Embeds[0].Description, want, got, wantIncident)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discordtest
+
+import (
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Created is when fixture Builds are created; they start a minute later and take four more.
+var Created = time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+// Statuses are the Build statuses that Build has fixtures for.
+var Statuses = []cbpb.Build_Status{
+	cbpb.Build_STATUS_UNKNOWN,
+	cbpb.Build_PENDING,
+	cbpb.Build_QUEUED,
+	cbpb.Build_WORKING,
+	cbpb.Build_SUCCESS,
+	cbpb.Build_FAILURE,
+	cbpb.Build_INTERNAL_ERROR,
+	cbpb.Build_TIMEOUT,
+	cbpb.Build_CANCELLED,
+	cbpb.Build_EXPIRED,
+}
+
+// Build returns a triggered Build of the `my-app` service at the given status, with the steps,
+// timestamps and results Cloud Build reports at that point. Each call returns a new Build.
+func Build(status cbpb.Build_Status) *cbpb.Build {
+	b := &cbpb.Build{
+		Id:             "0f1e2d3c-4b5a-6978-8695-a4b3c2d1e0f9",
+		ProjectId:      "my-project",
+		Status:         status,
+		BuildTriggerId: "trigger-id",
+		LogUrl:         "https://console.cloud.google.com/cloud-build/builds/0f1e2d3c-4b5a-6978-8695-a4b3c2d1e0f9?project=123",
+		LogsBucket:     "gs://123.cloudbuild-logs.googleusercontent.com",
+		CreateTime:     timestamppb.New(Created),
+		Timeout:        durationpb.New(10 * time.Minute),
+		Source: &cbpb.Source{Source: &cbpb.Source_RepoSource{RepoSource: &cbpb.RepoSource{
+			RepoName: "my-repo",
+			Revision: &cbpb.RepoSource_BranchName{BranchName: "main"},
+		}}},
+		Substitutions: map[string]string{
+			"_APP_NAME":    "my-app",
+			"TRIGGER_NAME": "deploy-my-app",
+			"BRANCH_NAME":  "main",
+			"REPO_NAME":    "my-repo",
+			"COMMIT_SHA":   "0123456789abcdef0123456789abcdef01234567",
+		},
+		Steps: []*cbpb.BuildStep{
+			{Id: "build", Name: "gcr.io/cloud-builders/docker", Args: []string{"build", "-t", "gcr.io/my-project/my-app", "."}},
+			{Id: "test", Name: "gcr.io/cloud-builders/go", Args: []string{"test", "./..."}},
+			{Id: "push", Name: "gcr.io/cloud-builders/docker", Args: []string{"push", "gcr.io/my-project/my-app"}},
+		},
+	}
+
+	started := Created.Add(time.Minute)
+	finished := started.Add(4 * time.Minute)
+	stepStatuses := func(st ...cbpb.Build_Status) {
+		t := started
+		for i, s := range st {
+			b.Steps[i].Status = s
+			if s == cbpb.Build_STATUS_UNKNOWN || s == cbpb.Build_QUEUED {
+				continue
+			}
+			b.Steps[i].Timing = &cbpb.TimeSpan{StartTime: timestamppb.New(t)}
+			if s != cbpb.Build_WORKING {
+				t = t.Add(time.Minute)
+				b.Steps[i].Timing.EndTime = timestamppb.New(t)
+			}
+		}
+	}
+
+	switch status {
+	case cbpb.Build_PENDING:
+		b.Approval = &cbpb.BuildApproval{State: cbpb.BuildApproval_PENDING}
+	case cbpb.Build_QUEUED:
+		stepStatuses(cbpb.Build_QUEUED, cbpb.Build_QUEUED, cbpb.Build_QUEUED)
+	case cbpb.Build_WORKING:
+		b.StartTime = timestamppb.New(started)
+		stepStatuses(cbpb.Build_SUCCESS, cbpb.Build_WORKING, cbpb.Build_QUEUED)
+	case cbpb.Build_SUCCESS:
+		b.StartTime, b.FinishTime = timestamppb.New(started), timestamppb.New(finished)
+		stepStatuses(cbpb.Build_SUCCESS, cbpb.Build_SUCCESS, cbpb.Build_SUCCESS)
+		b.Images = []string{"gcr.io/my-project/my-app"}
+		b.Results = &cbpb.Results{Images: []*cbpb.BuiltImage{{
+			Name:   "gcr.io/my-project/my-app",
+			Digest: "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		}}}
+	case cbpb.Build_FAILURE:
+		b.StartTime, b.FinishTime = timestamppb.New(started), timestamppb.New(finished)
+		stepStatuses(cbpb.Build_SUCCESS, cbpb.Build_FAILURE, cbpb.Build_QUEUED)
+		b.FailureInfo = &cbpb.Build_FailureInfo{Type: cbpb.Build_FailureInfo_USER_BUILD_STEP, Detail: `Build step failure: build step 1 "gcr.io/cloud-builders/go" failed: step exited with non-zero status: 1`}
+	case cbpb.Build_INTERNAL_ERROR:
+		b.StartTime, b.FinishTime = timestamppb.New(started), timestamppb.New(finished)
+		stepStatuses(cbpb.Build_SUCCESS, cbpb.Build_SUCCESS, cbpb.Build_CANCELLED)
+	case cbpb.Build_TIMEOUT:
+		b.StartTime, b.FinishTime = timestamppb.New(started), timestamppb.New(started.Add(10*time.Minute))
+		stepStatuses(cbpb.Build_SUCCESS, cbpb.Build_TIMEOUT, cbpb.Build_QUEUED)
+	case cbpb.Build_CANCELLED:
+		b.StartTime, b.FinishTime = timestamppb.New(started), timestamppb.New(finished)
+		stepStatuses(cbpb.Build_SUCCESS, cbpb.Build_CANCELLED, cbpb.Build_QUEUED)
+	case cbpb.Build_EXPIRED:
+		b.FinishTime = timestamppb.New(Created.Add(time.Hour))
+	}
+	return b
+}
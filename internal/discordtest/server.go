@@ -0,0 +1,272 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package discordtest provides a fake Discord webhook API and canned Builds for end-to-end tests of
+// the notifier.
+package discordtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// WebhookPath is the path of the fake server's webhook.
+	WebhookPath = "/api/webhooks/1234567890/webhook-token"
+	// ChannelID is the channel the fake webhook posts to.
+	ChannelID = "111111111111111111"
+
+	// Discord's documented limits, checked on every message.
+	maxContent     = 2000
+	maxEmbeds      = 10
+	maxTitle       = 256
+	maxDescription = 4096
+	maxFields      = 25
+	maxEmbedText   = 6000
+)
+
+// Request is a request received by the Server.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// Message decodes the request body, as sent to the webhook.
+func (r Request) Message() (Message, error) {
+	var m Message
+	err := json.Unmarshal(r.Body, &m)
+	return m, err
+}
+
+// Message is the subset of a Discord webhook message the Server understands.
+type Message struct {
+	Content    string  `json:"content"`
+	Username   string  `json:"username"`
+	AvatarURL  string  `json:"avatar_url"`
+	ThreadName string  `json:"thread_name"`
+	Embeds     []Embed `json:"embeds"`
+}
+
+// Embed is the subset of a Discord embed the Server understands.
+type Embed struct {
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	URL         string  `json:"url"`
+	Color       int     `json:"color"`
+	Timestamp   string  `json:"timestamp"`
+	Fields      []Field `json:"fields"`
+	Footer      *struct {
+		Text string `json:"text"`
+	} `json:"footer"`
+}
+
+// Field is an embed field.
+type Field struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// Response is a canned reply to one request.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   string
+}
+
+// RateLimited is the 429 Discord replies with when a webhook's rate limit is exhausted.
+func RateLimited(retryAfterSeconds float64) Response {
+	ra := strconv.FormatFloat(retryAfterSeconds, 'f', -1, 64)
+	return Response{
+		Status: http.StatusTooManyRequests,
+		Header: http.Header{
+			"Retry-After":             {ra},
+			"X-Ratelimit-Remaining":   {"0"},
+			"X-Ratelimit-Reset-After": {ra},
+			"X-Ratelimit-Scope":       {"user"},
+		},
+		Body: fmt.Sprintf(`{"message": "You are being rate limited.", "retry_after": %s, "global": false}`, ra),
+	}
+}
+
+// BadRequest is the 400 Discord replies with when a field of the message is invalid, e.g.
+// BadRequest("embeds.0.description", "Must be 4096 or fewer in length.").
+func BadRequest(field, message string) Response {
+	errs := map[string]interface{}{"_errors": []map[string]string{{"code": "BASE_TYPE_MAX_LENGTH", "message": message}}}
+	parts := strings.Split(field, ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		errs = map[string]interface{}{parts[i]: errs}
+	}
+	body, _ := json.Marshal(map[string]interface{}{"code": 50035, "message": "Invalid Form Body", "errors": errs})
+	return Response{Status: http.StatusBadRequest, Body: string(body)}
+}
+
+// ServerError is a 5xx reply without a Discord error body.
+func ServerError(status int) Response {
+	return Response{Status: status, Body: "upstream connect error"}
+}
+
+// Server emulates a Discord webhook. Messages are validated against Discord's limits and rejected
+// with a 400 as Discord would; valid ones are answered with 204, or with the created message when
+// `wait=true` is set. Responses queued with Enqueue are sent first, one per request.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	queue    []Response
+	requests []Request
+	nextID   int
+}
+
+// NewServer starts a Server, which the caller must Close.
+func NewServer() *Server {
+	s := &Server{nextID: 1}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// WebhookURL returns the URL of the fake webhook.
+func (s *Server) WebhookURL() string {
+	return s.URL + WebhookPath
+}
+
+// Enqueue queues replies to the next requests, in order.
+func (s *Server) Enqueue(rs ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, rs...)
+}
+
+// Requests returns every request received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Header: r.Header.Clone(), Body: body})
+	var canned *Response
+	if len(s.queue) > 0 {
+		canned = &s.queue[0]
+		s.queue = s.queue[1:]
+	}
+	id := strconv.Itoa(s.nextID)
+	s.nextID++
+	s.mu.Unlock()
+
+	if canned != nil {
+		for k, vs := range canned.Header {
+			w.Header()[k] = vs
+		}
+		if strings.HasPrefix(canned.Body, "{") {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(canned.Status)
+		w.Write([]byte(canned.Body))
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == WebhookPath:
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, WebhookPath+"/messages/"):
+		id = strings.TrimPrefix(r.URL.Path, WebhookPath+"/messages/")
+	default:
+		writeJSON(w, http.StatusNotFound, `{"message": "Unknown Webhook", "code": 10015}`)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		writeJSON(w, http.StatusBadRequest, `{"message": "Cannot send an empty message", "code": 50006}`)
+		return
+	}
+	var m Message
+	if err := json.Unmarshal(body, &m); err != nil {
+		writeJSON(w, http.StatusBadRequest, `{"message": "The request body contains invalid JSON.", "code": 50109}`)
+		return
+	}
+	if bad := validate(m); bad.Status != 0 {
+		writeJSON(w, bad.Status, bad.Body)
+		return
+	}
+
+	channel := ChannelID
+	if m.ThreadName != "" {
+		channel = id
+	} else if t := r.URL.Query().Get("thread_id"); t != "" {
+		channel = t
+	}
+	if r.Method == http.MethodPost && r.URL.Query().Get("wait") != "true" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, fmt.Sprintf(`{"id": %q, "channel_id": %q}`, id, channel))
+}
+
+// validate returns the BadRequest Discord would reply with for the message, or a zero Response.
+func validate(m Message) Response {
+	if m.Content == "" && len(m.Embeds) == 0 {
+		return Response{Status: http.StatusBadRequest, Body: `{"message": "Cannot send an empty message", "code": 50006}`}
+	}
+	if utf8.RuneCountInString(m.Content) > maxContent {
+		return BadRequest("content", fmt.Sprintf("Must be %d or fewer in length.", maxContent))
+	}
+	if len(m.Embeds) > maxEmbeds {
+		return BadRequest("embeds", fmt.Sprintf("Must be %d or fewer in length.", maxEmbeds))
+	}
+	total := 0
+	for i, e := range m.Embeds {
+		if n := utf8.RuneCountInString(e.Title); n > maxTitle {
+			return BadRequest(fmt.Sprintf("embeds.%d.title", i), fmt.Sprintf("Must be %d or fewer in length.", maxTitle))
+		}
+		if n := utf8.RuneCountInString(e.Description); n > maxDescription {
+			return BadRequest(fmt.Sprintf("embeds.%d.description", i), fmt.Sprintf("Must be %d or fewer in length.", maxDescription))
+		}
+		if len(e.Fields) > maxFields {
+			return BadRequest(fmt.Sprintf("embeds.%d.fields", i), fmt.Sprintf("Must be %d or fewer in length.", maxFields))
+		}
+		total += utf8.RuneCountInString(e.Title) + utf8.RuneCountInString(e.Description)
+		for j, f := range e.Fields {
+			if f.Name == "" || f.Value == "" {
+				return BadRequest(fmt.Sprintf("embeds.%d.fields.%d", i, j), "This field is required")
+			}
+			total += utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+		}
+		if e.Footer != nil {
+			total += utf8.RuneCountInString(e.Footer.Text)
+		}
+	}
+	if total > maxEmbedText {
+		return BadRequest("embeds", fmt.Sprintf("Embed size exceeds maximum size of %d", maxEmbedText))
+	}
+	return Response{}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Discord's message size limits, in characters.
+const (
+	// maxDescriptionLength is the most characters Discord accepts in an embed description.
+	maxDescriptionLength = 4096
+	maxTitleLength       = 256
+	maxFieldNameLength   = 256
+	// maxFieldValueLength is the most characters Discord accepts in a field value.
+	maxFieldValueLength = 1024
+	maxFooterLength     = 2048
+	maxAuthorLength     = 256
+	maxContentLength    = 2000
+	// maxEmbedFields is the most fields Discord accepts in an embed.
+	maxEmbedFields = 25
+	// maxTotalEmbedLength caps the titles, descriptions, field names and values, footers and authors of
+	// all of a message's embeds combined.
+	maxTotalEmbedLength = 6000
+)
+
+// truncate shortens s to max characters, ending it with an ellipsis when cut. It cuts on rune
+// boundaries so the result stays valid UTF-8.
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	if max <= 0 {
+		return ""
+	}
+	r := []rune(s)
+	return string(r[:max-1]) + "…"
+}
+
+// embedLength counts the characters of the embed that Discord includes in maxTotalEmbedLength.
+func embedLength(e embed) int {
+	n := utf8.RuneCountInString(e.Title) + utf8.RuneCountInString(e.Description)
+	for _, f := range e.Fields {
+		n += utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+	}
+	if e.Footer != nil {
+		n += utf8.RuneCountInString(e.Footer.Text)
+	}
+	if e.Author != nil {
+		n += utf8.RuneCountInString(e.Author.Name)
+	}
+	return n
+}
+
+// limiter records what withinLimits cut so it can be logged.
+type limiter struct {
+	cuts []string
+}
+
+func (l *limiter) truncate(what, s string, max int) string {
+	t := truncate(s, max)
+	if t != s {
+		l.cuts = append(l.cuts, fmt.Sprintf("%s (%d to %d characters)", what, utf8.RuneCountInString(s), max))
+	}
+	return t
+}
+
+// withinLimits returns the message cut down to Discord's size limits, so that it isn't rejected with
+// a 400. Each over-long part is truncated with an ellipsis, and if the embeds are still too large
+// combined, the later embeds' descriptions are shortened and then dropped, since those hold extras
+// such as log tails. Whatever was cut is logged. The original message is returned unchanged if it fits.
+func withinLimits(msg *discordMessage) *discordMessage {
+	var l limiter
+	m := *msg
+	m.Content = l.truncate("content", m.Content, maxContentLength)
+	embeds := msg.Embeds
+	if len(embeds) > maxEmbeds {
+		l.cuts = append(l.cuts, fmt.Sprintf("%d embeds over the limit of %d", len(embeds)-maxEmbeds, maxEmbeds))
+		embeds = embeds[:maxEmbeds]
+	}
+	m.Embeds = make([]embed, len(embeds))
+	total := 0
+	for i, e := range embeds {
+		e.Title = l.truncate(fmt.Sprintf("embed %d title", i), e.Title, maxTitleLength)
+		e.Description = l.truncate(fmt.Sprintf("embed %d description", i), e.Description, maxDescriptionLength)
+		if len(e.Fields) > maxEmbedFields {
+			l.cuts = append(l.cuts, fmt.Sprintf("embed %d: %d fields over the limit of %d", i, len(e.Fields)-maxEmbedFields, maxEmbedFields))
+			e.Fields = e.Fields[:maxEmbedFields]
+		}
+		fields := make([]embedField, len(e.Fields))
+		for j, f := range e.Fields {
+			f.Name = l.truncate(fmt.Sprintf("embed %d field %q name", i, f.Name), f.Name, maxFieldNameLength)
+			f.Value = l.truncate(fmt.Sprintf("embed %d field %q value", i, f.Name), f.Value, maxFieldValueLength)
+			fields[j] = f
+		}
+		if e.Fields != nil {
+			e.Fields = fields
+		}
+		if e.Footer != nil {
+			e.Footer = &embedFooter{Text: l.truncate(fmt.Sprintf("embed %d footer", i), e.Footer.Text, maxFooterLength)}
+		}
+		if e.Author != nil {
+			e.Author = &embedAuthor{Name: l.truncate(fmt.Sprintf("embed %d author", i), e.Author.Name, maxAuthorLength)}
+		}
+		m.Embeds[i] = e
+		total += embedLength(e)
+	}
+
+	for i := len(m.Embeds) - 1; i >= 0 && total > maxTotalEmbedLength; i-- {
+		e := &m.Embeds[i]
+		over := total - maxTotalEmbedLength
+		desc := utf8.RuneCountInString(e.Description)
+		if desc > over {
+			e.Description = l.truncate(fmt.Sprintf("embed %d description to fit the %d character total", i, maxTotalEmbedLength), e.Description, desc-over)
+			total -= over
+			break
+		}
+		if i == 0 {
+			e.Description = l.truncate(fmt.Sprintf("embed %d description to fit the %d character total", i, maxTotalEmbedLength), e.Description, 0)
+			total -= desc
+			break
+		}
+		l.cuts = append(l.cuts, fmt.Sprintf("embed %d to fit the %d character total", i, maxTotalEmbedLength))
+		total -= embedLength(*e)
+		m.Embeds = m.Embeds[:i]
+	}
+
+	if len(l.cuts) == 0 {
+		return msg
+	}
+	notifierLog.Warningf("cut message down to Discord's limits: %s", strings.Join(l.cuts, "; "))
+	return &m
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	for name, desc := range map[string]string{
+		"ascii":     strings.Repeat("a", maxDescriptionLength+100),
+		"multibyte": strings.Repeat("é🔨", maxDescriptionLength),
+	} {
+		got := truncate(desc, maxDescriptionLength)
+		if n := utf8.RuneCountInString(got); n != maxDescriptionLength {
+			t.Errorf("%s: got %d characters, want %d", name, n, maxDescriptionLength)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: got invalid UTF-8", name)
+		}
+		if !strings.HasSuffix(got, "…") {
+			t.Errorf("%s: got %q, want it to end with an ellipsis", name, got[len(got)-10:])
+		}
+	}
+
+	short := "Build ID: some-build-id"
+	if got := truncate(short, maxDescriptionLength); got != short {
+		t.Errorf("truncate(%q) got %q, want it unchanged", short, got)
+	}
+	if got := withinLimits(&discordMessage{Embeds: []embed{{Description: short}}}); got.Embeds[0].Description != short {
+		t.Errorf("withinLimits changed description %q to %q, want it unchanged", short, got.Embeds[0].Description)
+	}
+}
+
+func TestSendNotificationTruncatesDescription(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusNoContent, "")
+	n := setUpTestNotifier(t, srv.URL, nil)
+	b := testBuild()
+	b.LogUrl = "https://logs.example.com/" + strings.Repeat("🔨", maxDescriptionLength)
+
+	if err := n.SendNotification(context.Background(), b); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	got := reqs()
+	if len(got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(got))
+	}
+	var msg discordMessage
+	if err := json.Unmarshal(got[0].body, &msg); err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	if n := utf8.RuneCountInString(msg.Embeds[0].Description); n > maxDescriptionLength {
+		t.Errorf("got a description of %d characters, want at most %d", n, maxDescriptionLength)
+	}
+}
+
+func TestWithinLimits(t *testing.T) {
+	long := func(n int) string { return strings.Repeat("x", n) }
+	msg := &discordMessage{
+		Content: long(maxContentLength + 1),
+		Embeds: []embed{{
+			Title:       long(maxTitleLength + 1),
+			Description: long(3000),
+			Fields:      []embedField{{Name: long(maxFieldNameLength + 1), Value: long(maxFieldValueLength + 1)}},
+			Footer:      &embedFooter{Text: "my-project-id"},
+		}, {
+			Title:       "📜 Last 30 log lines",
+			Description: long(1500),
+		}, {
+			Title:       "📜 Extra",
+			Description: long(1500),
+		}},
+	}
+	for i := 0; i < maxEmbeds; i++ {
+		msg.Embeds = append(msg.Embeds, embed{Title: "extra"})
+	}
+
+	got := withinLimits(msg)
+	if n := utf8.RuneCountInString(got.Content); n != maxContentLength {
+		t.Errorf("got content of %d characters, want %d", n, maxContentLength)
+	}
+	if len(got.Embeds) > maxEmbeds {
+		t.Errorf("got %d embeds, want at most %d", len(got.Embeds), maxEmbeds)
+	}
+	total := 0
+	for _, e := range got.Embeds {
+		total += embedLength(e)
+	}
+	if total > maxTotalEmbedLength {
+		t.Errorf("got embeds of %d characters combined, want at most %d", total, maxTotalEmbedLength)
+	}
+	first := got.Embeds[0]
+	if n := utf8.RuneCountInString(first.Title); n != maxTitleLength {
+		t.Errorf("got title of %d characters, want %d", n, maxTitleLength)
+	}
+	if n := utf8.RuneCountInString(first.Fields[0].Value); n != maxFieldValueLength {
+		t.Errorf("got field value of %d characters, want %d", n, maxFieldValueLength)
+	}
+	if first.Description != msg.Embeds[0].Description {
+		t.Error("got the first embed's description shortened, want the later embeds cut first")
+	}
+	if msg.Embeds[0].Title != long(maxTitleLength+1) {
+		t.Error("withinLimits modified the original message")
+	}
+
+	fits := &discordMessage{Content: "hi", Embeds: []embed{{Title: "✅ SUCCESS", Description: "ok"}}}
+	if got := withinLimits(fits); got != fits {
+		t.Errorf("withinLimits got %+v for a message within the limits, want it unchanged", got)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// consoleURL is the base of Cloud Console links.
+const consoleURL = "https://console.cloud.google.com"
+
+// link is a labelled URL rendered as a Markdown link.
+type link struct {
+	label string
+	url   string
+}
+
+// buildRegion returns the region from the Build's `projects/p/locations/r/builds/id` name, or "" for
+// global Builds and Builds without a name.
+func buildRegion(build *cbpb.Build) string {
+	parts := strings.Split(build.Name, "/")
+	if len(parts) == 6 && parts[2] == "locations" && parts[3] != "global" {
+		return parts[3]
+	}
+	return ""
+}
+
+// consolePath returns a Cloud Build page in the Console, scoped to the Build's region when it has one,
+// e.g. `.../cloud-build/builds;region=us-central1/<id>?project=<project>`.
+func consolePath(build *cbpb.Build, page, rest string) string {
+	u := consoleURL + "/cloud-build/" + page
+	if r := buildRegion(build); r != "" {
+		u += ";region=" + r
+	}
+	return u + "/" + rest + "?project=" + url.QueryEscape(build.ProjectId)
+}
+
+// buildPageURL links to the Build's page in Cloud Console, or "" if the Build lacks an ID or project.
+func buildPageURL(build *cbpb.Build) string {
+	if build.Id == "" || build.ProjectId == "" {
+		return ""
+	}
+	return consolePath(build, "builds", url.PathEscape(build.Id))
+}
+
+// triggerURL links to the edit page of the trigger that started the Build, or "" for manual Builds.
+func triggerURL(build *cbpb.Build) string {
+	if build.BuildTriggerId == "" || build.ProjectId == "" {
+		return ""
+	}
+	return consolePath(build, "triggers", "edit/"+url.PathEscape(build.BuildTriggerId))
+}
+
+// artifactsURL links to the Cloud Console browser for the Build's uploaded artifacts, or "" if it has none.
+func artifactsURL(build *cbpb.Build) string {
+	loc := build.Artifacts.GetObjects().GetLocation()
+	if !strings.HasPrefix(loc, "gs://") {
+		return ""
+	}
+	return consoleURL + "/storage/browser/" + strings.TrimSuffix(strings.TrimPrefix(loc, "gs://"), "/")
+}
+
+// links returns the direct links for the Build: its Console page, log, trigger, commit and artifacts.
+// Unknown links are left out, as is a log URL that duplicates the Console page.
+func (s *discordNotifier) links(build *cbpb.Build, src sourceInfo) []link {
+	var out []link
+	page := buildPageURL(build)
+	if page != "" {
+		out = append(out, link{s.text("link.build"), page})
+	}
+	if build.LogUrl != "" && build.LogUrl != page {
+		out = append(out, link{s.text("link.logs"), build.LogUrl})
+	}
+	if u := triggerURL(build); u != "" {
+		out = append(out, link{s.text("link.trigger"), u})
+	}
+	if src.commitURL != "" {
+		out = append(out, link{s.text("link.commit"), src.commitURL})
+	}
+	if u := artifactsURL(build); u != "" {
+		out = append(out, link{s.text("link.artifacts"), u})
+	}
+	return out
+}
+
+// linkRow renders the links as a row of Markdown links, e.g. `[Build](...) • [Logs](...)`.
+func linkRow(links []link) string {
+	parts := make([]string, 0, len(links))
+	for _, l := range links {
+		parts = append(parts, "["+l.label+"]("+l.url+")")
+	}
+	return strings.Join(parts, " • ")
+}
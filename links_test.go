@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestLinks(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		build *cbpb.Build
+		want  string
+	}{{
+		name: "global build",
+		build: &cbpb.Build{
+			Id:        "some-build-id",
+			ProjectId: "my-project-id",
+			LogUrl:    "https://logs.example.com/some-build-id",
+		},
+		want: "[Build](https://console.cloud.google.com/cloud-build/builds/some-build-id?project=my-project-id) • " +
+			"[Logs](https://logs.example.com/some-build-id)",
+	}, {
+		name: "regional trigger build",
+		build: &cbpb.Build{
+			Name:           "projects/my-project-id/locations/us-central1/builds/some-build-id",
+			Id:             "some-build-id",
+			ProjectId:      "my-project-id",
+			BuildTriggerId: "some-trigger-id",
+			Substitutions: map[string]string{
+				"COMMIT_SHA":     "0123456789abcdef",
+				"_HEAD_REPO_URL": "https://github.com/acme/app",
+			},
+			Artifacts: &cbpb.Artifacts{Objects: &cbpb.Artifacts_ArtifactObjects{Location: "gs://my-bucket/artifacts/"}},
+		},
+		want: "[Build](https://console.cloud.google.com/cloud-build/builds;region=us-central1/some-build-id?project=my-project-id) • " +
+			"[Trigger](https://console.cloud.google.com/cloud-build/triggers;region=us-central1/edit/some-trigger-id?project=my-project-id) • " +
+			"[Commit](https://github.com/acme/app/commit/0123456789abcdef) • " +
+			"[Artifacts](https://console.cloud.google.com/storage/browser/my-bucket/artifacts)",
+	}, {
+		name: "log URL is the build page",
+		build: &cbpb.Build{
+			Name:      "projects/my-project-id/locations/global/builds/some-build-id",
+			Id:        "some-build-id",
+			ProjectId: "my-project-id",
+			LogUrl:    "https://console.cloud.google.com/cloud-build/builds/some-build-id?project=my-project-id",
+		},
+		want: "[Build](https://console.cloud.google.com/cloud-build/builds/some-build-id?project=my-project-id)",
+	}, {
+		name:  "nothing known",
+		build: &cbpb.Build{},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := new(discordNotifier)
+			got := linkRow(n.links(tc.build, n.source(tc.build)))
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("linkRow got unexpected diff: %s", diff)
+			}
+		})
+	}
+}
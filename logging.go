@@ -0,0 +1,183 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// logLevelField sets the lowest severity the notifier logs: debug, info (the default), warning or error.
+const logLevelField = "logLevel"
+
+// logLevel orders log entries by severity.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarning
+	levelError
+)
+
+// levelNames maps logLevelField values to log levels.
+var levelNames = map[string]logLevel{
+	"debug":   levelDebug,
+	"info":    levelInfo,
+	"warning": levelWarning,
+	"error":   levelError,
+}
+
+// String returns the Cloud Logging severity for the level.
+func (l logLevel) String() string {
+	switch l {
+	case levelDebug:
+		return "DEBUG"
+	case levelWarning:
+		return "WARNING"
+	case levelError:
+		return "ERROR"
+	default:
+		return "INFO"
+	}
+}
+
+// notifierLog is the process-wide logger. Its level is set by SetUp.
+var notifierLog = &logger{w: os.Stderr, level: levelInfo}
+
+// logger writes one JSON object per line, which Cloud Logging on Cloud Run parses into a structured
+// entry with its severity and the correlation fields as labels to filter on.
+type logger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level logLevel
+}
+
+// logFields correlate a log entry with the Build and Discord response it is about.
+type logFields struct {
+	BuildID      string `json:"build_id,omitempty"`
+	ProjectID    string `json:"project_id,omitempty"`
+	Status       string `json:"status,omitempty"`
+	Trigger      string `json:"trigger,omitempty"`
+	ResponseCode int    `json:"response_code,omitempty"`
+}
+
+// logLine is the JSON form of a log entry.
+type logLine struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	logFields
+}
+
+func (l *logger) setLevel(level logLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+func (l *logger) getLevel() logLevel {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level
+}
+
+func (l *logger) log(level logLevel, f logFields, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.level {
+		return
+	}
+	// Marshaling only strings and ints can't fail.
+	line, _ := json.Marshal(logLine{Severity: level.String(), Message: fmt.Sprintf(format, args...), logFields: f})
+	l.w.Write(append(line, '\n'))
+}
+
+// fieldLogger logs with a fixed set of correlation fields.
+type fieldLogger struct {
+	l *logger
+	logFields
+}
+
+// Infof logs an entry that isn't about a single Build.
+func (l *logger) Infof(format string, args ...interface{}) {
+	l.log(levelInfo, logFields{}, format, args...)
+}
+
+// Warningf logs an entry that isn't about a single Build.
+func (l *logger) Warningf(format string, args ...interface{}) {
+	l.log(levelWarning, logFields{}, format, args...)
+}
+
+// Errorf logs an entry that isn't about a single Build.
+func (l *logger) Errorf(format string, args ...interface{}) {
+	l.log(levelError, logFields{}, format, args...)
+}
+
+// buildLog returns an entry carrying the Build's ID, project, status and trigger.
+func buildLog(build *cbpb.Build) fieldLogger {
+	if build == nil {
+		return fieldLogger{l: notifierLog}
+	}
+	return fieldLogger{l: notifierLog, logFields: logFields{
+		BuildID:   build.Id,
+		ProjectID: build.ProjectId,
+		Status:    build.Status.String(),
+		Trigger:   triggerName(build),
+	}}
+}
+
+// withResponseCode adds the HTTP status code Discord (or another sink) responded with.
+func (e fieldLogger) withResponseCode(code int) fieldLogger {
+	e.ResponseCode = code
+	return e
+}
+
+func (e fieldLogger) Debugf(format string, args ...interface{}) {
+	e.l.log(levelDebug, e.logFields, format, args...)
+}
+
+func (e fieldLogger) Infof(format string, args ...interface{}) {
+	e.l.log(levelInfo, e.logFields, format, args...)
+}
+
+func (e fieldLogger) Warningf(format string, args ...interface{}) {
+	e.l.log(levelWarning, e.logFields, format, args...)
+}
+
+func (e fieldLogger) Errorf(format string, args ...interface{}) {
+	e.l.log(levelError, e.logFields, format, args...)
+}
+
+// getLogLevel returns the optional log level field from the given delivery config, defaulting to info.
+func getLogLevel(delivery map[string]interface{}) (logLevel, error) {
+	name, err := getString(delivery, logLevelField)
+	if err != nil {
+		return 0, err
+	}
+	if name == "" {
+		return levelInfo, nil
+	}
+	level, ok := levelNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown %s %q: expected one of debug, info, warning or error", logLevelField, name)
+	}
+	return level, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// captureLog redirects notifierLog to a buffer at the given level for the rest of the test.
+func captureLog(t *testing.T, level logLevel) *bytes.Buffer {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	w, old := notifierLog.w, notifierLog.level
+	notifierLog.w, notifierLog.level = buf, level
+	t.Cleanup(func() { notifierLog.w, notifierLog.level = w, old })
+	return buf
+}
+
+// logLines decodes the JSON log lines written to buf.
+func logLines(t *testing.T, buf *bytes.Buffer) []logLine {
+	t.Helper()
+	var lines []logLine
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if l == "" {
+			continue
+		}
+		var line logLine
+		if err := json.Unmarshal([]byte(l), &line); err != nil {
+			t.Fatalf("failed to unmarshal log line %q: %v", l, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestBuildLog(t *testing.T) {
+	buf := captureLog(t, levelInfo)
+	build := testBuild()
+	build.Substitutions["TRIGGER_NAME"] = "deploy-prod"
+
+	buildLog(build).withResponseCode(429).Warningf("retrying in %v", "1s")
+	buildLog(build).Debugf("not logged at info")
+	notifierLog.Errorf("no build")
+
+	want := []logLine{{
+		Severity: "WARNING",
+		Message:  "retrying in 1s",
+		logFields: logFields{
+			BuildID:      "some-build-id",
+			ProjectID:    "my-project-id",
+			Status:       "SUCCESS",
+			Trigger:      "deploy-prod",
+			ResponseCode: 429,
+		},
+	}, {
+		Severity: "ERROR",
+		Message:  "no build",
+	}}
+	if diff := cmp.Diff(want, logLines(t, buf), cmp.AllowUnexported(logLine{})); diff != "" {
+		t.Errorf("log lines diff: (-want +got):\n%s", diff)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	buf := captureLog(t, levelInfo)
+	setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{logLevelField: "error"})
+	if notifierLog.level != levelError {
+		t.Errorf("level = %v, want %v", notifierLog.level, levelError)
+	}
+	buildLog(testBuild()).Warningf("dropped")
+	if buf.Len() != 0 {
+		t.Errorf("got log output %q, want none below the error level", buf.String())
+	}
+
+	n := new(discordNotifier)
+	cfg := newTestConfig(map[string]interface{}{logLevelField: "verbose"})
+	if err := n.SetUp(context.Background(), cfg, fakeSecretGetter{}, nil); err == nil {
+		t.Error("SetUp succeeded with an unknown log level, want error")
+	}
+}
+
+func TestDeliveryLogsResponseCode(t *testing.T) {
+	buf := captureLog(t, levelInfo)
+	srv, _ := recordingServer(t, 204, "")
+	n := setUpTestNotifier(t, srv.URL, nil)
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+
+	var found bool
+	for _, l := range logLines(t, buf) {
+		if l.ResponseCode == 204 {
+			found = true
+			if l.BuildID != "some-build-id" || l.Status != cbpb.Build_SUCCESS.String() {
+				t.Errorf("response log line = %+v, want it to carry the Build's fields", l)
+			}
+		}
+		if strings.Contains(l.Message, srv.URL) {
+			t.Errorf("log line %q contains the webhook URL", l.Message)
+		}
+		if strings.Contains(l.Message, `"embeds"`) {
+			t.Errorf("log line %q contains the payload, want it only at debug level", l.Message)
+		}
+	}
+	if !found {
+		t.Errorf("no log line has response_code 204 in %q", buf.String())
+	}
+}
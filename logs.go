@@ -0,0 +1,163 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/storage"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// logTailBytes is how much of the end of a Build log is fetched.
+	logTailBytes = 64 * 1024
+	// maxErrorLineLength caps the highlighted error line.
+	maxErrorLineLength = 256
+	// maxLogTailLength caps the characters of an attached log tail, keeping the message well within
+	// Discord's 6000 character total for embeds.
+	maxLogTailLength = 1500
+)
+
+// logFetcher reads Build logs.
+type logFetcher interface {
+	// Tail returns up to maxBytes from the end of the Build's log.
+	Tail(ctx context.Context, build *cbpb.Build, maxBytes int64) ([]byte, error)
+}
+
+// gcsLogFetcher reads Build logs from the Build's GCS logs bucket.
+type gcsLogFetcher struct {
+	client *storage.Client
+}
+
+func (g *gcsLogFetcher) Tail(ctx context.Context, build *cbpb.Build, maxBytes int64) ([]byte, error) {
+	bucket, object, err := logObject(build)
+	if err != nil {
+		return nil, err
+	}
+	r, err := g.client.Bucket(bucket).Object(object).NewRangeReader(ctx, -maxBytes, -1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log gs://%s/%s: %w", bucket, object, err)
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// logObject returns the GCS bucket and object name holding the Build's log.
+func logObject(build *cbpb.Build) (string, string, error) {
+	if build.LogsBucket == "" {
+		return "", "", errors.New("build has no logs bucket")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(build.LogsBucket, "gs://"), "/", 2)
+	prefix := ""
+	if len(parts) == 2 && parts[1] != "" {
+		prefix = strings.TrimSuffix(parts[1], "/") + "/"
+	}
+	return parts[0], prefix + "log-" + build.Id + ".txt", nil
+}
+
+// findErrorLine returns the first line of the log matching the configured errorPattern, or "".
+func (s *discordNotifier) findErrorLine(logText []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(logText))
+	sc.Buffer(make([]byte, 0, 64*1024), logTailBytes)
+	for sc.Scan() {
+		line := sc.Text()
+		if s.errorPattern.MatchString(line) {
+			line = strings.TrimSpace(line)
+			if r := []rune(line); len(r) > maxErrorLineLength {
+				line = string(r[:maxErrorLineLength]) + "…"
+			}
+			return line
+		}
+	}
+	return ""
+}
+
+// lastLines returns up to n lines from the end of the log tail, leaving out its first line when the
+// tail may have been cut mid-line.
+func lastLines(tail []byte, n int) []string {
+	text := strings.TrimRight(string(tail), "\n")
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n")
+	if len(tail) >= logTailBytes && len(lines) > 1 {
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// logTailEmbed renders the lines as a code block, dropping the oldest ones to fit maxLogTailLength.
+func (s *discordNotifier) logTailEmbed(lines []string, color int) embed {
+	const fences = len("```\n") + len("\n```")
+	var shown []string
+	length := fences
+	for i := len(lines) - 1; i >= 0; i-- {
+		// A run of backticks would close the code block early.
+		line := strings.ReplaceAll(strings.TrimRight(lines[i], "\r"), "```", "'''")
+		n := utf8.RuneCountInString(line) + 1
+		if length+n > maxLogTailLength {
+			if len(shown) == 0 {
+				// An overlong last line keeps its end, where the error usually is.
+				r := []rune(line)
+				shown = append(shown, "…"+string(r[len(r)-(maxLogTailLength-fences-1):]))
+			}
+			break
+		}
+		length += n
+		shown = append(shown, line)
+	}
+	for i, j := 0, len(shown)-1; i < j; i, j = i+1, j-1 {
+		shown[i], shown[j] = shown[j], shown[i]
+	}
+	return embed{
+		Title:       s.textN("title.logTail", len(shown)),
+		Color:       color,
+		Description: "```\n" + strings.Join(shown, "\n") + "\n```",
+	}
+}
+
+// annotateFailure adds the first log line matching errorPattern, and the last logTailLines lines of the
+// log, to a failure message. Errors reading the log are logged rather than failing the notification.
+func (s *discordNotifier) annotateFailure(ctx context.Context, build *cbpb.Build, msg *discordMessage) {
+	if (s.errorPattern == nil && s.logTailLines == 0) || s.logs == nil || !isFailureStatus(build.Status) || len(msg.Embeds) == 0 {
+		return
+	}
+	tail, err := s.logs.Tail(ctx, build, logTailBytes)
+	if err != nil {
+		buildLog(build).Warningf("failed to fetch log tail for Build %q: %v", build.Id, err)
+		return
+	}
+	if s.errorPattern != nil {
+		if line := s.findErrorLine(tail); line != "" {
+			msg.Embeds[0].Description += "\n**" + strings.ReplaceAll(line, "*", `\*`) + "**"
+		}
+	}
+	if s.logTailLines > 0 && len(msg.Embeds) < maxEmbeds {
+		if lines := lastLines(tail, s.logTailLines); len(lines) > 0 {
+			msg.Embeds = append(msg.Embeds, s.logTailEmbed(lines, msg.Embeds[0].Color))
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+type fakeLogFetcher struct {
+	log string
+	err error
+}
+
+func (f *fakeLogFetcher) Tail(_ context.Context, _ *cbpb.Build, _ int64) ([]byte, error) {
+	return []byte(f.log), f.err
+}
+
+func TestLogObject(t *testing.T) {
+	for bucket, want := range map[string]string{
+		"gs://123.cloudbuild-logs.googleusercontent.com": "123.cloudbuild-logs.googleusercontent.com log-some-build-id.txt",
+		"gs://my-logs/builds/":                           "my-logs builds/log-some-build-id.txt",
+		"my-logs":                                        "my-logs log-some-build-id.txt",
+	} {
+		b, o, err := logObject(&cbpb.Build{Id: "some-build-id", LogsBucket: bucket})
+		if err != nil {
+			t.Fatalf("logObject(%q) failed: %v", bucket, err)
+		}
+		if got := b + " " + o; got != want {
+			t.Errorf("logObject(%q) got %q, want %q", bucket, got, want)
+		}
+	}
+	if _, _, err := logObject(&cbpb.Build{Id: "some-build-id"}); err == nil {
+		t.Error("logObject succeeded without a logs bucket, want error")
+	}
+}
+
+func TestAnnotateFailureErrorPattern(t *testing.T) {
+	logText := `Step #1: npm install
+Step #1: added 120 packages
+Step #2: npm ERR! code ELIFECYCLE
+Step #2: npm ERR! errno 1
+`
+	n := &discordNotifier{
+		errorPattern: regexp.MustCompile(`npm ERR!`),
+		logs:         &fakeLogFetcher{log: logText},
+	}
+
+	b := testBuild()
+	b.Status = cbpb.Build_FAILURE
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	n.annotateFailure(context.Background(), b, msg)
+	if got, want := msg.Embeds[0].Description, "\n**Step #2: npm ERR! code ELIFECYCLE**"; !strings.HasSuffix(got, want) {
+		t.Errorf("got description %q, want it to end with %q", got, want)
+	}
+
+	// Successful builds are left alone.
+	b.Status = cbpb.Build_SUCCESS
+	msg, err = n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	before := msg.Embeds[0].Description
+	n.annotateFailure(context.Background(), b, msg)
+	if msg.Embeds[0].Description != before {
+		t.Errorf("annotateFailure changed a SUCCESS description to %q", msg.Embeds[0].Description)
+	}
+}
+
+func TestAnnotateFailureFetchError(t *testing.T) {
+	n := &discordNotifier{
+		errorPattern: regexp.MustCompile(`ERROR`),
+		logs:         &fakeLogFetcher{err: errors.New("permission denied")},
+	}
+	b := testBuild()
+	b.Status = cbpb.Build_FAILURE
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	before := msg.Embeds[0].Description
+	n.annotateFailure(context.Background(), b, msg)
+	if msg.Embeds[0].Description != before {
+		t.Errorf("annotateFailure changed the description to %q after a fetch error", msg.Embeds[0].Description)
+	}
+}
+
+func TestSetUpInvalidErrorPattern(t *testing.T) {
+	n := new(discordNotifier)
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	if err := n.SetUp(context.Background(), newTestConfig(map[string]interface{}{errorPatternField: "npm ERR!("}), sg, nil); err == nil {
+		t.Error("SetUp succeeded with an invalid errorPattern, want error")
+	}
+}
+
+func TestAnnotateFailureLogTail(t *testing.T) {
+	logText := `Step #1: npm install
+Step #2: npm ERR! code ELIFECYCLE
+Step #2: npm ERR! in ` + "```" + `build` + "```" + `
+`
+	n := &discordNotifier{logTailLines: 2, logs: &fakeLogFetcher{log: logText}}
+	b := testBuild()
+	b.Status = cbpb.Build_FAILURE
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	n.annotateFailure(context.Background(), b, msg)
+	if len(msg.Embeds) != 2 {
+		t.Fatalf("got %d embeds, want 2", len(msg.Embeds))
+	}
+	want := embed{
+		Title:       "📜 Last 2 log lines",
+		Color:       msg.Embeds[0].Color,
+		Description: "```\nStep #2: npm ERR! code ELIFECYCLE\nStep #2: npm ERR! in '''build'''\n```",
+	}
+	if diff := cmp.Diff(want, msg.Embeds[1]); diff != "" {
+		t.Errorf("got unexpected log tail embed diff: %s", diff)
+	}
+}
+
+func TestLogTailEmbedLength(t *testing.T) {
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, strings.Repeat("x", 99))
+	}
+	e := new(discordNotifier).logTailEmbed(lines, 0)
+	if got := len(e.Description); got > maxLogTailLength {
+		t.Errorf("got a %d character log tail, want at most %d", got, maxLogTailLength)
+	}
+	if want := "📜 Last 14 log lines"; e.Title != want {
+		t.Errorf("got title %q, want %q", e.Title, want)
+	}
+
+	e = new(discordNotifier).logTailEmbed([]string{strings.Repeat("x", 2000) + "ERROR"}, 0)
+	if got := len([]rune(e.Description)); got > maxLogTailLength {
+		t.Errorf("got a %d character log tail for an overlong line, want at most %d", got, maxLogTailLength)
+	}
+	if !strings.HasSuffix(e.Description, "ERROR\n```") {
+		t.Errorf("got log tail %q, want it to keep the end of the line", e.Description)
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	if diff := cmp.Diff([]string{"b", "c"}, lastLines([]byte("a\nb\nc\n"), 2)); diff != "" {
+		t.Errorf("lastLines got unexpected diff: %s", diff)
+	}
+	if got := lastLines([]byte("\n"), 2); got != nil {
+		t.Errorf("lastLines of an empty log got %q, want nil", got)
+	}
+	full := []byte("partial\n" + strings.Repeat("x", logTailBytes))
+	if got := lastLines(full, 5); len(got) != 1 || got[0] == "partial" {
+		t.Errorf("lastLines of a full tail got %d lines, want the partial first line left out", len(got))
+	}
+}
+
+func TestSetUpInvalidLogTailLines(t *testing.T) {
+	n := new(discordNotifier)
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	if err := n.SetUp(context.Background(), newTestConfig(map[string]interface{}{logTailLinesField: -1}), sg, nil); err == nil {
+		t.Error("SetUp succeeded with a negative logTailLines, want error")
+	}
+}
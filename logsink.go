@@ -0,0 +1,61 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// logEntry is a structured log line understood by Cloud Logging when written to stdout on Cloud Run.
+type logEntry struct {
+	Severity  string          `json:"severity"`
+	Message   string          `json:"message"`
+	BuildID   string          `json:"build_id"`
+	ProjectID string          `json:"project_id"`
+	Status    string          `json:"status"`
+	Payload   *discordMessage `json:"payload"`
+}
+
+// logSink writes messages as structured log entries instead of sending them to Discord.
+type logSink struct {
+	out io.Writer
+}
+
+func (l *logSink) name() string {
+	return sinkTypeLog
+}
+
+func (l *logSink) send(_ context.Context, build *cbpb.Build, msg *discordMessage) error {
+	line, err := json.Marshal(logEntry{
+		Severity:  "INFO",
+		Message:   "discord notification",
+		BuildID:   build.Id,
+		ProjectID: build.ProjectId,
+		Status:    build.Status.String(),
+		Payload:   msg,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal log entry: %w", err)
+	}
+	if _, err := fmt.Fprintf(l.out, "%s\n", line); err != nil {
+		return fmt.Errorf("failed to write log entry: %w", err)
+	}
+	return nil
+}
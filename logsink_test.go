@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLogDeliveryMode(t *testing.T) {
+	cfg := &notifiers.Config{
+		Spec: &notifiers.Spec{
+			Notification: &notifiers.Notification{
+				Delivery: map[string]interface{}{deliveryModeField: deliveryModeLog},
+			},
+		},
+	}
+	n := new(discordNotifier)
+	// No webhook secret is configured or available in log mode.
+	if err := n.SetUp(context.Background(), cfg, fakeSecretGetter{}, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	if len(n.sinks) != 1 {
+		t.Fatalf("got %d sinks, want 1", len(n.sinks))
+	}
+	ls, ok := n.sinks[0].(*logSink)
+	if !ok {
+		t.Fatalf("got sink %T, want *logSink", n.sinks[0])
+	}
+	var buf bytes.Buffer
+	ls.out = &buf
+	n.client = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		t.Errorf("got unexpected HTTP request to %s in log mode", r.URL)
+		return nil, errors.New("unexpected request")
+	})}
+
+	b := testBuild()
+	if err := n.SendNotification(context.Background(), b); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+
+	var got logEntry
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("failed to unmarshal log output %q: %v", buf.String(), err)
+	}
+	wantMsg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	want := logEntry{
+		Severity:  "INFO",
+		Message:   "discord notification",
+		BuildID:   b.Id,
+		ProjectID: b.ProjectId,
+		Status:    "SUCCESS",
+		Payload:   wantMsg,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("got unexpected log entry diff: %s", diff)
+	}
+}
+
+func TestSetUpInvalidDeliveryMode(t *testing.T) {
+	n := new(discordNotifier)
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	if err := n.SetUp(context.Background(), newTestConfig(map[string]interface{}{deliveryModeField: "carrier-pigeon"}), sg, nil); err == nil {
+		t.Error("SetUp succeeded with an unknown deliveryMode, want error")
+	}
+}
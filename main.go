@@ -15,25 +15,185 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/ed25519"
+	"flag"
 	"fmt"
 	"net/http"
-	"os"
+	"regexp"
 	"strings"
+	"text/template"
+	"time"
 
+	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
 	log "github.com/golang/glog"
+	"go.opentelemetry.io/otel/trace"
 	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
 )
 
 const (
 	webhookURLSecretName = "webhookUrl"
+
+	// deliveryModeField selects where notifications go: deliveryModeWebhook (the default) or deliveryModeLog.
+	deliveryModeField   = "deliveryMode"
+	deliveryModeWebhook = "webhook"
+	deliveryModeLog     = "log"
+
+	// notificationTimeoutField caps the total time spent in a single SendNotification call.
+	notificationTimeoutField = "notificationTimeout"
+
+	// skipMetricsField enables counting skipped notifications by reason.
+	skipMetricsField = "skipMetrics"
+
+	// incidentIDFormatField enables incident references on failures, e.g. `INC-%s`.
+	incidentIDFormatField = "incidentIdFormat"
+
+	// collapseFailuresField enables threading consecutive failures of a trigger.
+	collapseFailuresField = "collapseFailures"
+	// threadIDField posts every message into an existing thread.
+	threadIDField = "threadId"
+	// threadPerBuildField opens a thread per Build that receives all of its status updates.
+	threadPerBuildField = "threadPerBuild"
+
+	// contentTemplatesField maps a status name to a template for the message content.
+	contentTemplatesField = "contentTemplates"
+
+	// descriptionTemplateField and titleTemplateField are Go templates rendering the embed description
+	// and title from the Build.
+	descriptionTemplateField = "descriptionTemplate"
+	titleTemplateField       = "titleTemplate"
+
+	// commitURLTemplateField is a Go template rendering the link for a Build's commit SHA.
+	commitURLTemplateField = "commitUrlTemplate"
+
+	// showSubstitutionsField lists substitutions shown as embed fields.
+	showSubstitutionsField = "showSubstitutions"
+
+	// richEmbedsField lays embeds out with fields, a linked title and an author instead of description lines.
+	richEmbedsField = "richEmbeds"
+
+	// editInPlaceField keeps a single message per Build, edited as its status changes.
+	editInPlaceField = "editInPlace"
+
+	// usernameField and avatarURLField override the webhook's name and avatar.
+	// `$PROJECT_ID` in the username is replaced with the Build's project.
+	usernameField  = "username"
+	avatarURLField = "avatarUrl"
+	// contentPrefixField is static text placed at the start of every message's content; `$PROJECT_ID`
+	// is replaced as in the username.
+	contentPrefixField = "contentPrefix"
+
+	// notifyOnUnhandledField sends a generic embed for statuses without a dedicated one.
+	notifyOnUnhandledField = "notifyOnUnhandled"
+
+	// mentionOnFailureField is a Discord mention (e.g. `<@&123>`) pinged in the content of failure notifications.
+	mentionOnFailureField = "mentionOnFailure"
+	// mentionRolesOnFailureField and mentionUsersOnFailureField list Discord role and user IDs pinged on failures.
+	mentionRolesOnFailureField = "mentionRolesOnFailure"
+	mentionUsersOnFailureField = "mentionUsersOnFailure"
+
+	// mentionOnCancelledField also pings the failure mentions for CANCELLED and EXPIRED Builds.
+	mentionOnCancelledField = "mentionOnCancelled"
+
+	// notifyOnQueuedField sends an embed when a Build is queued.
+	notifyOnQueuedField = "notifyOnQueued"
+
+	// retryOnTimeoutField also retries webhook attempts that timed out, at the risk of duplicates.
+	retryOnTimeoutField = "retryOnTimeout"
+
+	// showElapsedField adds time since the Build was created to WORKING notifications.
+	showElapsedField = "showElapsed"
+
+	// errorPatternField is a regexp whose first match in a failed Build's log tail is highlighted.
+	errorPatternField = "errorPattern"
+	// logTailLinesField attaches the last lines of a failed Build's log.
+	logTailLinesField = "logTailLines"
+
+	// phaseSubstitutionField names the substitution (e.g. `_PHASE`) whose value selects a style from phasesField
+	// for SUCCESS notifications.
+	phaseSubstitutionField = "phaseSubstitution"
+	phasesField            = "phases"
+
+	// severityField gates notifications on the Build's status or environment.
+	severityField = "severity"
+
+	// showStepTimingsField adds a per-step duration breakdown to SUCCESS notifications.
+	showStepTimingsField = "showStepTimings"
+
+	// successHooksField maps an `_APP_NAME` to an endpoint called after its Build succeeds.
+	successHooksField = "successHooks"
+
+	// dedupeWindowField suppresses a message identical to the previous one sent to the same webhook within the window.
+	dedupeWindowField = "dedupeWindow"
+
+	// dedupeEventsField notifies each (Build ID, status) pair at most once per process, or across processes
+	// sharing the dedupeEventsBucketField location. dedupeEventsWindowField bounds how long events are remembered.
+	dedupeEventsField       = "dedupeEvents"
+	dedupeEventsWindowField = "dedupeEventsWindow"
+	dedupeEventsBucketField = "dedupeEventsBucket"
+
+	// appNameKeyField and accessURLKeyField name the substitutions shown as the Service and Access lines.
+	appNameKeyField     = "appNameKey"
+	accessURLKeyField   = "accessUrlKey"
+	defaultAppNameKey   = "_APP_NAME"
+	defaultAccessURLKey = "_URL"
+
+	// requireSubstitutionField names a substitution (e.g. `_APP_NAME`) a Build must set to be notified, and
+	// requireSubstitutionsField lists several.
+	requireSubstitutionField  = "requireSubstitution"
+	requireSubstitutionsField = "requireSubstitutions"
+
+	// maxAttemptsField and retryDelayField tune webhook retries; see defaultMaxAttempts and defaultRetryDelay.
+	maxAttemptsField = "maxAttempts"
+	retryDelayField  = "retryDelay"
+
+	// httpTimeoutField caps each HTTP request made by the notifier; it defaults to defaultHTTPTimeout.
+	httpTimeoutField = "httpTimeout"
+
+	// notifyStatusesField lists the only statuses that are notified.
+	notifyStatusesField = "notifyStatuses"
+
+	// statusStylesField maps a status name to the title and/or color of its embed.
+	statusStylesField = "statusStyles"
+
+	// showProgressField adds a finished/total steps progress bar to WORKING notifications.
+	showProgressField = "showProgress"
+
+	cancelledColor       = 9807270
+	timeoutColor         = 15844367
+	expiredColor         = 15105570
+	queuedColor          = 3447003
+	unhandledStatusColor = 9807270
+
+	// maxEmbeds is the most embeds Discord accepts in one message.
+	maxEmbeds = 10
 )
 
 func main() {
-	if err := notifiers.Main(new(discordNotifier)); err != nil {
+	flag.Parse()
+	if *dryRunFlag {
+		if err := runDryRun(context.Background()); err != nil {
+			log.Exitf("dry run failed: %v", err)
+		}
+		return
+	}
+
+	var tracer trace.Tracer
+	if exp, ok := notifiers.GetEnv(traceExporterEnv); ok {
+		tp, err := newTracerProvider(exp)
+		if err != nil {
+			log.Fatalf("fatal error: %v", err)
+		}
+		tracer = tp.Tracer(tracerName)
+	}
+	n := &reloadingNotifier{newNotifier: func() *discordNotifier { return &discordNotifier{tracer: tracer} }}
+	// notifiers.Main serves on the default mux, so these share its port.
+	http.Handle("/metrics", notifierMetrics)
+	http.HandleFunc("/healthz", healthz)
+	http.Handle(digestPath, digestHandler{n})
+	http.Handle(interactionsPath, interactionHandler{n})
+	if err := notifiers.Main(n); err != nil {
 		log.Fatalf("fatal error: %v", err)
 	}
 }
@@ -41,141 +201,879 @@ func main() {
 type discordNotifier struct {
 	filter     notifiers.EventFilter
 	webhookURL string
+	client     *http.Client
+
+	sinks []sink
+	// mirror also receives every message; its failures are logged rather than returned.
+	mirror sink
+
+	requireSubstitutions  []string
+	appNameSubstitution   string
+	accessURLSubstitution string
+	// groupEmbedsBy is the groupEmbedsByField mode; groupByApp when empty.
+	groupEmbedsBy string
+
+	retryDelay     time.Duration
+	maxAttempts    int
+	limits         rateLimits
+	retryOnTimeout bool
+
+	notificationTimeout time.Duration
+	skipMetrics         bool
+
+	logPayloads         bool
+	redactSubstitutions []string
+	incidentIDFormat    string
+	mentionOnFailure    string
+	mentionRoles        []string
+	mentionUsers        []string
+	mentionOnCancelled  bool
+	approverMention     string
+	username            string
+	avatarURL           string
+	contentPrefix       string
+
+	editInPlace bool
+	richEmbeds  bool
+	formatter   messageFormatter
+	// showSubstitutions are rendered as embed fields.
+	showSubstitutions []string
+
+	// collapseFailures routes repeated failures of a trigger into a single thread.
+	collapseFailures bool
+	threadID         string
+	threadPerBuild   bool
+
+	contentTemplates map[cbpb.Build_Status]*template.Template
+	// descriptionTemplate and titleTemplate replace the built-in embed description and title when set.
+	descriptionTemplate *template.Template
+	titleTemplate       *template.Template
+	commitURLTemplate   *template.Template
+	notifyOnUnhandled   bool
+	notifyOnQueued      bool
+	showElapsed         bool
+	showStepTimings     bool
+	showProgress        bool
+
+	errorPattern *regexp.Regexp
+	logTailLines int
+	logs         logFetcher
+
+	statusStyles map[cbpb.Build_Status]embedStyle
+	// notifyStatuses is nil when every status is notified.
+	notifyStatuses    map[cbpb.Build_Status]bool
+	phaseSubstitution string
+	phases            map[string]embedStyle
+
+	severity *severityGate
+
+	environments   map[string]environmentTheme
+	environmentKey string
+
+	successHooks map[string]string
+	postHooks    []postHook
+	dedupeWindow time.Duration
+	// events is nil unless dedupeEvents is set.
+	events eventStore
+	// digest is nil unless digest is set.
+	digest *digest
+	// transitions is nil unless transitions is set.
+	transitions *transitions
+	// messages is the catalog of notification text; English when nil.
+	messages catalog
+	// quiet is nil unless quietHours is set.
+	quiet *quietHours
+	// throttle holds the throttle limits, which each webhook copies; nil unless throttle is set.
+	throttle *throttle
+
+	// buttons, interactionKey and buttonRoles are set when bot messages carry buttons, whose actions
+	// are performed by actions.
+	buttons        map[string]bool
+	interactionKey ed25519.PublicKey
+	buttonRoles    []string
+	actions        buildActions
+
+	// reloadInterval is how often a reloadingNotifier checks for config changes.
+	reloadInterval time.Duration
+
+	// tracer records delivery spans; tracing is disabled when nil.
+	tracer trace.Tracer
+
+	// now is the clock used for relative times; time.Now when nil.
+	now func() time.Time
+	// schedule runs a function after a delay; time.AfterFunc when nil.
+	schedule func(time.Duration, func())
 }
 
 type embed struct {
-	Title       string `json:"title"`
-	Color       int    `json:"color"`
-	Description string `json:"description"`
+	Title       string       `json:"title"`
+	Color       int          `json:"color"`
+	Description string       `json:"description"`
+	URL         string       `json:"url,omitempty"`
+	Author      *embedAuthor `json:"author,omitempty"`
+	Fields      []embedField `json:"fields,omitempty"`
+	Footer      *embedFooter `json:"footer,omitempty"`
+	// Timestamp is an RFC 3339 time that Discord renders beside the footer.
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+type embedFooter struct {
+	Text string `json:"text"`
 }
 
 type discordMessage struct {
-	Content string  `json:"content"`
-	Embeds  []embed `json:"embeds"`
+	Content    string  `json:"content"`
+	Embeds     []embed `json:"embeds"`
+	ThreadName string  `json:"thread_name,omitempty"`
+	// Username and AvatarURL override the webhook's default identity.
+	Username  string `json:"username,omitempty"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+	// AllowedMentions restricts who the message may ping. Nil keeps Discord's default of parsing all mentions.
+	AllowedMentions *allowedMentions `json:"allowed_mentions,omitempty"`
+	// Components holds buttons, which only bot messages may carry.
+	Components []actionRow `json:"components,omitempty"`
 }
 
 func (s *discordNotifier) SetUp(ctx context.Context, cfg *notifiers.Config, sg notifiers.SecretGetter, _ notifiers.BindingResolver) error {
-	if cfg.Spec.Notification.Filter != "" {
-		prd, err := notifiers.MakeCELPredicate(cfg.Spec.Notification.Filter)
+	level, err := getLogLevel(cfg.Spec.Notification.Delivery)
+	if err != nil {
+		return err
+	}
+	notifierLog.setLevel(level)
+
+	if s.messages, err = getMessages(ctx, cfg.Spec.Notification.Delivery); err != nil {
+		return err
+	}
+
+	notify, err := configFilter(cfg.Spec.Notification.Delivery)
+	if err != nil {
+		return err
+	}
+	if filter := combineFilters(cfg.Spec.Notification.Filter, notify); filter != "" {
+		prd, err := notifiers.MakeCELPredicate(filter)
 		if err != nil {
 			return fmt.Errorf("failed to make a CEL predicate: %w", err)
 		}
 		s.filter = prd
 	}
 
-	wuRef, err := notifiers.GetSecretRef(cfg.Spec.Notification.Delivery, webhookURLSecretName)
+	// Each webhook sink takes its own throttle, so the limits are needed before the sinks are set up.
+	if s.throttle, err = getThrottle(cfg.Spec.Notification.Delivery); err != nil {
+		return err
+	}
+	if s.sinks, s.mirror, err = s.setUpSinks(ctx, cfg, sg); err != nil {
+		return err
+	}
+
+	rs, err := getString(cfg.Spec.Notification.Delivery, requireSubstitutionField)
+	if err != nil {
+		return err
+	}
+	if rs != "" {
+		s.requireSubstitutions = append(s.requireSubstitutions, rs)
+	}
+	rss, err := getStringSlice(cfg.Spec.Notification.Delivery, requireSubstitutionsField)
+	if err != nil {
+		return err
+	}
+	s.requireSubstitutions = append(s.requireSubstitutions, rss...)
+
+	if s.appNameSubstitution, err = getString(cfg.Spec.Notification.Delivery, appNameKeyField); err != nil {
+		return err
+	}
+	if s.groupEmbedsBy, err = getGroupEmbedsBy(cfg.Spec.Notification.Delivery); err != nil {
+		return err
+	}
+	if s.accessURLSubstitution, err = getString(cfg.Spec.Notification.Delivery, accessURLKeyField); err != nil {
+		return err
+	}
+
+	if s.reloadInterval, err = getDuration(cfg.Spec.Notification.Delivery, reloadIntervalField); err != nil {
+		return err
+	}
+
+	nt, err := getDuration(cfg.Spec.Notification.Delivery, notificationTimeoutField)
 	if err != nil {
-		return fmt.Errorf("failed to get Secret ref from delivery config (%v) field %q: %w", cfg.Spec.Notification.Delivery, webhookURLSecretName, err)
+		return err
 	}
-	wuResource, err := notifiers.FindSecretResourceName(cfg.Spec.Secrets, wuRef)
+	s.notificationTimeout = nt
+
+	sm, err := getBool(cfg.Spec.Notification.Delivery, skipMetricsField)
 	if err != nil {
-		return fmt.Errorf("failed to find Secret for ref %q: %w", wuRef, err)
+		return err
 	}
-	wu, err := sg.GetSecret(ctx, wuResource)
+	s.skipMetrics = sm
+
+	if s.logPayloads, err = getBool(cfg.Spec.Notification.Delivery, logPayloadsField); err != nil {
+		return err
+	}
+	if s.redactSubstitutions, err = getStringSlice(cfg.Spec.Notification.Delivery, redactSubstitutionsField); err != nil {
+		return err
+	}
+
+	idf, err := getString(cfg.Spec.Notification.Delivery, incidentIDFormatField)
 	if err != nil {
-		return fmt.Errorf("failed to get token secret: %w", err)
+		return err
+	}
+	if err := validateIncidentIDFormat(idf); err != nil {
+		return err
+	}
+	s.incidentIDFormat = idf
+
+	if s.mentionOnFailure, err = getString(cfg.Spec.Notification.Delivery, mentionOnFailureField); err != nil {
+		return err
+	}
+	if err := validateMention(mentionOnFailureField, s.mentionOnFailure); err != nil {
+		return err
+	}
+	if s.mentionRoles, err = getMentionIDs(cfg.Spec.Notification.Delivery, mentionRolesOnFailureField); err != nil {
+		return err
+	}
+	if s.mentionUsers, err = getMentionIDs(cfg.Spec.Notification.Delivery, mentionUsersOnFailureField); err != nil {
+		return err
+	}
+	if s.mentionOnCancelled, err = getBool(cfg.Spec.Notification.Delivery, mentionOnCancelledField); err != nil {
+		return err
+	}
+	if s.approverMention, err = getString(cfg.Spec.Notification.Delivery, approverMentionField); err != nil {
+		return err
+	}
+	if err := validateMention(approverMentionField, s.approverMention); err != nil {
+		return err
+	}
+
+	if s.username, err = getString(cfg.Spec.Notification.Delivery, usernameField); err != nil {
+		return err
+	}
+	if s.avatarURL, err = getString(cfg.Spec.Notification.Delivery, avatarURLField); err != nil {
+		return err
+	}
+	if s.contentPrefix, err = getString(cfg.Spec.Notification.Delivery, contentPrefixField); err != nil {
+		return err
+	}
+
+	cf, err := getBool(cfg.Spec.Notification.Delivery, collapseFailuresField)
+	if err != nil {
+		return err
+	}
+	s.collapseFailures = cf
+
+	if s.editInPlace, err = getBool(cfg.Spec.Notification.Delivery, editInPlaceField); err != nil {
+		return err
+	}
+	if s.threadID, err = getString(cfg.Spec.Notification.Delivery, threadIDField); err != nil {
+		return err
+	}
+	if s.threadPerBuild, err = getBool(cfg.Spec.Notification.Delivery, threadPerBuildField); err != nil {
+		return err
+	}
+	if err := s.validateThreading(); err != nil {
+		return err
+	}
+	if s.richEmbeds, err = getBool(cfg.Spec.Notification.Delivery, richEmbedsField); err != nil {
+		return err
+	}
+	if s.formatter, err = s.getFormatter(cfg.Spec.Notification.Delivery); err != nil {
+		return err
+	}
+	if s.showSubstitutions, err = getStringSlice(cfg.Spec.Notification.Delivery, showSubstitutionsField); err != nil {
+		return err
+	}
+
+	cts, err := getStringMap(cfg.Spec.Notification.Delivery, contentTemplatesField)
+	if err != nil {
+		return err
+	}
+	if s.contentTemplates, err = parseStatusTemplates(contentTemplatesField, cts); err != nil {
+		return err
+	}
+
+	dt, err := getString(cfg.Spec.Notification.Delivery, descriptionTemplateField)
+	if err != nil {
+		return err
+	}
+	if s.descriptionTemplate, err = parseTemplate(descriptionTemplateField, dt); err != nil {
+		return err
+	}
+	tt, err := getString(cfg.Spec.Notification.Delivery, titleTemplateField)
+	if err != nil {
+		return err
+	}
+	if s.titleTemplate, err = parseTemplate(titleTemplateField, tt); err != nil {
+		return err
+	}
+	ct, err := getString(cfg.Spec.Notification.Delivery, commitURLTemplateField)
+	if err != nil {
+		return err
+	}
+	if s.commitURLTemplate, err = parseTemplate(commitURLTemplateField, ct); err != nil {
+		return err
+	}
+
+	nu, err := getBool(cfg.Spec.Notification.Delivery, notifyOnUnhandledField)
+	if err != nil {
+		return err
+	}
+	s.notifyOnUnhandled = nu
+
+	if s.notifyOnQueued, err = getBool(cfg.Spec.Notification.Delivery, notifyOnQueuedField); err != nil {
+		return err
+	}
+
+	rt, err := getBool(cfg.Spec.Notification.Delivery, retryOnTimeoutField)
+	if err != nil {
+		return err
+	}
+	s.retryOnTimeout = rt
+
+	rd, err := getDuration(cfg.Spec.Notification.Delivery, retryDelayField)
+	if err != nil {
+		return err
+	}
+	if rd == 0 {
+		rd = defaultRetryDelay
+	}
+	s.retryDelay = rd
+	ma, err := getInt(cfg.Spec.Notification.Delivery, maxAttemptsField)
+	if err != nil {
+		return err
+	}
+	if ma < 0 {
+		return fmt.Errorf("expected delivery config field %q to be positive, got %d", maxAttemptsField, ma)
+	}
+	s.maxAttempts = ma
+
+	ht, err := getDuration(cfg.Spec.Notification.Delivery, httpTimeoutField)
+	if err != nil {
+		return err
+	}
+	if ht == 0 {
+		ht = defaultHTTPTimeout
+	}
+	tr, err := newTransport(ctx, sg, cfg.Spec.Secrets, cfg.Spec.Notification.Delivery)
+	if err != nil {
+		return err
+	}
+	s.client = &http.Client{Timeout: ht, Transport: tr}
+
+	se, err := getBool(cfg.Spec.Notification.Delivery, showElapsedField)
+	if err != nil {
+		return err
+	}
+	s.showElapsed = se
+
+	sst, err := getBool(cfg.Spec.Notification.Delivery, showStepTimingsField)
+	if err != nil {
+		return err
+	}
+	s.showStepTimings = sst
+
+	if s.showProgress, err = getBool(cfg.Spec.Notification.Delivery, showProgressField); err != nil {
+		return err
+	}
+
+	ep, err := getString(cfg.Spec.Notification.Delivery, errorPatternField)
+	if err != nil {
+		return err
+	}
+	if ep != "" {
+		if s.errorPattern, err = regexp.Compile(ep); err != nil {
+			return fmt.Errorf("failed to compile delivery config field %q: %w", errorPatternField, err)
+		}
+	}
+	if s.logTailLines, err = getInt(cfg.Spec.Notification.Delivery, logTailLinesField); err != nil {
+		return err
+	}
+	if s.logTailLines < 0 {
+		return fmt.Errorf("expected delivery config field %q to be positive, got %d", logTailLinesField, s.logTailLines)
+	}
+	if s.errorPattern != nil || s.logTailLines > 0 {
+		sc, err := storage.NewClient(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create GCS client for build logs: %w", err)
+		}
+		s.logs = &gcsLogFetcher{client: sc}
+	}
+
+	if s.statusStyles, err = getStatusStyles(cfg.Spec.Notification.Delivery, statusStylesField); err != nil {
+		return err
+	}
+	if s.notifyStatuses, err = getStatusSet(cfg.Spec.Notification.Delivery, notifyStatusesField); err != nil {
+		return err
+	}
+	if s.phaseSubstitution, err = getString(cfg.Spec.Notification.Delivery, phaseSubstitutionField); err != nil {
+		return err
+	}
+	if s.phases, err = getStyles(cfg.Spec.Notification.Delivery, phasesField); err != nil {
+		return err
+	}
+	if len(s.phases) > 0 && s.phaseSubstitution == "" {
+		return fmt.Errorf("delivery config field %q requires %q to be set", phasesField, phaseSubstitutionField)
+	}
+
+	if s.severity, err = getSeverityGate(cfg.Spec.Notification.Delivery); err != nil {
+		return err
+	}
+	if s.environments, err = getEnvironmentThemes(cfg.Spec.Notification.Delivery); err != nil {
+		return err
+	}
+	if s.environmentKey, err = getString(cfg.Spec.Notification.Delivery, environmentKeyField); err != nil {
+		return err
+	}
+
+	if s.successHooks, err = getStringMap(cfg.Spec.Notification.Delivery, successHooksField); err != nil {
+		return err
+	}
+	if s.postHooks, err = getPostHooks(ctx, sg, cfg.Spec.Secrets, cfg.Spec.Notification.Delivery); err != nil {
+		return err
+	}
+
+	if s.dedupeWindow, err = getDuration(cfg.Spec.Notification.Delivery, dedupeWindowField); err != nil {
+		return err
+	}
+	de, err := getBool(cfg.Spec.Notification.Delivery, dedupeEventsField)
+	if err != nil {
+		return err
+	}
+	ew, err := getDuration(cfg.Spec.Notification.Delivery, dedupeEventsWindowField)
+	if err != nil {
+		return err
+	}
+	eb, err := getString(cfg.Spec.Notification.Delivery, dedupeEventsBucketField)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !de && (ew > 0 || eb != ""):
+		return fmt.Errorf("delivery config fields %q and %q require %q to be true", dedupeEventsWindowField, dedupeEventsBucketField, dedupeEventsField)
+	case de && eb != "":
+		sc, err := storage.NewClient(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create GCS client for event deduplication: %w", err)
+		}
+		if s.events, err = newGCSEventStore(sc, eb, ew, s.clock); err != nil {
+			return fmt.Errorf("invalid delivery config field %q: %w", dedupeEventsBucketField, err)
+		}
+	case de:
+		s.events = newSeenEvents(maxSeenEvents, ew, s.clock)
+	}
+
+	if s.digest, err = s.getDigest(ctx, sg, cfg.Spec.Secrets, cfg.Spec.Notification.Delivery); err != nil {
+		return err
+	}
+	if s.transitions, err = getTransitions(ctx, cfg.Spec.Notification.Delivery); err != nil {
+		return err
+	}
+	if s.quiet, err = getQuietHours(cfg.Spec.Notification.Delivery); err != nil {
+		return err
+	}
+	if s.quiet != nil && s.quiet.queue && s.webhookURL == "" {
+		return fmt.Errorf("%s.%s %q requires %q to be set", quietHoursField, quietModeField, quietModeQueue, webhookURLSecretName)
+	}
+	if err := s.getButtons(cfg.Spec.Notification.Delivery); err != nil {
+		return err
 	}
-	s.webhookURL = wu
 
 	return nil
 }
 
 func (s *discordNotifier) SendNotification(ctx context.Context, build *cbpb.Build) error {
-	if s.filter != nil && s.filter.Apply(ctx, build) {
-		return nil
+	if s.notificationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.notificationTimeout)
+		defer cancel()
+	}
+
+	ctx, span := s.startSpan(ctx, "SendNotification", build)
+	defer span.End()
+
+	notifierMetrics.attempt(build.Status.String())
+	reason, err := s.send(ctx, build)
+	// The error is logged by notifiers.Main and recorded on the span, neither of which may see secrets.
+	err = s.redactError(build, err)
+	switch {
+	case reason != "":
+		s.skip(build, reason)
+		notifierMetrics.notification("skipped", build.Status.String())
+	case err != nil:
+		notifierMetrics.notification("failed", build.Status.String())
+	default:
+		notifierMetrics.notification("sent", build.Status.String())
 	}
-	if build.Substitutions["_APP_NAME"] != "" {
-		log.Infof("sending discord webhook for Build %q (status: %q)", build.Id, build.Status)
-		msg, err := s.buildMessage(build)
+	endSpan(span, reason, err)
+	return err
+}
+
+// send builds and delivers the notification for the Build. It returns a non-empty skipReason
+// when the Build is not notified.
+func (s *discordNotifier) send(ctx context.Context, build *cbpb.Build) (skipReason, error) {
+	if s.events != nil {
+		key := eventKey(build)
+		added, err := s.events.add(ctx, key)
 		if err != nil {
-			return fmt.Errorf("failed to write discord message: %w", err)
+			// Failing open risks a duplicate, which beats a lost notification.
+			buildLog(build).Warningf("failed to check Build %q for a duplicate event, notifying anyway: %v", build.Id, err)
+		} else if !added {
+			return skipDuplicateEvent, nil
 		}
-		if msg == nil {
-			return nil
+		reason, err := s.sendOnce(ctx, build)
+		if err != nil {
+			// Let a redelivery of the event try again.
+			if rerr := s.events.remove(ctx, key); rerr != nil {
+				buildLog(build).Warningf("failed to forget Build %q event after a failed delivery: %v", build.Id, rerr)
+			}
 		}
+		return reason, err
+	}
+	return s.sendOnce(ctx, build)
+}
 
-		payload, err := json.Marshal(msg)
-		if err != nil {
-			return fmt.Errorf("Unable to marshal payload %w", err)
+// sendOnce applies the notification gates and delivers the notification for the Build.
+func (s *discordNotifier) sendOnce(ctx context.Context, build *cbpb.Build) (skipReason, error) {
+	if s.filter != nil && s.filter.Apply(ctx, build) {
+		return skipFiltered, nil
+	}
+	// Builds count towards the digest and status history even if their own notification is turned off below.
+	s.recordDigest(ctx, build)
+	transition := s.recordStatus(ctx, build)
+	if reason := s.gate(build); reason != "" {
+		if reason == skipQuietHours && s.quiet.queue && isDoneStatus(build.Status) {
+			s.queueQuiet(build)
 		}
+		return reason, nil
+	}
 
-		log.Infof("sending payload %s", string(payload))
-		resp, err := http.Post(s.webhookURL, "application/json", bytes.NewBuffer(payload))
-		if err != nil {
-			return err
+	buildLog(build).Infof("sending discord webhook for Build %q (status: %q)", build.Id, build.Status)
+	msg, err := s.messageFormatter().format(build)
+	if err != nil {
+		return "", fmt.Errorf("failed to write discord message: %w", err)
+	}
+	if msg == nil {
+		return skipUnhandledStatus, nil
+	}
+
+	s.annotateTransition(transition, msg)
+	s.runSuccessHooks(ctx, build)
+	s.annotateFailure(ctx, build, msg)
+
+	err = s.deliver(ctx, build, msg)
+	s.runPostHooks(ctx, build)
+	if reason := skipReasonOf(err); reason != "" {
+		return reason, nil
+	}
+	return "", err
+}
+
+// gate applies the notification gates after the filter, returning the reason the Build is skipped or "".
+func (s *discordNotifier) gate(build *cbpb.Build) skipReason {
+	if s.notifyStatuses != nil && !s.notifyStatuses[build.Status] {
+		return skipStatusDisabled
+	}
+	for _, sub := range s.requireSubstitutions {
+		if build.Substitutions[sub] == "" {
+			return skipMissingSubstitution
 		}
-		log.Infof("got resp %+v", resp)
 	}
-	return nil
+	if s.severity != nil && !s.severity.allows(build) {
+		return skipBelowSeverity
+	}
+	if s.quiet != nil && !isFailureStatus(build.Status) && s.quiet.active(s.clock()) {
+		return skipQuietHours
+	}
+	return ""
 }
 
 func (s *discordNotifier) buildMessage(build *cbpb.Build) (*discordMessage, error) {
 	var embeds []embed
+	groups := s.groups(build)
+	if groups == nil {
+		e, err := s.buildEmbeds(build)
+		if err != nil {
+			return nil, err
+		}
+		embeds = e
+	} else {
+		if len(groups) > maxEmbeds {
+			buildLog(build).Warningf("Build %q lists %d services, only notifying the first %d", build.Id, len(groups), maxEmbeds)
+			groups = groups[:maxEmbeds]
+		}
+		for _, b := range groups {
+			e, err := s.buildEmbeds(b)
+			if err != nil {
+				return nil, err
+			}
+			embeds = append(embeds, e...)
+		}
+	}
+	if len(embeds) == 0 {
+		return nil, nil
+	}
 
-	sourceText := ""
-	sourceRepo := build.Source.GetRepoSource()
-	log.Infof("repo info %+v", sourceRepo)
-	if sourceRepo != nil {
-		sourceText = sourceRepo.GetRepoName()
+	content, err := s.renderContent(build)
+	if err != nil {
+		return nil, err
 	}
+	if s.contentPrefix != "" {
+		prefix := strings.ReplaceAll(s.contentPrefix, "$PROJECT_ID", build.ProjectId)
+		if content == "" {
+			content = prefix
+		} else {
+			content = prefix + " " + content
+		}
+	}
+
+	content, allowed := s.withMention(build, content)
+	return &discordMessage{
+		Content:         content,
+		Embeds:          embeds,
+		Username:        strings.ReplaceAll(s.username, "$PROJECT_ID", build.ProjectId),
+		AvatarURL:       s.avatarURL,
+		AllowedMentions: allowed,
+	}, nil
+}
+
+// buildEmbeds renders the embed for the Build's status, or nil if the status isn't notified.
+func (s *discordNotifier) buildEmbeds(build *cbpb.Build) ([]embed, error) {
+	var embeds []embed
+
+	buildLog(build).Debugf("repo info %+v", build.Source.GetRepoSource())
+	src := s.source(build)
 	switch build.Status {
 	case cbpb.Build_WORKING:
+		description := s.buildDescription(build)
+		if s.showElapsed {
+			if line := s.elapsedLine(build); line != "" {
+				description += "\n" + line
+			}
+		}
+		if s.showProgress {
+			if line := s.progressLine(build); line != "" {
+				description += "\n" + line
+			}
+		}
 		embeds = append(embeds, embed{
-			Title: "🔨 BUILDING",
-			Color: 1027128,
-			Description: `Build ID: ` + build.Id + `
-Service: ` + build.Substitutions["_APP_NAME"] + `
-Environment: ` + build.ProjectId + `
-Logs: ` + build.LogUrl,
+			Title:       s.text("title.building"),
+			Color:       1027128,
+			Description: description,
 		})
 	case cbpb.Build_SUCCESS:
 		embeds = append(embeds, embed{
-			Title: "✅ SUCCESS",
-			Color: 1127128,
-			Description: `Build ID: ` + build.Id + `
-Service: ` + build.Substitutions["_APP_NAME"] + `
-Environment: ` + build.ProjectId + `
-Logs: ` + build.LogUrl + `
-Access: ` + build.Substitutions["_URL"],
+			Title:       s.text("title.success"),
+			Color:       1127128,
+			Description: s.buildDescription(build),
 		})
-		if strings.Contains(build.Substitutions["_APP_NAME"], "backend") {
-			callDojo()
+		if u := build.Substitutions[s.accessURLKey()]; u != "" {
+			embeds[0].Description += "\n" + s.detail("label.access", u)
 		}
-	case cbpb.Build_FAILURE, cbpb.Build_INTERNAL_ERROR, cbpb.Build_TIMEOUT:
+		if line := s.durationLine(build); line != "" {
+			embeds[0].Description += "\n" + line
+		}
+		if lines := s.artifactLines(build); lines != "" {
+			embeds[0].Description += "\n" + lines
+		}
+		if s.showStepTimings {
+			if timings := s.stepTimings(build); timings != "" {
+				embeds[0].Description += "\n" + timings
+			}
+		}
+	case cbpb.Build_TIMEOUT:
+		embeds = append(embeds, embed{
+			Title:       s.text("title.timeout"),
+			Color:       timeoutColor,
+			Description: s.buildDescription(build),
+		})
+		if build.Timeout != nil {
+			embeds[0].Description += "\n" + s.detail("label.timeout", formatDuration(build.Timeout.AsDuration()))
+		}
+		if line := s.failedStepLines(build); line != "" {
+			embeds[0].Description += "\n" + line
+		}
+		if line := s.durationLine(build); line != "" {
+			embeds[0].Description += "\n" + line
+		}
+	case cbpb.Build_FAILURE, cbpb.Build_INTERNAL_ERROR:
+		embeds = append(embeds, embed{
+			Title:       s.text("title.error", s.statusName(build.Status)),
+			Color:       14177041,
+			Description: s.buildDescription(build),
+		})
+		if line := s.failedStepLines(build); line != "" {
+			embeds[0].Description += "\n" + line
+		}
+		if line := s.durationLine(build); line != "" {
+			embeds[0].Description += "\n" + line
+		}
+	case cbpb.Build_CANCELLED:
+		if e, ok := s.approvalOutcomeEmbed(build); ok {
+			embeds = append(embeds, e)
+			break
+		}
+		embeds = append(embeds, embed{
+			Title:       s.text("title.cancelled"),
+			Color:       cancelledColor,
+			Description: s.buildDescription(build),
+		})
+	case cbpb.Build_EXPIRED:
 		embeds = append(embeds, embed{
-			Title: fmt.Sprintf("❌ ERROR - %s", build.Status),
-			Color: 14177041,
-			Description: `Build ID: ` + build.Id + `
-Service: ` + build.Substitutions["_APP_NAME"] + `
-Environment: ` + build.ProjectId + `
-Logs: ` + build.LogUrl,
+			Title:       s.text("title.expired"),
+			Color:       expiredColor,
+			Description: s.buildDescription(build),
 		})
+	case cbpb.Build_QUEUED:
+		if e, ok := s.approvalOutcomeEmbed(build); ok {
+			embeds = append(embeds, e)
+		} else if s.notifyOnQueued {
+			embeds = append(embeds, embed{
+				Title:       s.text("title.queued"),
+				Color:       queuedColor,
+				Description: s.buildDescription(build),
+			})
+		}
+	case cbpb.Build_PENDING:
+		embeds = append(embeds, s.pendingEmbed(build))
 
 	default:
-		log.Infof("Unknown status %s", build.Status)
-	}
-
-	if len(embeds) > 0 && len(sourceText) > 0 {
-		embeds[0].Description = sourceText
+		buildLog(build).Infof("Unknown status %s", build.Status)
+		if s.notifyOnUnhandled {
+			embeds = append(embeds, embed{
+				// String() falls back to the numeric value for statuses this proto version doesn't name.
+				Title:       s.text("title.unhandled", s.statusName(build.Status)),
+				Color:       unhandledStatusColor,
+				Description: s.buildDescription(build),
+			})
+		}
 	}
 
 	if len(embeds) == 0 {
-		log.Infof("unhandled status - skipping notification %s", build.Status)
 		return nil, nil
 	}
 
-	return &discordMessage{
-		Embeds: embeds,
-	}, nil
+	if st, ok := s.statusStyles[build.Status]; ok {
+		st.apply(&embeds[0])
+	}
+	if build.Status == cbpb.Build_SUCCESS {
+		if st, ok := s.phases[build.Substitutions[s.phaseSubstitution]]; ok {
+			st.apply(&embeds[0])
+		}
+	}
+	if t, ok := s.environmentTheme(build); ok {
+		t.apply(&embeds[0])
+	}
+
+	if s.titleTemplate != nil {
+		title, err := executeTemplate(s.titleTemplate, build)
+		if err != nil {
+			return nil, err
+		}
+		embeds[0].Title = title
+	}
+	if s.descriptionTemplate != nil {
+		desc, err := executeTemplate(s.descriptionTemplate, build)
+		if err != nil {
+			return nil, err
+		}
+		embeds[0].Description = desc
+	} else if !s.richEmbeds {
+		for _, line := range s.sourceLines(src) {
+			embeds[0].Description += "\n" + line
+		}
+	}
+
+	footer, timestamp := footerText(build), s.embedTimestamp(build)
+	for i := range embeds {
+		embeds[i].Footer = &embedFooter{Text: footer}
+		embeds[i].Timestamp = timestamp
+	}
+
+	if s.incidentIDFormat != "" && isFailureStatus(build.Status) {
+		embeds[0].Description += "\n" + s.detail("label.incident", incidentID(s.incidentIDFormat, build.Id))
+	}
+	if s.richEmbeds {
+		s.richLayout(build, &embeds[0], src)
+	}
+	embeds[0].Fields = append(embeds[0].Fields, substitutionFields(build, s.showSubstitutions, len(embeds[0].Fields))...)
+	return embeds, nil
 }
 
-func callDojo() {
-	dojoURL := os.Getenv("DOJO_URL")
-	if dojoURL != "" {
-		if _, err := http.Get(dojoURL); err != nil {
-			log.Errorf("Failed to call dojo %s", err)
-		} else {
-			log.Infof("Successfully called dojo")
+// appName returns the Build's service name from the configured app name substitution, falling back to
+// the trigger name and then the repository for Builds that don't set it.
+func (s *discordNotifier) appName(build *cbpb.Build) string {
+	return firstNonEmpty(build.Substitutions[s.appNameKey()], build.Substitutions["TRIGGER_NAME"], s.source(build).repo)
+}
+
+// appNameKey returns the substitution holding the Build's service name.
+func (s *discordNotifier) appNameKey() string {
+	if s.appNameSubstitution != "" {
+		return s.appNameSubstitution
+	}
+	return defaultAppNameKey
+}
+
+// services splits a comma-separated app name (e.g. `frontend,backend`) from a monorepo Build into its services.
+func (s *discordNotifier) services(build *cbpb.Build) []string {
+	var out []string
+	for _, svc := range strings.Split(s.appName(build), ",") {
+		if svc = strings.TrimSpace(svc); svc != "" {
+			out = append(out, svc)
 		}
 	}
+	return out
+}
+
+// accessURLKey returns the substitution holding the deployed service's URL.
+func (s *discordNotifier) accessURLKey() string {
+	if s.accessURLSubstitution != "" {
+		return s.accessURLSubstitution
+	}
+	return defaultAccessURLKey
+}
+
+// buildDescription returns the common embed description lines for the Build.
+// In rich embed mode these are fields instead, so the description is empty.
+func (s *discordNotifier) buildDescription(build *cbpb.Build) string {
+	if s.richEmbeds {
+		return ""
+	}
+	desc := s.detail("label.buildId", build.Id) + "\n" +
+		s.detail("label.service", s.appName(build)) + "\n" +
+		s.detail("label.environment", s.environmentLabel(build))
+	if row := linkRow(s.links(build, s.source(build))); row != "" {
+		desc += "\n" + s.detail("label.links", row)
+	}
+	return desc
+}
+
+// footerText identifies where the Build came from, e.g. `my-project • deploy-prod`, omitting the trigger for manual Builds.
+func footerText(build *cbpb.Build) string {
+	trigger := triggerName(build)
+	if trigger == "" {
+		return build.ProjectId
+	}
+	return build.ProjectId + " • " + trigger
+}
+
+// triggerName names the Build's trigger by its TRIGGER_NAME substitution, falling back to its ID.
+func triggerName(build *cbpb.Build) string {
+	if trigger := build.Substitutions["TRIGGER_NAME"]; trigger != "" {
+		return trigger
+	}
+	return build.BuildTriggerId
+}
+
+// embedTimestamp returns when the Build finished, or the current time if it hasn't, in RFC 3339 form.
+func (s *discordNotifier) embedTimestamp(build *cbpb.Build) string {
+	t := s.clock()
+	if build.FinishTime != nil {
+		t = build.FinishTime.AsTime()
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// isFailureStatus reports whether the given status is one of the failure states.
+func isFailureStatus(status cbpb.Build_Status) bool {
+	switch status {
+	case cbpb.Build_FAILURE, cbpb.Build_INTERNAL_ERROR, cbpb.Build_TIMEOUT:
+		return true
+	}
+	return false
 }
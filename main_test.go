@@ -1,11 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
 	"github.com/google/go-cmp/cmp"
 	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestBuildMessage(t *testing.T) {
@@ -16,9 +29,11 @@ func TestBuildMessage(t *testing.T) {
 		Status:    cbpb.Build_SUCCESS,
 		LogUrl:    "https://some.example.com/log/url?foo=bar",
 		Substitutions: map[string]string{
-			"_APP_NAME": "my-app",
-			"_URL":      "https://some.example.com",
+			"_APP_NAME":    "my-app",
+			"_URL":         "https://some.example.com",
+			"TRIGGER_NAME": "deploy-prod",
 		},
+		FinishTime: timestamppb.New(time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)),
 	}
 
 	got, err := n.buildMessage(b)
@@ -33,8 +48,10 @@ func TestBuildMessage(t *testing.T) {
 				Description: `Build ID: ` + b.Id + `
 Service: ` + b.Substitutions["_APP_NAME"] + `
 Environment: ` + b.ProjectId + `
-Logs: ` + b.LogUrl + `
+Links: [Build](https://console.cloud.google.com/cloud-build/builds/some-build-id?project=my-project-id) • [Logs](` + b.LogUrl + `)
 Access: ` + b.Substitutions["_URL"],
+				Footer:    &embedFooter{Text: "my-project-id • deploy-prod"},
+				Timestamp: "2021-02-01T12:00:00Z",
 			},
 		},
 	})
@@ -45,3 +62,483 @@ Access: ` + b.Substitutions["_URL"],
 		t.Errorf("writeMessage got unexpected diff: %s", diff)
 	}
 }
+
+type fakeSecretGetter map[string]string
+
+func (f fakeSecretGetter) GetSecret(_ context.Context, name string) (string, error) {
+	v, ok := f[name]
+	if !ok {
+		return "", errors.New("no such secret")
+	}
+	return v, nil
+}
+
+func newTestConfig(delivery map[string]interface{}) *notifiers.Config {
+	if delivery == nil {
+		delivery = map[string]interface{}{}
+	}
+	delivery[webhookURLSecretName] = map[interface{}]interface{}{"secretRef": "webhook-url"}
+	return &notifiers.Config{
+		Spec: &notifiers.Spec{
+			Notification: &notifiers.Notification{Delivery: delivery},
+			Secrets: []*notifiers.Secret{
+				{LocalName: "webhook-url", ResourceName: "projects/p/secrets/webhook-url/versions/latest"},
+			},
+		},
+	}
+}
+
+func setUpTestNotifier(t *testing.T, webhookURL string, delivery map[string]interface{}) *discordNotifier {
+	t.Helper()
+	n := new(discordNotifier)
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": webhookURL}
+	if err := n.SetUp(context.Background(), newTestConfig(delivery), sg, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	return n
+}
+
+func TestSetUpNotificationTimeout(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{notificationTimeoutField: "30s"})
+	if n.notificationTimeout != 30*time.Second {
+		t.Errorf("got notificationTimeout %v, want 30s", n.notificationTimeout)
+	}
+
+	bad := new(discordNotifier)
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	if err := bad.SetUp(context.Background(), newTestConfig(map[string]interface{}{notificationTimeoutField: "soon"}), sg, nil); err == nil {
+		t.Error("SetUp succeeded with an invalid notificationTimeout, want error")
+	}
+}
+
+func TestSendNotificationTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{notificationTimeoutField: "100ms"})
+	b := &cbpb.Build{
+		Id:            "some-build-id",
+		Status:        cbpb.Build_SUCCESS,
+		Substitutions: map[string]string{"_APP_NAME": "my-app"},
+	}
+
+	start := time.Now()
+	err := n.SendNotification(context.Background(), b)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SendNotification got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("SendNotification took %v, want it capped near the 100ms timeout", elapsed)
+	}
+}
+
+func TestNotificationTimeoutAcrossRetries(t *testing.T) {
+	slowError := func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(40 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	srv, calls := sequenceServer(t, slowError)
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{notificationTimeoutField: "100ms"})
+	n.retryDelay = 30 * time.Millisecond
+
+	start := time.Now()
+	err := n.SendNotification(context.Background(), testBuild())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SendNotification got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("SendNotification took %v, want it capped near the 100ms timeout", elapsed)
+	}
+	if got := atomic.LoadInt32(calls); got < 2 {
+		t.Errorf("got %d webhook attempts, want the timeout to span several retries", got)
+	}
+}
+
+type recordedRequest struct {
+	method string
+	path   string
+	query  map[string][]string
+	header http.Header
+	body   []byte
+}
+
+// recordingServer returns a test server that records every request and replies with the given status and body.
+func recordingServer(t *testing.T, status int, body string) (*httptest.Server, func() []recordedRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var reqs []recordedRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		reqs = append(reqs, recordedRequest{method: r.Method, path: r.URL.Path, query: r.URL.Query(), header: r.Header.Clone(), body: b})
+		mu.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []recordedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]recordedRequest(nil), reqs...)
+	}
+}
+
+func TestBuildMessageUnhandledStatus(t *testing.T) {
+	b := &cbpb.Build{
+		ProjectId:     "my-project-id",
+		Id:            "some-build-id",
+		Status:        cbpb.Build_Status(99),
+		LogUrl:        "https://some.example.com/log/url?foo=bar",
+		Substitutions: map[string]string{"_APP_NAME": "my-app"},
+	}
+
+	got, err := new(discordNotifier).buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if got != nil {
+		t.Errorf("buildMessage got %+v for an unknown status with notifyOnUnhandled unset, want nil", got)
+	}
+
+	now := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	n := &discordNotifier{notifyOnUnhandled: true, now: func() time.Time { return now }}
+	got, err = n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	want := &discordMessage{
+		Embeds: []embed{{
+			Title:       "ℹ️ 99",
+			Color:       unhandledStatusColor,
+			Description: new(discordNotifier).buildDescription(b),
+			Footer:      &embedFooter{Text: "my-project-id"},
+			Timestamp:   "2021-02-01T12:00:00Z",
+		}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("buildMessage got unexpected diff: %s", diff)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestRequireSubstitution(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		delivery map[string]interface{}
+		build    *cbpb.Build
+		wantSent bool
+	}{{
+		name:     "unset sends every build",
+		build:    testBuildWithoutApp(),
+		wantSent: true,
+	}, {
+		name:     "configured key present",
+		delivery: map[string]interface{}{requireSubstitutionField: "_TEAM"},
+		build: &cbpb.Build{
+			Id:            "some-build-id",
+			Status:        cbpb.Build_SUCCESS,
+			Substitutions: map[string]string{"_TEAM": "payments"},
+		},
+		wantSent: true,
+	}, {
+		name:     "configured key missing",
+		delivery: map[string]interface{}{requireSubstitutionField: "_TEAM"},
+		build:    testBuild(),
+	}, {
+		name:     "all listed keys present",
+		delivery: map[string]interface{}{requireSubstitutionsField: []interface{}{"_APP_NAME", "_TEAM"}},
+		build: &cbpb.Build{
+			Id:            "some-build-id",
+			Status:        cbpb.Build_SUCCESS,
+			Substitutions: map[string]string{"_APP_NAME": "my-app", "_TEAM": "payments"},
+		},
+		wantSent: true,
+	}, {
+		name:     "one listed key missing",
+		delivery: map[string]interface{}{requireSubstitutionsField: []interface{}{"_APP_NAME", "_TEAM"}},
+		build:    testBuild(),
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, reqs := recordingServer(t, http.StatusNoContent, "")
+			n := setUpTestNotifier(t, srv.URL, tc.delivery)
+			if err := n.SendNotification(context.Background(), tc.build); err != nil {
+				t.Fatalf("SendNotification failed: %v", err)
+			}
+			if got := len(reqs()) == 1; got != tc.wantSent {
+				t.Errorf("got sent=%v, want %v", got, tc.wantSent)
+			}
+		})
+	}
+}
+
+func TestAppNameFallback(t *testing.T) {
+	repo := &cbpb.Source{Source: &cbpb.Source_RepoSource{RepoSource: &cbpb.RepoSource{RepoName: "my-repo"}}}
+	for _, tc := range []struct {
+		name   string
+		subs   map[string]string
+		source *cbpb.Source
+		want   string
+	}{
+		{name: "substitution", subs: map[string]string{"_APP_NAME": "my-app", "TRIGGER_NAME": "deploy"}, source: repo, want: "my-app"},
+		{name: "trigger name", subs: map[string]string{"TRIGGER_NAME": "deploy"}, source: repo, want: "deploy"},
+		{name: "repository", source: repo, want: "my-repo"},
+		{name: "nothing", want: ""},
+	} {
+		b := &cbpb.Build{Substitutions: tc.subs, Source: tc.source}
+		if got := new(discordNotifier).appName(b); got != tc.want {
+			t.Errorf("%s: appName got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBuildMessageRepository(t *testing.T) {
+	b := testBuild()
+	b.Source = &cbpb.Source{
+		Source: &cbpb.Source_RepoSource{RepoSource: &cbpb.RepoSource{RepoName: "my-repo"}},
+	}
+
+	got, err := new(discordNotifier).buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	desc := got.Embeds[0].Description
+	for _, line := range []string{"Build ID: some-build-id", "Repository: my-repo"} {
+		if !strings.Contains(desc, line) {
+			t.Errorf("got description %q, want it to contain %q", desc, line)
+		}
+	}
+}
+
+func TestBuildMessageExtraStatuses(t *testing.T) {
+	for _, tc := range []struct {
+		status    cbpb.Build_Status
+		n         *discordNotifier
+		wantTitle string
+	}{
+		{status: cbpb.Build_CANCELLED, n: new(discordNotifier), wantTitle: "🚫 CANCELLED"},
+		{status: cbpb.Build_EXPIRED, n: new(discordNotifier), wantTitle: "⌛ EXPIRED"},
+		{status: cbpb.Build_QUEUED, n: new(discordNotifier)},
+		{status: cbpb.Build_QUEUED, n: &discordNotifier{notifyOnQueued: true}, wantTitle: "⏳ QUEUED"},
+	} {
+		b := testBuild()
+		b.Status = tc.status
+		got, err := tc.n.buildMessage(b)
+		if err != nil {
+			t.Fatalf("buildMessage failed: %v", err)
+		}
+		if tc.wantTitle == "" {
+			if got != nil {
+				t.Errorf("%s: buildMessage got %+v, want nil", tc.status, got)
+			}
+			continue
+		}
+		if got == nil || got.Embeds[0].Title != tc.wantTitle || got.Embeds[0].Description != new(discordNotifier).buildDescription(b) {
+			t.Errorf("%s: buildMessage got %+v, want a %q embed with the build description", tc.status, got, tc.wantTitle)
+		}
+	}
+}
+
+func TestBuildMessageTimeout(t *testing.T) {
+	start := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	b := testBuild()
+	b.Status = cbpb.Build_TIMEOUT
+	b.Timeout = durationpb.New(10 * time.Minute)
+	b.StartTime = timestamppb.New(start)
+	b.FinishTime = timestamppb.New(start.Add(10 * time.Minute))
+
+	n := new(discordNotifier)
+	got, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	e := got.Embeds[0]
+	if e.Title != "⏱ TIMEOUT" || e.Color != timeoutColor {
+		t.Errorf("got title %q and color %d, want %q and %d", e.Title, e.Color, "⏱ TIMEOUT", timeoutColor)
+	}
+	if want := n.buildDescription(b) + "\nTimeout: 10m\nDuration: 10m"; e.Description != want {
+		t.Errorf("got description %q, want %q", e.Description, want)
+	}
+}
+
+func TestEmbedFooterJSON(t *testing.T) {
+	b := testBuild()
+	b.BuildTriggerId = "0123-trigger"
+	b.FinishTime = timestamppb.New(time.Date(2021, 2, 1, 12, 30, 0, 0, time.UTC))
+
+	msg, err := new(discordNotifier).buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("failed to marshal message: %v", err)
+	}
+	var got struct {
+		Embeds []struct {
+			Footer struct {
+				Text string `json:"text"`
+			} `json:"footer"`
+			Timestamp string `json:"timestamp"`
+		} `json:"embeds"`
+	}
+	if err := json.Unmarshal(payload, &got); err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	if got.Embeds[0].Footer.Text != "my-project-id • 0123-trigger" {
+		t.Errorf("got footer text %q, want the project and trigger ID", got.Embeds[0].Footer.Text)
+	}
+	if got.Embeds[0].Timestamp != "2021-02-01T12:30:00Z" {
+		t.Errorf("got timestamp %q, want the finish time", got.Embeds[0].Timestamp)
+	}
+}
+
+func TestCustomSubstitutionKeys(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{
+		appNameKeyField:   "_SERVICE",
+		accessURLKeyField: "_DEPLOY_URL",
+	})
+	b := testBuild()
+	b.Substitutions = map[string]string{
+		"_SERVICE":    "payments",
+		"_DEPLOY_URL": "https://payments.example.com",
+		"_APP_NAME":   "ignored",
+		"_URL":        "https://ignored.example.com",
+	}
+
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	desc := msg.Embeds[0].Description
+	for _, line := range []string{"\nService: payments\n", "\nAccess: https://payments.example.com"} {
+		if !strings.Contains(desc, line) {
+			t.Errorf("got description %q, want it to contain %q", desc, line)
+		}
+	}
+}
+
+func TestAccessLineOmittedWithoutURL(t *testing.T) {
+	b := testBuild()
+	delete(b.Substitutions, "_URL")
+
+	msg, err := new(discordNotifier).buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if got := msg.Embeds[0].Description; strings.Contains(got, "Access:") {
+		t.Errorf("got description %q without an access URL, want no Access line", got)
+	}
+}
+
+func TestBuildMessageMultipleServices(t *testing.T) {
+	b := testBuild()
+	b.Substitutions["_APP_NAME"] = "frontend, backend,worker"
+
+	msg, err := new(discordNotifier).buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if len(msg.Embeds) != 3 {
+		t.Fatalf("got %d embeds, want 3", len(msg.Embeds))
+	}
+	for i, svc := range []string{"frontend", "backend", "worker"} {
+		e := msg.Embeds[i]
+		if e.Title != "✅ SUCCESS" || e.Color != 1127128 {
+			t.Errorf("embed %d: got (%q, %d), want the SUCCESS title and color", i, e.Title, e.Color)
+		}
+		if !strings.Contains(e.Description, "\nService: "+svc+"\n") {
+			t.Errorf("embed %d: got description %q, want Service %q", i, e.Description, svc)
+		}
+	}
+	if b.Substitutions["_APP_NAME"] != "frontend, backend,worker" {
+		t.Errorf("buildMessage modified the Build's substitutions")
+	}
+
+	services := make([]string, maxEmbeds+2)
+	for i := range services {
+		services[i] = fmt.Sprintf("svc-%d", i)
+	}
+	b.Substitutions["_APP_NAME"] = strings.Join(services, ",")
+	msg, err = new(discordNotifier).buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if len(msg.Embeds) != maxEmbeds {
+		t.Errorf("got %d embeds, want them capped at %d", len(msg.Embeds), maxEmbeds)
+	}
+}
+
+func TestUsernameAndAvatar(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		delivery map[string]interface{}
+		want     map[string]string
+	}{{
+		name: "unset",
+	}, {
+		name: "configured",
+		delivery: map[string]interface{}{
+			usernameField:  "CloudBuild $PROJECT_ID",
+			avatarURLField: "https://example.com/avatar.png",
+		},
+		want: map[string]string{
+			"username":   "CloudBuild my-project-id",
+			"avatar_url": "https://example.com/avatar.png",
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, reqs := recordingServer(t, http.StatusNoContent, "")
+			n := setUpTestNotifier(t, srv.URL, tc.delivery)
+			if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+				t.Fatalf("SendNotification failed: %v", err)
+			}
+			var payload map[string]interface{}
+			if err := json.Unmarshal(reqs()[0].body, &payload); err != nil {
+				t.Fatalf("failed to unmarshal payload: %v", err)
+			}
+			for _, field := range []string{"username", "avatar_url"} {
+				got, ok := payload[field]
+				if want, wantOK := tc.want[field]; ok != wantOK || (ok && got != want) {
+					t.Errorf("got %s %v (present: %v), want %q (present: %v)", field, got, ok, want, wantOK)
+				}
+			}
+		})
+	}
+}
+
+func TestContentPrefix(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{
+		contentPrefixField:    "[$PROJECT_ID]",
+		mentionOnFailureField: "<@&123>",
+		contentTemplatesField: map[interface{}]interface{}{
+			"TIMEOUT": "{{.Substitutions._APP_NAME}} timed out",
+		},
+	})
+	for status, want := range map[cbpb.Build_Status]string{
+		cbpb.Build_SUCCESS: "[my-project-id]",
+		cbpb.Build_FAILURE: "<@&123> [my-project-id]",
+		cbpb.Build_TIMEOUT: "<@&123> [my-project-id] my-app timed out",
+	} {
+		b := testBuild()
+		b.Status = status
+		msg, err := n.buildMessage(b)
+		if err != nil {
+			t.Fatalf("buildMessage failed: %v", err)
+		}
+		if msg.Content != want {
+			t.Errorf("%s: got content %q, want %q", status, msg.Content, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// mentionPattern matches a single Discord user (`<@id>`, `<@!id>`) or role (`<@&id>`) mention, or `@here`/`@everyone`.
+var mentionPattern = regexp.MustCompile(`^(<@[!&]?[0-9]+>|@here|@everyone)$`)
+
+// snowflakePattern matches a Discord role or user ID.
+var snowflakePattern = regexp.MustCompile(`^[0-9]+$`)
+
+// allowedMentions is the `allowed_mentions` object of a Discord message.
+// An empty Parse with explicit Roles and Users pings exactly those and nothing else.
+type allowedMentions struct {
+	Parse []string `json:"parse"`
+	Roles []string `json:"roles,omitempty"`
+	Users []string `json:"users,omitempty"`
+}
+
+// validateMention checks that a non-empty mention is one Discord will render as a ping.
+func validateMention(field, mention string) error {
+	if mention == "" || mentionPattern.MatchString(mention) {
+		return nil
+	}
+	return fmt.Errorf("expected %q to be a Discord mention like <@&123> or <@123>, got %q", field, mention)
+}
+
+// getMentionIDs returns the optional list of Discord role or user IDs from the given delivery config.
+func getMentionIDs(delivery map[string]interface{}, field string) ([]string, error) {
+	ids, err := getStringSlice(delivery, field)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if !snowflakePattern.MatchString(id) {
+			return nil, fmt.Errorf("expected %q entries to be numeric Discord IDs, got %q", field, id)
+		}
+	}
+	return ids, nil
+}
+
+// pages reports whether Builds with the status ping the failure mentions: failures always do, while
+// CANCELLED and EXPIRED Builds only do with mentionOnCancelled, since they are usually deliberate.
+func (s *discordNotifier) pages(status cbpb.Build_Status) bool {
+	switch status {
+	case cbpb.Build_CANCELLED, cbpb.Build_EXPIRED:
+		return s.mentionOnCancelled
+	}
+	return isFailureStatus(status)
+}
+
+// withMention prefixes the message content with the configured mentions for failed Builds, or with the
+// approver mention for Builds awaiting approval. Builds in an environment themed with `mention: false`
+// ping nobody.
+// When role or user IDs are configured it also returns an allowed_mentions object limiting the pings to
+// the configured mentions, so a templated content can't ping anyone else. Otherwise it returns nil and
+// Discord parses mentions in the content as usual.
+func (s *discordNotifier) withMention(build *cbpb.Build, content string) (string, *allowedMentions) {
+	if t, ok := s.environmentTheme(build); ok && t.mute {
+		// Nobody is pinged, not even by mentions in a templated content.
+		return content, &allowedMentions{Parse: []string{}}
+	}
+	if build.Status == cbpb.Build_PENDING && s.approverMention != "" {
+		if content == "" {
+			return s.approverMention, nil
+		}
+		return s.approverMention + " " + content, nil
+	}
+	if !s.pages(build.Status) {
+		return content, nil
+	}
+	var mentions []string
+	if s.mentionOnFailure != "" {
+		mentions = append(mentions, s.mentionOnFailure)
+	}
+	for _, id := range s.mentionRoles {
+		mentions = append(mentions, "<@&"+id+">")
+	}
+	for _, id := range s.mentionUsers {
+		mentions = append(mentions, "<@"+id+">")
+	}
+	if len(mentions) == 0 {
+		return content, nil
+	}
+	if content != "" {
+		mentions = append(mentions, content)
+	}
+	content = strings.Join(mentions, " ")
+
+	if len(s.mentionRoles) == 0 && len(s.mentionUsers) == 0 {
+		return content, nil
+	}
+	allowed := &allowedMentions{
+		Parse: []string{},
+		Roles: append([]string(nil), s.mentionRoles...),
+		Users: append([]string(nil), s.mentionUsers...),
+	}
+	switch m := s.mentionOnFailure; {
+	case m == "@here" || m == "@everyone":
+		allowed.Parse = append(allowed.Parse, "everyone")
+	case strings.HasPrefix(m, "<@&"):
+		allowed.Roles = append(allowed.Roles, strings.Trim(m, "<@&>"))
+	case m != "":
+		allowed.Users = append(allowed.Users, strings.Trim(m, "<@!>"))
+	}
+	return content, allowed
+}
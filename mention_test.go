@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestValidateMention(t *testing.T) {
+	for mention, wantErr := range map[string]bool{
+		"":            false,
+		"<@&123>":     false,
+		"<@123>":      false,
+		"<@!123>":     false,
+		"@here":       false,
+		"@everyone":   false,
+		"@oncall":     true,
+		"<@&abc>":     true,
+		"<@&123> hey": true,
+	} {
+		if err := validateMention(mentionOnFailureField, mention); (err != nil) != wantErr {
+			t.Errorf("validateMention(%q) got error %v, want error %v", mention, err, wantErr)
+		}
+	}
+}
+
+func TestMentionOnFailure(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{
+		mentionOnFailureField: "<@&123>",
+		contentTemplatesField: map[interface{}]interface{}{
+			"TIMEOUT": "{{.Substitutions._APP_NAME}} timed out",
+		},
+	})
+	for status, want := range map[cbpb.Build_Status]string{
+		cbpb.Build_SUCCESS:        "",
+		cbpb.Build_WORKING:        "",
+		cbpb.Build_FAILURE:        "<@&123>",
+		cbpb.Build_INTERNAL_ERROR: "<@&123>",
+		cbpb.Build_TIMEOUT:        "<@&123> my-app timed out",
+		cbpb.Build_CANCELLED:      "",
+		cbpb.Build_EXPIRED:        "",
+	} {
+		b := testBuild()
+		b.Status = status
+		msg, err := n.buildMessage(b)
+		if err != nil {
+			t.Fatalf("buildMessage failed: %v", err)
+		}
+		if msg.Content != want {
+			t.Errorf("%s: got content %q, want %q", status, msg.Content, want)
+		}
+	}
+}
+
+func TestMentionOnCancelled(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{
+		mentionOnFailureField:   "<@&123>",
+		mentionOnCancelledField: true,
+	})
+	for _, status := range []cbpb.Build_Status{cbpb.Build_CANCELLED, cbpb.Build_EXPIRED, cbpb.Build_FAILURE} {
+		b := testBuild()
+		b.Status = status
+		msg, err := n.buildMessage(b)
+		if err != nil {
+			t.Fatalf("buildMessage failed: %v", err)
+		}
+		if want := "<@&123>"; msg.Content != want {
+			t.Errorf("%s: got content %q, want %q", status, msg.Content, want)
+		}
+	}
+}
+
+func TestMentionRolesAndUsersOnFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		delivery    map[string]interface{}
+		wantContent string
+		wantAllowed *allowedMentions
+	}{
+		{
+			name: "roles and users",
+			delivery: map[string]interface{}{
+				mentionRolesOnFailureField: []interface{}{"111", "222"},
+				mentionUsersOnFailureField: []interface{}{"333"},
+			},
+			wantContent: "<@&111> <@&222> <@333>",
+			wantAllowed: &allowedMentions{Parse: []string{}, Roles: []string{"111", "222"}, Users: []string{"333"}},
+		},
+		{
+			name: "with mentionOnFailure",
+			delivery: map[string]interface{}{
+				mentionOnFailureField:      "@here",
+				mentionUsersOnFailureField: []interface{}{"333"},
+			},
+			wantContent: "@here <@333>",
+			wantAllowed: &allowedMentions{Parse: []string{"everyone"}, Users: []string{"333"}},
+		},
+		{
+			name: "with mentionOnFailure user",
+			delivery: map[string]interface{}{
+				mentionOnFailureField:      "<@!444>",
+				mentionRolesOnFailureField: []interface{}{"111"},
+			},
+			wantContent: "<@!444> <@&111>",
+			wantAllowed: &allowedMentions{Parse: []string{}, Roles: []string{"111"}, Users: []string{"444"}},
+		},
+		{
+			name:        "mentionOnFailure only",
+			delivery:    map[string]interface{}{mentionOnFailureField: "<@&123>"},
+			wantContent: "<@&123>",
+		},
+	} {
+		n := setUpTestNotifier(t, "https://discord.example.com", tc.delivery)
+		b := testBuild()
+		b.Status = cbpb.Build_FAILURE
+		msg, err := n.buildMessage(b)
+		if err != nil {
+			t.Fatalf("%s: buildMessage failed: %v", tc.name, err)
+		}
+		if msg.Content != tc.wantContent {
+			t.Errorf("%s: got content %q, want %q", tc.name, msg.Content, tc.wantContent)
+		}
+		if diff := cmp.Diff(tc.wantAllowed, msg.AllowedMentions); diff != "" {
+			t.Errorf("%s: got unexpected allowed mentions (-want +got):\n%s", tc.name, diff)
+		}
+
+		b.Status = cbpb.Build_SUCCESS
+		if msg, err = n.buildMessage(b); err != nil {
+			t.Fatalf("%s: buildMessage failed: %v", tc.name, err)
+		}
+		if msg.Content != "" || msg.AllowedMentions != nil {
+			t.Errorf("%s: got content %q and allowed mentions %+v for a success, want neither", tc.name, msg.Content, msg.AllowedMentions)
+		}
+	}
+}
+
+func TestAllowedMentionsJSON(t *testing.T) {
+	b, err := json.Marshal(allowedMentions{Parse: []string{}, Roles: []string{"111"}})
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	if got, want := string(b), `{"parse":[],"roles":["111"]}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestSetUpInvalidMention(t *testing.T) {
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	for name, delivery := range map[string]map[string]interface{}{
+		"mention":  {mentionOnFailureField: "oncall"},
+		"role id":  {mentionRolesOnFailureField: []interface{}{"<@&111>"}},
+		"user ids": {mentionUsersOnFailureField: "333"},
+	} {
+		if err := new(discordNotifier).SetUp(context.Background(), newTestConfig(delivery), sg, nil); err == nil {
+			t.Errorf("%s: SetUp succeeded, want error for an invalid mention", name)
+		}
+	}
+}
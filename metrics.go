@@ -0,0 +1,167 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// deliveryBuckets are the upper bounds, in seconds, of the delivery latency histogram.
+var deliveryBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// notifierMetrics is served at /metrics.
+var notifierMetrics = newMetrics()
+
+type notificationKey struct {
+	outcome string
+	status  string
+}
+
+// metrics counts notifications, webhook responses and delivery latencies, and serves them in the
+// Prometheus text exposition format.
+type metrics struct {
+	mu            sync.Mutex
+	attempted     map[string]uint64
+	notifications map[notificationKey]uint64
+	responses     map[int]uint64
+	mirrorErrors  uint64
+	// buckets[i] counts deliveries up to deliveryBuckets[i]; the last entry counts all of them.
+	buckets    []uint64
+	latencySum float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		attempted:     make(map[string]uint64),
+		notifications: make(map[notificationKey]uint64),
+		responses:     make(map[int]uint64),
+		buckets:       make([]uint64, len(deliveryBuckets)+1),
+	}
+}
+
+// attempt counts a Build event received for notification.
+func (m *metrics) attempt(status string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempted[status]++
+}
+
+// notification counts the outcome (`sent`, `failed` or `skipped`) of a Build event.
+func (m *metrics) notification(outcome, status string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifications[notificationKey{outcome, status}]++
+}
+
+// response counts an HTTP status code returned by Discord.
+func (m *metrics) response(code int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.responses[code]++
+}
+
+// mirrorFailure counts a message the mirror sink failed to deliver.
+func (m *metrics) mirrorFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mirrorErrors++
+}
+
+// observeDelivery records how long a delivery took, including retries.
+func (m *metrics) observeDelivery(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	secs := d.Seconds()
+	for i, le := range deliveryBuckets {
+		if secs <= le {
+			m.buckets[i]++
+		}
+	}
+	m.buckets[len(deliveryBuckets)]++
+	m.latencySum += secs
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprint(w, "# HELP discord_notifier_notifications_attempted_total Build events received for notification, by build status.\n")
+	fmt.Fprint(w, "# TYPE discord_notifier_notifications_attempted_total counter\n")
+	statuses := make([]string, 0, len(m.attempted))
+	for st := range m.attempted {
+		statuses = append(statuses, st)
+	}
+	sort.Strings(statuses)
+	for _, st := range statuses {
+		fmt.Fprintf(w, "discord_notifier_notifications_attempted_total{status=%q} %d\n", st, m.attempted[st])
+	}
+
+	fmt.Fprint(w, "# HELP discord_notifier_notifications_total Build events by outcome (sent, failed or skipped) and build status.\n")
+	fmt.Fprint(w, "# TYPE discord_notifier_notifications_total counter\n")
+	keys := make([]notificationKey, 0, len(m.notifications))
+	for k := range m.notifications {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].outcome != keys[j].outcome {
+			return keys[i].outcome < keys[j].outcome
+		}
+		return keys[i].status < keys[j].status
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "discord_notifier_notifications_total{outcome=%q,status=%q} %d\n", k.outcome, k.status, m.notifications[k])
+	}
+
+	fmt.Fprint(w, "# HELP discord_notifier_http_responses_total Discord HTTP responses by status code.\n")
+	fmt.Fprint(w, "# TYPE discord_notifier_http_responses_total counter\n")
+	codes := make([]int, 0, len(m.responses))
+	for c := range m.responses {
+		codes = append(codes, c)
+	}
+	sort.Ints(codes)
+	for _, c := range codes {
+		fmt.Fprintf(w, "discord_notifier_http_responses_total{code=\"%d\"} %d\n", c, m.responses[c])
+	}
+
+	fmt.Fprint(w, "# HELP discord_notifier_mirror_failures_total Messages the mirror sink failed to deliver.\n")
+	fmt.Fprint(w, "# TYPE discord_notifier_mirror_failures_total counter\n")
+	fmt.Fprintf(w, "discord_notifier_mirror_failures_total %d\n", m.mirrorErrors)
+
+	fmt.Fprint(w, "# HELP discord_notifier_delivery_duration_seconds Time taken to deliver a message to Discord, including retries.\n")
+	fmt.Fprint(w, "# TYPE discord_notifier_delivery_duration_seconds histogram\n")
+	for i, le := range deliveryBuckets {
+		fmt.Fprintf(w, "discord_notifier_delivery_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(le, 'g', -1, 64), m.buckets[i])
+	}
+	count := m.buckets[len(deliveryBuckets)]
+	fmt.Fprintf(w, "discord_notifier_delivery_duration_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	fmt.Fprintf(w, "discord_notifier_delivery_duration_seconds_sum %s\n", strconv.FormatFloat(m.latencySum, 'g', -1, 64))
+	fmt.Fprintf(w, "discord_notifier_delivery_duration_seconds_count %d\n", count)
+}
+
+// healthz reports that the notifier is up and serving.
+func healthz(w http.ResponseWriter, _ *http.Request) {
+	fmt.Fprint(w, "ok\n")
+}
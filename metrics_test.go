@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMetricsWrite(t *testing.T) {
+	m := newMetrics()
+	m.attempt("SUCCESS")
+	m.attempt("FAILURE")
+	m.notification("sent", "SUCCESS")
+	m.notification("skipped", "FAILURE")
+	m.response(http.StatusServiceUnavailable)
+	m.response(http.StatusNoContent)
+	m.mirrorFailure()
+	m.observeDelivery(200 * time.Millisecond)
+	m.observeDelivery(3 * time.Second)
+
+	var b strings.Builder
+	m.write(&b)
+	want := `# HELP discord_notifier_notifications_attempted_total Build events received for notification, by build status.
+# TYPE discord_notifier_notifications_attempted_total counter
+discord_notifier_notifications_attempted_total{status="FAILURE"} 1
+discord_notifier_notifications_attempted_total{status="SUCCESS"} 1
+# HELP discord_notifier_notifications_total Build events by outcome (sent, failed or skipped) and build status.
+# TYPE discord_notifier_notifications_total counter
+discord_notifier_notifications_total{outcome="sent",status="SUCCESS"} 1
+discord_notifier_notifications_total{outcome="skipped",status="FAILURE"} 1
+# HELP discord_notifier_http_responses_total Discord HTTP responses by status code.
+# TYPE discord_notifier_http_responses_total counter
+discord_notifier_http_responses_total{code="204"} 1
+discord_notifier_http_responses_total{code="503"} 1
+# HELP discord_notifier_mirror_failures_total Messages the mirror sink failed to deliver.
+# TYPE discord_notifier_mirror_failures_total counter
+discord_notifier_mirror_failures_total 1
+# HELP discord_notifier_delivery_duration_seconds Time taken to deliver a message to Discord, including retries.
+# TYPE discord_notifier_delivery_duration_seconds histogram
+discord_notifier_delivery_duration_seconds_bucket{le="0.1"} 0
+discord_notifier_delivery_duration_seconds_bucket{le="0.25"} 1
+discord_notifier_delivery_duration_seconds_bucket{le="0.5"} 1
+discord_notifier_delivery_duration_seconds_bucket{le="1"} 1
+discord_notifier_delivery_duration_seconds_bucket{le="2.5"} 1
+discord_notifier_delivery_duration_seconds_bucket{le="5"} 2
+discord_notifier_delivery_duration_seconds_bucket{le="10"} 2
+discord_notifier_delivery_duration_seconds_bucket{le="30"} 2
+discord_notifier_delivery_duration_seconds_bucket{le="+Inf"} 2
+discord_notifier_delivery_duration_seconds_sum 3.2
+discord_notifier_delivery_duration_seconds_count 2
+`
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("got unexpected metrics diff: %s", diff)
+	}
+}
+
+func TestSendNotificationMetrics(t *testing.T) {
+	srv, _ := sequenceServer(t, respondWith(http.StatusServiceUnavailable), respondWith(http.StatusNoContent))
+	n := setUpTestNotifier(t, srv.URL, nil)
+	n.retryDelay = time.Millisecond
+
+	notifierMetrics.mu.Lock()
+	wantSent := notifierMetrics.notifications[notificationKey{"sent", "SUCCESS"}] + 1
+	want503 := notifierMetrics.responses[http.StatusServiceUnavailable] + 1
+	want204 := notifierMetrics.responses[http.StatusNoContent] + 1
+	wantDeliveries := notifierMetrics.buckets[len(deliveryBuckets)] + 1
+	notifierMetrics.mu.Unlock()
+
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+
+	notifierMetrics.mu.Lock()
+	defer notifierMetrics.mu.Unlock()
+	if got := notifierMetrics.notifications[notificationKey{"sent", "SUCCESS"}]; got != wantSent {
+		t.Errorf("got %d sent notifications, want %d", got, wantSent)
+	}
+	if got := notifierMetrics.responses[http.StatusServiceUnavailable]; got != want503 {
+		t.Errorf("got %d 503 responses, want %d", got, want503)
+	}
+	if got := notifierMetrics.responses[http.StatusNoContent]; got != want204 {
+		t.Errorf("got %d 204 responses, want %d", got, want204)
+	}
+	if got := notifierMetrics.buckets[len(deliveryBuckets)]; got != wantDeliveries {
+		t.Errorf("got %d observed deliveries, want %d", got, wantDeliveries)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	healthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// teamsSink translates messages into Microsoft Teams incoming webhook message cards.
+type teamsSink struct {
+	n   *discordNotifier
+	url string
+}
+
+type teamsMessage struct {
+	Type       string         `json:"@type"`
+	Context    string         `json:"@context"`
+	ThemeColor string         `json:"themeColor"`
+	Summary    string         `json:"summary"`
+	Text       string         `json:"text,omitempty"`
+	Sections   []teamsSection `json:"sections"`
+}
+
+type teamsSection struct {
+	ActivityTitle string      `json:"activityTitle"`
+	Text          string      `json:"text,omitempty"`
+	Facts         []teamsFact `json:"facts,omitempty"`
+}
+
+type teamsFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// toTeamsMessage converts a Discord message into a Teams message card with a section per embed.
+// Teams renders single newlines as spaces, so description lines are separated by blank lines.
+func toTeamsMessage(msg *discordMessage) *teamsMessage {
+	tm := &teamsMessage{
+		Type:    "MessageCard",
+		Context: "https://schema.org/extensions",
+		Text:    msg.Content,
+	}
+	for i, e := range msg.Embeds {
+		if i == 0 {
+			tm.ThemeColor = fmt.Sprintf("%06X", e.Color)
+			tm.Summary = e.Title
+		}
+		sec := teamsSection{ActivityTitle: e.Title, Text: strings.ReplaceAll(e.Description, "\n", "\n\n")}
+		for _, f := range e.Fields {
+			sec.Facts = append(sec.Facts, teamsFact{Name: f.Name, Value: f.Value})
+		}
+		tm.Sections = append(tm.Sections, sec)
+	}
+	return tm
+}
+
+func (t *teamsSink) name() string {
+	return sinkTypeTeams
+}
+
+func (t *teamsSink) send(ctx context.Context, build *cbpb.Build, msg *discordMessage) error {
+	payload, err := json.Marshal(toTeamsMessage(msg))
+	if err != nil {
+		return fmt.Errorf("failed to marshal Teams payload: %w", err)
+	}
+	_, err = t.n.postWebhook(ctx, build, t.url, nil, payload)
+	return err
+}
+
+// jsonWebhookSink POSTs the Build's identity and the rendered Discord message to a generic endpoint.
+type jsonWebhookSink struct {
+	n   *discordNotifier
+	url string
+}
+
+// webhookPayload is the body sent by jsonWebhookSink.
+type webhookPayload struct {
+	BuildID   string          `json:"build_id"`
+	ProjectID string          `json:"project_id"`
+	Status    string          `json:"status"`
+	LogURL    string          `json:"log_url"`
+	Message   *discordMessage `json:"message"`
+}
+
+func (j *jsonWebhookSink) name() string {
+	return sinkTypeWebhook
+}
+
+func (j *jsonWebhookSink) send(ctx context.Context, build *cbpb.Build, msg *discordMessage) error {
+	payload, err := json.Marshal(webhookPayload{
+		BuildID:   build.Id,
+		ProjectID: build.ProjectId,
+		Status:    build.Status.String(),
+		LogURL:    build.LogUrl,
+		Message:   msg,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+	_, err = j.n.postWebhook(ctx, build, j.url, nil, payload)
+	return err
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMirror(t *testing.T) {
+	discordSrv, discordReqs := recordingServer(t, http.StatusNoContent, "")
+	mirrorSrv, mirrorReqs := recordingServer(t, http.StatusOK, "")
+
+	cfg := newTestConfig(map[string]interface{}{
+		mirrorField: map[interface{}]interface{}{
+			"type":               sinkTypeWebhook,
+			webhookURLSecretName: map[interface{}]interface{}{"secretRef": "mirror-url"},
+		},
+	})
+	cfg.Spec.Secrets = append(cfg.Spec.Secrets, &notifiers.Secret{LocalName: "mirror-url", ResourceName: "projects/p/secrets/mirror"})
+	sg := fakeSecretGetter{
+		"projects/p/secrets/webhook-url/versions/latest": discordSrv.URL,
+		"projects/p/secrets/mirror":                      mirrorSrv.URL,
+	}
+	n := new(discordNotifier)
+	if err := n.SetUp(context.Background(), cfg, sg, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+
+	b := testBuild()
+	if err := n.SendNotification(context.Background(), b); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := len(discordReqs()); got != 1 {
+		t.Errorf("got %d Discord requests, want 1", got)
+	}
+	reqs := mirrorReqs()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d mirror requests, want 1", len(reqs))
+	}
+	var got webhookPayload
+	if err := json.Unmarshal(reqs[0].body, &got); err != nil {
+		t.Fatalf("failed to unmarshal mirror payload: %v", err)
+	}
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	want := webhookPayload{BuildID: b.Id, ProjectID: b.ProjectId, Status: "SUCCESS", LogURL: b.LogUrl, Message: msg}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("got unexpected mirror payload diff: %s", diff)
+	}
+}
+
+func TestMirrorFailure(t *testing.T) {
+	discordSrv, discordReqs := recordingServer(t, http.StatusNoContent, "")
+	mirrorSrv, mirrorReqs := recordingServer(t, http.StatusInternalServerError, "")
+
+	cfg := newTestConfig(map[string]interface{}{
+		mirrorField: map[interface{}]interface{}{
+			"type":               sinkTypeWebhook,
+			webhookURLSecretName: map[interface{}]interface{}{"secretRef": "mirror-url"},
+		},
+	})
+	cfg.Spec.Secrets = append(cfg.Spec.Secrets, &notifiers.Secret{LocalName: "mirror-url", ResourceName: "projects/p/secrets/mirror"})
+	sg := fakeSecretGetter{
+		"projects/p/secrets/webhook-url/versions/latest": discordSrv.URL,
+		"projects/p/secrets/mirror":                      mirrorSrv.URL,
+	}
+	n := new(discordNotifier)
+	if err := n.SetUp(context.Background(), cfg, sg, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	n.retryDelay = time.Millisecond
+
+	before := notifierMetrics.mirrorErrors
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed on a mirror error: %v", err)
+	}
+	if got := len(discordReqs()); got != 1 {
+		t.Errorf("got %d Discord requests, want 1", got)
+	}
+	if len(mirrorReqs()) == 0 {
+		t.Error("got no mirror requests, want the mirror to be tried")
+	}
+	if got := notifierMetrics.mirrorErrors - before; got != 1 {
+		t.Errorf("got %d mirror failures counted, want 1", got)
+	}
+}
+
+func TestToTeamsMessage(t *testing.T) {
+	msg := &discordMessage{
+		Content: "<@&123>",
+		Embeds: []embed{{
+			Title:       "❌ ERROR - FAILURE",
+			Color:       14177041,
+			Description: "Build ID: some-build-id\nLinks: [Logs](https://logs.example.com)",
+			Fields:      []embedField{{Name: "_REGION", Value: "europe-west1", Inline: true}},
+		}},
+	}
+	want := &teamsMessage{
+		Type:       "MessageCard",
+		Context:    "https://schema.org/extensions",
+		ThemeColor: "D85311",
+		Summary:    "❌ ERROR - FAILURE",
+		Text:       "<@&123>",
+		Sections: []teamsSection{{
+			ActivityTitle: "❌ ERROR - FAILURE",
+			Text:          "Build ID: some-build-id\n\nLinks: [Logs](https://logs.example.com)",
+			Facts:         []teamsFact{{Name: "_REGION", Value: "europe-west1"}},
+		}},
+	}
+	if diff := cmp.Diff(want, toTeamsMessage(msg)); diff != "" {
+		t.Errorf("toTeamsMessage got unexpected diff: %s", diff)
+	}
+}
+
+func TestSlackLinks(t *testing.T) {
+	got := slackLinks("Links: [Build](https://console.example.com/b?project=p) • [Logs](https://logs.example.com)")
+	if want := "Links: <https://console.example.com/b?project=p|Build> • <https://logs.example.com|Logs>"; got != want {
+		t.Errorf("slackLinks got %q, want %q", got, want)
+	}
+}
+
+func TestSetUpInvalidMirror(t *testing.T) {
+	for name, mirror := range map[string]interface{}{
+		"not a map":    "slack",
+		"unknown type": map[interface{}]interface{}{"type": "carrier-pigeon"},
+		"no secret":    map[interface{}]interface{}{"type": sinkTypeTeams},
+	} {
+		cfg := newTestConfig(map[string]interface{}{mirrorField: mirror})
+		sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+		if err := new(discordNotifier).SetUp(context.Background(), cfg, sg, nil); err == nil {
+			t.Errorf("%s: SetUp succeeded, want error", name)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimits remembers, per destination URL, when an exhausted Discord rate limit bucket resets,
+// so the next request waits instead of being rejected with a 429.
+type rateLimits struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// resetAfter returns the X-RateLimit-Reset-After duration from a Discord response.
+func resetAfter(header http.Header) (time.Duration, bool) {
+	v := header.Get("X-RateLimit-Reset-After")
+	if v == "" {
+		return 0, false
+	}
+	secs, err := strconv.ParseFloat(v, 64)
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
+
+// update records the bucket reset time when the response says no requests remain.
+func (r *rateLimits) update(target string, header http.Header) {
+	if header.Get("X-RateLimit-Remaining") != "0" {
+		return
+	}
+	d, ok := resetAfter(header)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.until == nil {
+		r.until = make(map[string]time.Time)
+	}
+	r.until[target] = time.Now().Add(d)
+}
+
+// wait blocks until the destination's rate limit bucket has reset, or the context is done.
+// The entry stays in place until the reset has passed, so concurrent sends to the same
+// destination wait as well; update overwrites it with the next bucket's reset.
+func (r *rateLimits) wait(ctx context.Context, target string) error {
+	r.mu.Lock()
+	until, ok := r.until[target]
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	d := time.Until(until)
+	if d <= 0 {
+		r.forget(target, until)
+		return nil
+	}
+	if d > maxRateLimitWait {
+		d = maxRateLimitWait
+	}
+	select {
+	case <-time.After(d):
+		r.forget(target, until)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// forget drops the destination's entry once its reset has passed, unless update replaced it.
+func (r *rateLimits) forget(target string, until time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cur, ok := r.until[target]; ok && cur.Equal(until) && !time.Now().Before(until) {
+		delete(r.until, target)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// logPayloadsField enables logging message payloads and webhook responses at the debug level.
+	logPayloadsField = "logPayloads"
+	// redactSubstitutionsField lists substitutions whose values are masked in logged payloads.
+	redactSubstitutionsField = "redactSubstitutions"
+
+	redacted = "[REDACTED]"
+)
+
+// webhookURLPattern matches Discord webhook URLs, whose path carries the webhook's token.
+var webhookURLPattern = regexp.MustCompile(`https://(?:[a-z]+\.)?discord(?:app)?\.com/api(?:/v\d+)?/webhooks/[^\s"'?]+`)
+
+// logPayload logs what the payload or response body is, and the body itself, at the debug level when
+// logPayloads is set, with the Build's redacted substitutions and any webhook URLs masked.
+func (s *discordNotifier) logPayload(build *cbpb.Build, what string, payload []byte) {
+	if !s.logPayloads {
+		return
+	}
+	buildLog(build).Debugf("%s: %s", what, s.redact(build, string(payload)))
+}
+
+// redact masks the values of the redactSubstitutions and webhook URLs in text.
+func (s *discordNotifier) redact(build *cbpb.Build, text string) string {
+	var pairs []string
+	for _, key := range s.redactSubstitutions {
+		v := build.Substitutions[key]
+		if v == "" {
+			continue
+		}
+		pairs = append(pairs, v, redacted)
+		// Payloads are JSON, where the value may appear escaped.
+		if b, err := json.Marshal(v); err == nil {
+			if esc := string(b[1 : len(b)-1]); esc != v {
+				pairs = append(pairs, esc, redacted)
+			}
+		}
+	}
+	if s.webhookURL != "" {
+		pairs = append(pairs, s.webhookURL, redacted)
+	}
+	if len(pairs) > 0 {
+		text = strings.NewReplacer(pairs...).Replace(text)
+	}
+	return webhookURLPattern.ReplaceAllString(text, redacted)
+}
+
+// stripURL returns the cause of the *url.Error that net/http and url.Parse return, dropping the URL it
+// names since webhook and hook URLs carry their credentials. Other errors are returned as they are.
+func stripURL(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ue.Err
+	}
+	return err
+}
+
+// redactedError is an error whose message had secrets masked by redact. It still unwraps to the
+// original so that errors.Is and errors.As keep working.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactError masks the values redact would in the error's message.
+func (s *discordNotifier) redactError(build *cbpb.Build, err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if r := s.redact(build, msg); r != msg {
+		return &redactedError{msg: r, err: err}
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedact(t *testing.T) {
+	n := &discordNotifier{
+		webhookURL:          "https://hooks.example.com/secret-path",
+		redactSubstitutions: []string{"_TOKEN", "_QUOTED", "_UNSET"},
+	}
+	build := testBuild()
+	build.Substitutions["_TOKEN"] = "s3cr3t"
+	build.Substitutions["_QUOTED"] = `a"b`
+
+	for _, tc := range []struct {
+		text string
+		want string
+	}{{
+		text: `{"content":"token s3cr3t"}`,
+		want: `{"content":"token [REDACTED]"}`,
+	}, {
+		text: `{"content":"a\"b"}`,
+		want: `{"content":"[REDACTED]"}`,
+	}, {
+		text: "posting to https://hooks.example.com/secret-path",
+		want: "posting to [REDACTED]",
+	}, {
+		text: `{"url":"https://discord.com/api/webhooks/123/abc-DEF"}`,
+		want: `{"url":"[REDACTED]"}`,
+	}, {
+		text: `{"url":"https://canary.discordapp.com/api/v10/webhooks/123/abc?wait=true"}`,
+		want: `{"url":"[REDACTED]?wait=true"}`,
+	}, {
+		text: `{"content":"my-app"}`,
+		want: `{"content":"my-app"}`,
+	}} {
+		if got := n.redact(build, tc.text); got != tc.want {
+			t.Errorf("redact(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestLogPayloads(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		delivery map[string]interface{}
+		want     bool
+	}{{
+		name:     "off by default",
+		delivery: map[string]interface{}{logLevelField: "debug"},
+	}, {
+		name:     "needs debug level",
+		delivery: map[string]interface{}{logPayloadsField: true},
+	}, {
+		name: "redacted",
+		delivery: map[string]interface{}{
+			logLevelField:            "debug",
+			logPayloadsField:         true,
+			redactSubstitutionsField: []interface{}{"_APP_NAME"},
+		},
+		want: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := captureLog(t, levelInfo)
+			srv, _ := recordingServer(t, 204, "")
+			n := setUpTestNotifier(t, srv.URL, tc.delivery)
+			if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+				t.Fatalf("SendNotification failed: %v", err)
+			}
+
+			var logged bool
+			for _, l := range logLines(t, buf) {
+				if !strings.HasPrefix(l.Message, "sending payload") {
+					continue
+				}
+				logged = true
+				if l.Severity != "DEBUG" {
+					t.Errorf("payload logged at %s, want DEBUG", l.Severity)
+				}
+				if strings.Contains(l.Message, "my-app") || !strings.Contains(l.Message, redacted) {
+					t.Errorf("payload log %q doesn't redact _APP_NAME", l.Message)
+				}
+			}
+			if logged != tc.want {
+				t.Errorf("payload logged = %v, want %v", logged, tc.want)
+			}
+		})
+	}
+}
+
+func TestConnectionErrorHidesWebhookToken(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const token = "s3cr3t-webhook-token"
+	webhookURL := "http://" + l.Addr().String() + "/api/webhooks/123/" + token
+	l.Close()
+
+	n := setUpTestNotifier(t, webhookURL, nil)
+	n.retryDelay = time.Millisecond
+	buf := captureLog(t, levelDebug)
+	err = n.SendNotification(context.Background(), testBuild())
+	if err == nil {
+		t.Fatal("SendNotification to a closed listener succeeded, want error")
+	}
+	if strings.Contains(err.Error(), token) {
+		t.Errorf("got error %q, want the webhook token left out", err)
+	}
+	for _, l := range logLines(t, buf) {
+		if strings.Contains(l.Message, token) {
+			t.Errorf("got log %q, want the webhook token left out", l.Message)
+		}
+	}
+	if !strings.Contains(buf.String(), "retrying webhook") {
+		t.Errorf("got log %q, want the failed attempts logged", buf.String())
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// reloadIntervalField enables re-reading the config from CONFIG_PATH, and re-resolving its secrets,
+	// at the given interval (e.g. `5m`) so changes apply without a redeploy.
+	reloadIntervalField = "reloadInterval"
+
+	// configPathEnv names the GCS object notifiers.Main reads the config from.
+	configPathEnv = "CONFIG_PATH"
+)
+
+// notifierSource gives HTTP handlers the notifier currently in use.
+type notifierSource interface {
+	current() *discordNotifier
+}
+
+func (s *discordNotifier) current() *discordNotifier {
+	return s
+}
+
+// reloadingNotifier delivers notifications with a discordNotifier set up from the latest config. A
+// changed config or secret is set up on a fresh notifier that replaces the current one only once it
+// succeeds, so a bad edit leaves the working config in place.
+type reloadingNotifier struct {
+	// newNotifier returns an unconfigured notifier, e.g. with the tracer set by main.
+	newNotifier func() *discordNotifier
+	// read fetches the config; it defaults to reading CONFIG_PATH from GCS.
+	read func(ctx context.Context) (*notifiers.Config, error)
+
+	mu          sync.RWMutex
+	cur         *discordNotifier
+	fingerprint [sha256.Size]byte
+	sg          notifiers.SecretGetter
+	br          notifiers.BindingResolver
+	// stopWatch cancels the running watch, if any.
+	stopWatch context.CancelFunc
+}
+
+func (r *reloadingNotifier) SetUp(ctx context.Context, cfg *notifiers.Config, sg notifiers.SecretGetter, br notifiers.BindingResolver) error {
+	n := r.newNotifier()
+	if err := n.SetUp(ctx, cfg, sg, br); err != nil {
+		return err
+	}
+	fp, err := configFingerprint(ctx, cfg, sg)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cur, r.fingerprint, r.sg, r.br = n, fp, sg, br
+	// A new SetUp replaces the config, so an earlier watch must not keep reloading over it.
+	if r.stopWatch != nil {
+		r.stopWatch()
+		r.stopWatch = nil
+	}
+	r.mu.Unlock()
+
+	if n.reloadInterval == 0 {
+		return nil
+	}
+	if r.read == nil {
+		if r.read, err = gcsConfigReader(ctx); err != nil {
+			return fmt.Errorf("failed to set up config reloading: %w", err)
+		}
+	}
+	wctx, cancel := context.WithCancel(context.Background())
+	r.mu.Lock()
+	r.stopWatch = cancel
+	r.mu.Unlock()
+	go r.watch(wctx)
+	return nil
+}
+
+func (r *reloadingNotifier) SendNotification(ctx context.Context, build *cbpb.Build) error {
+	return r.current().SendNotification(ctx, build)
+}
+
+func (r *reloadingNotifier) current() *discordNotifier {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cur
+}
+
+// watch reloads the config every reloadInterval, until a reloaded config turns reloading off
+// or ctx is cancelled.
+func (r *reloadingNotifier) watch(ctx context.Context) {
+	for {
+		interval := r.current().reloadInterval
+		if interval == 0 {
+			notifierLog.Warningf("config reloading turned off by %q, restart the notifier to turn it back on", reloadIntervalField)
+			return
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+		err := r.reload(ctx)
+		if ctx.Err() != nil {
+			// SetUp stopped this watch and has already swapped in its own config.
+			return
+		}
+		if err != nil {
+			notifierLog.Errorf("failed to reload config, keeping the current one: %v", err)
+		}
+	}
+}
+
+// reload sets up a notifier from the latest config if it or its secrets changed, and swaps it in.
+func (r *reloadingNotifier) reload(ctx context.Context) error {
+	cfg, err := r.read(ctx)
+	if err != nil {
+		return err
+	}
+	fp, err := configFingerprint(ctx, cfg, r.sg)
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	unchanged := fp == r.fingerprint
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	old := r.current()
+	level := notifierLog.getLevel()
+	n := r.newNotifier()
+	if err := n.SetUp(ctx, cfg, r.sg, r.br); err != nil {
+		// SetUp applies the log level first, which shouldn't outlive a failed reload.
+		notifierLog.setLevel(level)
+		return fmt.Errorf("failed to set up notifier: %w", err)
+	}
+	n.inheritState(old)
+	r.mu.Lock()
+	r.cur, r.fingerprint = n, fp
+	r.mu.Unlock()
+	notifierLog.Infof("reloaded config with changes")
+	return nil
+}
+
+// configFingerprint hashes the config and the current values of its secrets, so that rotating a
+// secret counts as a change.
+func configFingerprint(ctx context.Context, cfg *notifiers.Config, sg notifiers.SecretGetter) ([sha256.Size]byte, error) {
+	var fp [sha256.Size]byte
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fp, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	h := sha256.New()
+	h.Write(b)
+	for _, sec := range cfg.Spec.Secrets {
+		v, err := sg.GetSecret(ctx, sec.ResourceName)
+		if err != nil {
+			return fp, fmt.Errorf("failed to get secret %q: %w", sec.LocalName, err)
+		}
+		fmt.Fprintf(h, "\x00%s=%d:%s", sec.LocalName, len(v), v)
+	}
+	copy(fp[:], h.Sum(nil))
+	return fp, nil
+}
+
+// gcsConfigReader reads the config object named by CONFIG_PATH, as notifiers.Main does.
+func gcsConfigReader(ctx context.Context) (func(context.Context) (*notifiers.Config, error), error) {
+	path, ok := notifiers.GetEnv(configPathEnv)
+	if !ok {
+		return nil, fmt.Errorf("expected %s to be non-empty", configPathEnv)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, "gs://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("expected %s to be a gs://bucket/object path, got %q", configPathEnv, path)
+	}
+	sc, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	obj := sc.Bucket(parts[0]).Object(parts[1])
+	return func(ctx context.Context) (*notifiers.Config, error) {
+		rd, err := obj.NewReader(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config %q: %w", path, err)
+		}
+		defer rd.Close()
+		return decodeConfig(rd)
+	}, nil
+}
+
+// inheritState carries in-memory state over from the notifier being replaced, where both keep it in
+// memory, so that a reload doesn't forget recent events, digest entries, trigger statuses or Builds
+// queued during quiet hours. Throttle windows start afresh, though summaries the old notifier has
+// already scheduled are still posted.
+func (s *discordNotifier) inheritState(old *discordNotifier) {
+	if s.quiet != nil && s.quiet.queue && old.quiet != nil {
+		// Moving the queue leaves the old notifier's scheduled summary with nothing to post.
+		s.queueQuietLines(old.quiet.takeQueued()...)
+	}
+	if prev, ok := old.events.(*seenEvents); ok {
+		if cur, ok := s.events.(*seenEvents); ok && cur.window == prev.window {
+			s.events = prev
+		}
+	}
+	if s.digest != nil && old.digest != nil {
+		if prev, ok := old.digest.store.(*memoryDigestStore); ok {
+			if _, ok := s.digest.store.(*memoryDigestStore); ok {
+				s.digest.store = prev
+			}
+		}
+	}
+	if s.transitions != nil && old.transitions != nil && s.transitions.groupBy == old.transitions.groupBy {
+		if prev, ok := old.transitions.store.(*memoryStatusStore); ok {
+			if _, ok := s.transitions.store.(*memoryStatusStore); ok {
+				s.transitions.store = prev
+			}
+		}
+	}
+}
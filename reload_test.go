@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+)
+
+func TestReload(t *testing.T) {
+	captureLog(t, levelInfo)
+	cfg := newTestConfig(map[string]interface{}{dedupeEventsField: true})
+	var readErr error
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com/a"}
+	r := &reloadingNotifier{
+		newNotifier: func() *discordNotifier { return new(discordNotifier) },
+		read: func(context.Context) (*notifiers.Config, error) {
+			return cfg, readErr
+		},
+	}
+	ctx := context.Background()
+	if err := r.SetUp(ctx, cfg, sg, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	first := r.current()
+
+	if err := r.reload(ctx); err != nil || r.current() != first {
+		t.Errorf("reload of an unchanged config = %v, replaced notifier: %v; want it kept", err, r.current() != first)
+	}
+
+	sg["projects/p/secrets/webhook-url/versions/latest"] = "https://discord.example.com/b"
+	if err := r.reload(ctx); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	second := r.current()
+	if second == first || second.webhookURL != "https://discord.example.com/b" {
+		t.Errorf("after rotating the secret got webhook %q, want the new one", second.webhookURL)
+	}
+	if second.events != first.events {
+		t.Error("reload forgot the in-memory events, want them carried over")
+	}
+
+	cfg = newTestConfig(map[string]interface{}{dedupeEventsField: true, logLevelField: "error", formatField: "bogus"})
+	if err := r.reload(ctx); err == nil {
+		t.Error("reload of an invalid config succeeded, want error")
+	}
+	if r.current() != second {
+		t.Error("failed reload replaced the notifier, want the working one kept")
+	}
+	if got := notifierLog.getLevel(); got != levelInfo {
+		t.Errorf("failed reload left log level %v, want %v", got, levelInfo)
+	}
+
+	readErr = errors.New("gcs down")
+	if err := r.reload(ctx); err == nil || r.current() != second {
+		t.Errorf("reload with a read error = %v, want error and the notifier kept", err)
+	}
+}
+
+func TestReloadKeepsQuietQueue(t *testing.T) {
+	captureLog(t, levelInfo)
+	srv, reqs := recordingServer(t, http.StatusNoContent, "")
+	quiet := map[interface{}]interface{}{quietStartField: "22:00", quietEndField: "07:00", quietModeField: quietModeQueue}
+	cfg := newTestConfig(map[string]interface{}{quietHoursField: quiet})
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": srv.URL}
+	var sched scheduled
+	r := &reloadingNotifier{
+		newNotifier: func() *discordNotifier {
+			return &discordNotifier{
+				now:      func() time.Time { return time.Date(2021, 9, 1, 23, 0, 0, 0, time.UTC) },
+				schedule: sched.schedule,
+			}
+		},
+		read: func(context.Context) (*notifiers.Config, error) {
+			return cfg, nil
+		},
+	}
+	ctx := context.Background()
+	if err := r.SetUp(ctx, cfg, sg, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	if err := r.current().SendNotification(ctx, testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+
+	cfg = newTestConfig(map[string]interface{}{quietHoursField: quiet, usernameField: "CI"})
+	if err := r.reload(ctx); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	sched.runAll()
+	got := reqs()
+	if len(got) != 1 {
+		t.Fatalf("got %d webhook requests after quiet hours, want a single summary", len(got))
+	}
+	var msg discordMessage
+	if err := json.Unmarshal(got[0].body, &msg); err != nil {
+		t.Fatalf("failed to unmarshal summary: %v", err)
+	}
+	if msg.Username != "CI" || len(msg.Embeds) != 1 || msg.Embeds[0].Title != "🌙 1 build during quiet hours" {
+		t.Errorf("got summary %+v, want the queued Build posted by the reloaded notifier", msg)
+	}
+}
+
+func TestReloadIntervalNeedsConfigPath(t *testing.T) {
+	r := &reloadingNotifier{newNotifier: func() *discordNotifier { return new(discordNotifier) }}
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	if old, ok := os.LookupEnv(configPathEnv); ok {
+		os.Unsetenv(configPathEnv)
+		defer os.Setenv(configPathEnv, old)
+	}
+	if err := r.SetUp(context.Background(), newTestConfig(map[string]interface{}{reloadIntervalField: "5m"}), sg, nil); err == nil {
+		t.Error("SetUp with reloadInterval but no CONFIG_PATH succeeded, want error")
+	}
+}
+
+func TestSetUpStopsPreviousWatch(t *testing.T) {
+	captureLog(t, levelError)
+	ctxs := make(chan context.Context, 10)
+	r := &reloadingNotifier{
+		newNotifier: func() *discordNotifier { return new(discordNotifier) },
+		// Each read blocks until its watch is stopped.
+		read: func(ctx context.Context) (*notifiers.Config, error) {
+			ctxs <- ctx
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	watched := newTestConfig(map[string]interface{}{reloadIntervalField: "1ms"})
+	watchCtx := func() context.Context {
+		t.Helper()
+		select {
+		case ctx := <-ctxs:
+			return ctx
+		case <-time.After(5 * time.Second):
+			t.Fatal("got no config read from the watch")
+			return nil
+		}
+	}
+	stopped := func(ctx context.Context, what string) {
+		t.Helper()
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s left the previous watch running", what)
+		}
+	}
+
+	ctx := context.Background()
+	if err := r.SetUp(ctx, watched, sg, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	first := watchCtx()
+	if err := r.SetUp(ctx, newTestConfig(map[string]interface{}{reloadIntervalField: "1ms"}), sg, nil); err != nil {
+		t.Fatalf("second SetUp failed: %v", err)
+	}
+	stopped(first, "second SetUp")
+	second := watchCtx()
+	if err := r.SetUp(ctx, newTestConfig(nil), sg, nil); err != nil {
+		t.Fatalf("third SetUp failed: %v", err)
+	}
+	stopped(second, "SetUp without reloadInterval")
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+type embedAuthor struct {
+	Name string `json:"name"`
+}
+
+type embedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// richLayout moves the Build's identifying details into embed fields, links the title to the
+// Build's logs and shows the repository as the author. Any remaining description lines are kept.
+func (s *discordNotifier) richLayout(build *cbpb.Build, e *embed, src sourceInfo) {
+	e.URL = build.LogUrl
+	if src.repo != "" {
+		e.Author = &embedAuthor{Name: src.repo}
+	}
+	e.Fields = append(e.Fields,
+		embedField{Name: s.text("label.buildId"), Value: fieldValue(build.Id), Inline: true},
+		embedField{Name: s.text("label.service"), Value: fieldValue(s.appName(build)), Inline: true},
+		embedField{Name: s.text("label.environment"), Value: fieldValue(s.environmentLabel(build)), Inline: true},
+	)
+	if r := src.refText(); r != "" {
+		e.Fields = append(e.Fields, embedField{Name: s.text("label.ref"), Value: r, Inline: true})
+	}
+	if src.archive != "" {
+		e.Fields = append(e.Fields, embedField{Name: s.text("label.source"), Value: src.archive})
+	}
+	if row := linkRow(s.links(build, src)); row != "" {
+		e.Fields = append(e.Fields, embedField{Name: s.text("label.links"), Value: row})
+	}
+	e.Description = strings.TrimPrefix(e.Description, "\n")
+}
+
+// substitutionFields renders each of the named substitutions the Build sets as an inline field,
+// keeping within Discord's limits on the number of fields and the length of their values.
+func substitutionFields(build *cbpb.Build, names []string, existing int) []embedField {
+	var out []embedField
+	for _, name := range names {
+		v := build.Substitutions[name]
+		if v == "" {
+			continue
+		}
+		if existing+len(out) == maxEmbedFields {
+			buildLog(build).Warningf("Build %q has more substitutions to show than fit in an embed, leaving out %q and later ones", build.Id, name)
+			break
+		}
+		if r := []rune(v); len(r) > maxFieldValueLength {
+			v = string(r[:maxFieldValueLength-1]) + "…"
+		}
+		out = append(out, embedField{Name: name, Value: v, Inline: true})
+	}
+	return out
+}
+
+// fieldValue substitutes a placeholder for empty values, which Discord rejects in fields.
+func fieldValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
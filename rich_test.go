@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestRichEmbeds(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{richEmbedsField: true})
+	b := testBuild()
+	b.LogUrl = "https://logs.example.com/some-build-id"
+	b.Substitutions["_URL"] = "https://my-app.example.com"
+	b.Source = &cbpb.Source{Source: &cbpb.Source_RepoSource{RepoSource: &cbpb.RepoSource{
+		RepoName: "my-repo",
+		Revision: &cbpb.RepoSource_BranchName{BranchName: "main"},
+	}}}
+
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	e := msg.Embeds[0]
+	if e.URL != b.LogUrl {
+		t.Errorf("got title URL %q, want the log URL", e.URL)
+	}
+	if e.Author == nil || e.Author.Name != "my-repo" {
+		t.Errorf("got author %+v, want the repository", e.Author)
+	}
+	wantFields := []embedField{
+		{Name: "Build ID", Value: "some-build-id", Inline: true},
+		{Name: "Service", Value: "my-app", Inline: true},
+		{Name: "Environment", Value: "my-project-id", Inline: true},
+		{Name: "Ref", Value: "main", Inline: true},
+		{Name: "Links", Value: "[Build](https://console.cloud.google.com/cloud-build/builds/some-build-id?project=my-project-id) • [Logs](" + b.LogUrl + ")"},
+	}
+	if diff := cmp.Diff(wantFields, e.Fields); diff != "" {
+		t.Errorf("got unexpected fields diff: %s", diff)
+	}
+	if want := "Access: https://my-app.example.com"; e.Description != want {
+		t.Errorf("got description %q, want %q", e.Description, want)
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("failed to marshal embed: %v", err)
+	}
+	var shape struct {
+		URL    string `json:"url"`
+		Author struct {
+			Name string `json:"name"`
+		} `json:"author"`
+		Fields []struct {
+			Name   string `json:"name"`
+			Value  string `json:"value"`
+			Inline bool   `json:"inline"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal(payload, &shape); err != nil {
+		t.Fatalf("failed to unmarshal embed: %v", err)
+	}
+	if shape.URL == "" || shape.Author.Name == "" || len(shape.Fields) != 5 || !shape.Fields[0].Inline {
+		t.Errorf("got embed JSON %s, want url, author and inline fields", payload)
+	}
+}
+
+func TestRichEmbedsToSlack(t *testing.T) {
+	msg := &discordMessage{Embeds: []embed{{
+		Title:  "✅ SUCCESS",
+		URL:    "https://logs.example.com",
+		Fields: []embedField{{Name: "Service", Value: "my-app", Inline: true}},
+	}}}
+	got := toSlackMessage(msg).Attachments[0]
+	if got.TitleLink != "https://logs.example.com" {
+		t.Errorf("got title link %q, want the embed URL", got.TitleLink)
+	}
+	if diff := cmp.Diff([]slackField{{Title: "Service", Value: "my-app", Short: true}}, got.Fields); diff != "" {
+		t.Errorf("got unexpected Slack fields diff: %s", diff)
+	}
+}
+
+func TestShowSubstitutions(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{
+		showSubstitutionsField: []interface{}{"_REGION", "_UNSET", "TAG_NAME", "_NOTES"},
+	})
+	b := testBuild()
+	b.Substitutions["_REGION"] = "europe-west1"
+	b.Substitutions["TAG_NAME"] = "v1.2.0"
+	b.Substitutions["_NOTES"] = strings.Repeat("n", maxFieldValueLength+10)
+
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	want := []embedField{
+		{Name: "_REGION", Value: "europe-west1", Inline: true},
+		{Name: "TAG_NAME", Value: "v1.2.0", Inline: true},
+		{Name: "_NOTES", Value: strings.Repeat("n", maxFieldValueLength-1) + "…", Inline: true},
+	}
+	if diff := cmp.Diff(want, msg.Embeds[0].Fields); diff != "" {
+		t.Errorf("got unexpected fields diff: %s", diff)
+	}
+}
+
+func TestSubstitutionFieldsLimit(t *testing.T) {
+	b := testBuild()
+	var names []string
+	for i := 0; i < maxEmbedFields; i++ {
+		name := fmt.Sprintf("_SUB_%d", i)
+		b.Substitutions[name] = "v"
+		names = append(names, name)
+	}
+	if got := len(substitutionFields(b, names, 4)); got != maxEmbedFields-4 {
+		t.Errorf("got %d fields after 4 existing ones, want %d", got, maxEmbedFields-4)
+	}
+}
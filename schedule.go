@@ -0,0 +1,417 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// quietHoursField configures when only failures are notified.
+	quietHoursField = "quietHours"
+	// quietStartField and quietEndField bound the daily quiet hours as `HH:MM`; they may span midnight.
+	quietStartField = "start"
+	quietEndField   = "end"
+	// quietDaysField lists weekdays (e.g. `sat`) that are quiet all day.
+	quietDaysField = "days"
+	// quietTimezoneField is the IANA time zone of the quiet hours, defaulting to UTC.
+	quietTimezoneField = "timezone"
+	// quietModeField is quietModeFailuresOnly (the default), which drops other notifications, or
+	// quietModeQueue, which posts a summary of them once the quiet hours end.
+	quietModeField        = "mode"
+	quietModeFailuresOnly = "failuresOnly"
+	quietModeQueue        = "queue"
+
+	// throttleField caps the messages posted to each webhook, summarizing the rest.
+	throttleField = "throttle"
+	// throttleMaxField is how many messages may be posted within throttleWindowField.
+	throttleMaxField    = "max"
+	throttleWindowField = "window"
+
+	// maxSummaryLines caps the Builds listed in a summary message.
+	maxSummaryLines = 10
+	summaryColor    = 9807270
+)
+
+// weekdays maps quietDaysField values to weekdays.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// summaryLine is a Build listed in a summary of notifications that weren't posted on their own.
+type summaryLine struct {
+	app     string
+	project string
+	status  cbpb.Build_Status
+	url     string
+}
+
+func (s *discordNotifier) summaryLine(build *cbpb.Build) summaryLine {
+	return summaryLine{app: firstNonEmpty(s.appName(build), build.Id), project: build.ProjectId, status: build.Status, url: buildPageURL(build)}
+}
+
+// summaryText renders a summary line, e.g. `✅ my-app SUCCESS ([Build](...))`.
+func (s *discordNotifier) summaryText(l summaryLine) string {
+	mark := "⚪"
+	switch {
+	case l.status == cbpb.Build_SUCCESS:
+		mark = "✅"
+	case isFailureStatus(l.status):
+		mark = "❌"
+	}
+	line := mark + " " + l.app + " " + s.statusName(l.status)
+	if l.url != "" {
+		line += " ([" + s.text("link.build") + "](" + l.url + "))"
+	}
+	return line
+}
+
+// summaryMessage lists the Builds under the title, leaving out any past maxSummaryLines.
+func (s *discordNotifier) summaryMessage(title string, lines []summaryLine) *discordMessage {
+	var b strings.Builder
+	for i, l := range lines {
+		if i == maxSummaryLines {
+			b.WriteString(s.textN("text.more", len(lines)-maxSummaryLines))
+			break
+		}
+		b.WriteString(s.summaryText(l) + "\n")
+	}
+	return &discordMessage{Embeds: []embed{{
+		Title:       title,
+		Color:       summaryColor,
+		Description: strings.TrimSuffix(b.String(), "\n"),
+	}}}
+}
+
+// summaryUsername expands `$PROJECT_ID` in the username to the project of the summarized Builds,
+// leaving it out when they come from several projects.
+func (s *discordNotifier) summaryUsername(lines []summaryLine) string {
+	project := ""
+	for i, l := range lines {
+		if i > 0 && l.project != project {
+			project = ""
+			break
+		}
+		project = l.project
+	}
+	return strings.TrimSpace(strings.ReplaceAll(s.username, "$PROJECT_ID", project))
+}
+
+// postSummary posts a summary message to the webhook.
+func (s *discordNotifier) postSummary(ctx context.Context, webhookURL, title string, lines []summaryLine) error {
+	msg := s.summaryMessage(title, lines)
+	msg.Username, msg.AvatarURL = s.summaryUsername(lines), s.avatarURL
+	payload, err := json.Marshal(withinLimits(msg))
+	if err != nil {
+		return fmt.Errorf("Unable to marshal payload %w", err)
+	}
+	_, err = s.postWebhook(ctx, &cbpb.Build{Id: "summary"}, webhookURL, nil, payload)
+	return err
+}
+
+// afterFunc runs f after d, like time.AfterFunc; tests replace it to run f on demand.
+func (s *discordNotifier) afterFunc(d time.Duration, f func()) {
+	if s.schedule != nil {
+		s.schedule(d, f)
+		return
+	}
+	time.AfterFunc(d, f)
+}
+
+// quietHours drops or queues notifications other than failures at configured times.
+type quietHours struct {
+	// start and end are minutes after midnight; when equal, only days are quiet.
+	start, end int
+	days       map[time.Weekday]bool
+	loc        *time.Location
+	queue      bool
+
+	mu     sync.Mutex
+	queued []summaryLine
+}
+
+// getQuietHours returns the quiet hours configured in the optional quietHoursField map, or nil if it is unset.
+func getQuietHours(delivery map[string]interface{}) (*quietHours, error) {
+	raw, ok := delivery[quietHoursField]
+	if !ok {
+		return nil, nil
+	}
+	cfg, err := toStringMap(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delivery config field %q: %w", quietHoursField, err)
+	}
+	for k := range cfg {
+		switch k {
+		case quietStartField, quietEndField, quietDaysField, quietTimezoneField, quietModeField:
+		default:
+			return nil, fmt.Errorf("unknown field %q in delivery config field %q", k, quietHoursField)
+		}
+	}
+
+	q := &quietHours{days: make(map[time.Weekday]bool), loc: time.UTC}
+	start, err := getString(cfg, quietStartField)
+	if err != nil {
+		return nil, err
+	}
+	end, err := getString(cfg, quietEndField)
+	if err != nil {
+		return nil, err
+	}
+	if (start == "") != (end == "") {
+		return nil, fmt.Errorf("delivery config field %q needs both %q and %q", quietHoursField, quietStartField, quietEndField)
+	}
+	if start != "" {
+		if q.start, err = parseClock(start); err != nil {
+			return nil, fmt.Errorf("invalid %s.%s: %w", quietHoursField, quietStartField, err)
+		}
+		if q.end, err = parseClock(end); err != nil {
+			return nil, fmt.Errorf("invalid %s.%s: %w", quietHoursField, quietEndField, err)
+		}
+	}
+	days, err := getStringSlice(cfg, quietDaysField)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range days {
+		wd, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q in %s.%s: expected one of sun, mon, tue, wed, thu, fri or sat", d, quietHoursField, quietDaysField)
+		}
+		q.days[wd] = true
+	}
+	if q.start == q.end && len(q.days) == 0 {
+		return nil, fmt.Errorf("delivery config field %q needs quiet hours or days", quietHoursField)
+	}
+	tz, err := getString(cfg, quietTimezoneField)
+	if err != nil {
+		return nil, err
+	}
+	if tz != "" {
+		if q.loc, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("invalid %s.%s: %w", quietHoursField, quietTimezoneField, err)
+		}
+	}
+	mode, err := getString(cfg, quietModeField)
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case "", quietModeFailuresOnly:
+	case quietModeQueue:
+		q.queue = true
+	default:
+		return nil, fmt.Errorf("expected %s.%s to be %q or %q, got %q", quietHoursField, quietModeField, quietModeFailuresOnly, quietModeQueue, mode)
+	}
+	return q, nil
+}
+
+// parseClock parses `HH:MM` as minutes after midnight.
+func parseClock(v string) (int, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("expected a time of day like 22:00, got %q", v)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// active reports whether t falls in the quiet hours.
+func (q *quietHours) active(t time.Time) bool {
+	t = t.In(q.loc)
+	if q.days[t.Weekday()] {
+		return true
+	}
+	m := t.Hour()*60 + t.Minute()
+	switch {
+	case q.start == q.end:
+		return false
+	case q.start < q.end:
+		return m >= q.start && m < q.end
+	default:
+		return m >= q.start || m < q.end
+	}
+}
+
+// until returns when the quiet hours around t end, walking forward a minute at a time so that
+// daylight saving changes are handled by the time zone. Quiet days can extend it up to a week.
+func (q *quietHours) until(t time.Time) time.Time {
+	end := t.Truncate(time.Minute)
+	for i := 0; i < 8*24*60 && q.active(end); i++ {
+		end = end.Add(time.Minute)
+	}
+	return end
+}
+
+// queueQuiet holds a Build notified during quiet hours, scheduling the summary when it's the first.
+func (s *discordNotifier) queueQuiet(build *cbpb.Build) {
+	s.queueQuietLines(s.summaryLine(build))
+}
+
+func (s *discordNotifier) queueQuietLines(lines ...summaryLine) {
+	q := s.quiet
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	first := len(q.queued) == 0
+	q.queued = append(q.queued, lines...)
+	if !first || len(q.queued) == 0 {
+		return
+	}
+	now := s.clock()
+	s.afterFunc(q.until(now).Sub(now), s.flushQuiet)
+}
+
+// takeQueued empties the quiet hours queue, returning the Builds it held.
+func (q *quietHours) takeQueued() []summaryLine {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	lines := q.queued
+	q.queued = nil
+	return lines
+}
+
+// flushQuiet posts the Builds queued during the quiet hours.
+func (s *discordNotifier) flushQuiet() {
+	lines := s.quiet.takeQueued()
+	if len(lines) == 0 {
+		return
+	}
+	if err := s.postSummary(context.Background(), s.webhookURL, s.textN("title.quietHours", len(lines)), lines); err != nil {
+		notifierLog.Errorf("failed to post the quiet hours summary of %d builds: %v", len(lines), err)
+	}
+}
+
+// throttle caps the messages posted to one webhook within a sliding window. Messages over the cap are
+// collected into a single summary posted once the window allows.
+type throttle struct {
+	max    int
+	window time.Duration
+
+	mu       sync.Mutex
+	sent     []time.Time
+	overflow []summaryLine
+}
+
+// getThrottle returns the throttle configured in the optional throttleField map, or nil if it is unset.
+func getThrottle(delivery map[string]interface{}) (*throttle, error) {
+	raw, ok := delivery[throttleField]
+	if !ok {
+		return nil, nil
+	}
+	cfg, err := toStringMap(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delivery config field %q: %w", throttleField, err)
+	}
+	for k := range cfg {
+		switch k {
+		case throttleMaxField, throttleWindowField:
+		default:
+			return nil, fmt.Errorf("unknown field %q in delivery config field %q", k, throttleField)
+		}
+	}
+	t := new(throttle)
+	if t.max, err = getInt(cfg, throttleMaxField); err != nil {
+		return nil, err
+	}
+	if t.window, err = getDuration(cfg, throttleWindowField); err != nil {
+		return nil, err
+	}
+	if t.max <= 0 || t.window == 0 {
+		return nil, fmt.Errorf("delivery config field %q needs a positive %q and a %q", throttleField, throttleMaxField, throttleWindowField)
+	}
+	return t, nil
+}
+
+// newThrottle returns a fresh throttle for one webhook with the configured limits, or nil if unset.
+func (s *discordNotifier) newThrottle() *throttle {
+	if s.throttle == nil {
+		return nil
+	}
+	return &throttle{max: s.throttle.max, window: s.throttle.window}
+}
+
+// allow records a message if the window has room, dropping sends that have left the window.
+func (t *throttle) allow(now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.allowLocked(now)
+}
+
+func (t *throttle) allowLocked(now time.Time) bool {
+	i := 0
+	for ; i < len(t.sent) && now.Sub(t.sent[i]) >= t.window; i++ {
+	}
+	t.sent = t.sent[i:]
+	if len(t.sent) >= t.max {
+		return false
+	}
+	t.sent = append(t.sent, now)
+	return true
+}
+
+// hold adds the Build to the overflow, reporting how long until the summary can be posted if it is
+// the first one held, or false if a summary is already scheduled.
+func (t *throttle) hold(now time.Time, l summaryLine) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.overflow = append(t.overflow, l)
+	if len(t.overflow) > 1 {
+		return 0, false
+	}
+	return t.sent[0].Add(t.window).Sub(now), true
+}
+
+// take returns the overflow to summarize, recording the summary as a sent message.
+func (t *throttle) take(now time.Time) []summaryLine {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := t.overflow
+	t.overflow = nil
+	t.allowLocked(now)
+	return lines
+}
+
+// throttled reports whether the sink is over its cap, holding the Build for the summary if so.
+func (d *discordSink) throttled(build *cbpb.Build) bool {
+	if d.throttle == nil {
+		return false
+	}
+	now := d.n.clock()
+	if d.throttle.allow(now) {
+		return false
+	}
+	if wait, first := d.throttle.hold(now, d.n.summaryLine(build)); first {
+		d.n.afterFunc(wait, d.flushThrottled)
+	}
+	buildLog(build).Infof("throttled Build %q: over %d messages in %v", build.Id, d.throttle.max, d.throttle.window)
+	return true
+}
+
+// flushThrottled posts a single message summarizing the throttled Builds.
+func (d *discordSink) flushThrottled() {
+	lines := d.throttle.take(d.n.clock())
+	if len(lines) == 0 {
+		return
+	}
+	if err := d.n.postSummary(context.Background(), d.url, d.n.textN("title.throttled", len(lines)), lines); err != nil {
+		notifierLog.Errorf("failed to post the summary of %d throttled builds: %v", len(lines), err)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// scheduled records functions passed to a discordNotifier's schedule so tests can run them on demand.
+type scheduled struct {
+	delays []time.Duration
+	funcs  []func()
+}
+
+func (s *scheduled) schedule(d time.Duration, f func()) {
+	s.delays = append(s.delays, d)
+	s.funcs = append(s.funcs, f)
+}
+
+func (s *scheduled) runAll() {
+	funcs := s.funcs
+	s.funcs, s.delays = nil, nil
+	for _, f := range funcs {
+		f()
+	}
+}
+
+func TestQuietHoursActive(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	for _, tc := range []struct {
+		name  string
+		quiet map[interface{}]interface{}
+		at    time.Time
+		want  bool
+		until time.Time
+	}{{
+		name:  "overnight, before midnight",
+		quiet: map[interface{}]interface{}{quietStartField: "22:00", quietEndField: "07:00"},
+		at:    time.Date(2021, 9, 1, 23, 30, 0, 0, time.UTC),
+		want:  true,
+		until: time.Date(2021, 9, 2, 7, 0, 0, 0, time.UTC),
+	}, {
+		name:  "overnight, after midnight",
+		quiet: map[interface{}]interface{}{quietStartField: "22:00", quietEndField: "07:00"},
+		at:    time.Date(2021, 9, 2, 6, 59, 0, 0, time.UTC),
+		want:  true,
+		until: time.Date(2021, 9, 2, 7, 0, 0, 0, time.UTC),
+	}, {
+		name:  "overnight, daytime",
+		quiet: map[interface{}]interface{}{quietStartField: "22:00", quietEndField: "07:00"},
+		at:    time.Date(2021, 9, 2, 7, 0, 0, 0, time.UTC),
+		want:  false,
+	}, {
+		name:  "same day",
+		quiet: map[interface{}]interface{}{quietStartField: "12:00", quietEndField: "13:00"},
+		at:    time.Date(2021, 9, 2, 12, 15, 0, 0, time.UTC),
+		want:  true,
+		until: time.Date(2021, 9, 2, 13, 0, 0, 0, time.UTC),
+	}, {
+		name:  "time zone",
+		quiet: map[interface{}]interface{}{quietStartField: "22:00", quietEndField: "07:00", quietTimezoneField: "Europe/Paris"},
+		at:    time.Date(2021, 9, 1, 21, 0, 0, 0, time.UTC),
+		want:  true,
+		until: time.Date(2021, 9, 2, 7, 0, 0, 0, paris),
+	}, {
+		name: "weekend following the night",
+		quiet: map[interface{}]interface{}{
+			quietStartField: "22:00", quietEndField: "07:00",
+			quietDaysField: []interface{}{"sat", "Sun"},
+		},
+		// A Friday night.
+		at:    time.Date(2021, 9, 3, 23, 0, 0, 0, time.UTC),
+		want:  true,
+		until: time.Date(2021, 9, 6, 7, 0, 0, 0, time.UTC),
+	}, {
+		name:  "weekday outside quiet days",
+		quiet: map[interface{}]interface{}{quietDaysField: []interface{}{"sat", "sun"}},
+		at:    time.Date(2021, 9, 3, 12, 0, 0, 0, time.UTC),
+		want:  false,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := getQuietHours(map[string]interface{}{quietHoursField: tc.quiet})
+			if err != nil {
+				t.Fatalf("getQuietHours failed: %v", err)
+			}
+			if got := q.active(tc.at); got != tc.want {
+				t.Fatalf("active(%v) = %v, want %v", tc.at, got, tc.want)
+			}
+			if !tc.want {
+				return
+			}
+			if got := q.until(tc.at); !got.Equal(tc.until) {
+				t.Errorf("until(%v) = %v, want %v", tc.at, got, tc.until)
+			}
+		})
+	}
+}
+
+func TestGetQuietHoursErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		quiet interface{}
+	}{
+		{name: "not a map", quiet: "22:00-07:00"},
+		{name: "unknown field", quiet: map[interface{}]interface{}{"from": "22:00"}},
+		{name: "start without end", quiet: map[interface{}]interface{}{quietStartField: "22:00"}},
+		{name: "invalid time", quiet: map[interface{}]interface{}{quietStartField: "10pm", quietEndField: "07:00"}},
+		{name: "nothing quiet", quiet: map[interface{}]interface{}{quietTimezoneField: "UTC"}},
+		{name: "unknown day", quiet: map[interface{}]interface{}{quietDaysField: []interface{}{"saturday"}}},
+		{name: "unknown time zone", quiet: map[interface{}]interface{}{quietDaysField: []interface{}{"sat"}, quietTimezoneField: "Mars/Olympus"}},
+		{name: "unknown mode", quiet: map[interface{}]interface{}{quietDaysField: []interface{}{"sat"}, quietModeField: "drop"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := getQuietHours(map[string]interface{}{quietHoursField: tc.quiet}); err == nil {
+				t.Error("getQuietHours succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestQuietHoursDropsNonFailures(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusNoContent, "")
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{
+		quietHoursField: map[interface{}]interface{}{quietStartField: "22:00", quietEndField: "07:00"},
+	})
+	n.now = func() time.Time { return time.Date(2021, 9, 1, 23, 0, 0, 0, time.UTC) }
+
+	if reason, err := n.sendOnce(context.Background(), testBuild()); err != nil || reason != skipQuietHours {
+		t.Errorf("sendOnce of a SUCCESS Build got (%q, %v), want (%q, nil)", reason, err, skipQuietHours)
+	}
+	failed := testBuild()
+	failed.Status = cbpb.Build_FAILURE
+	if reason, err := n.sendOnce(context.Background(), failed); err != nil || reason != "" {
+		t.Errorf("sendOnce of a FAILURE Build got (%q, %v), want it sent", reason, err)
+	}
+	if got := len(reqs()); got != 1 {
+		t.Errorf("got %d webhook requests, want 1", got)
+	}
+}
+
+func TestQuietHoursQueue(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusNoContent, "")
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{
+		quietHoursField: map[interface{}]interface{}{quietStartField: "22:00", quietEndField: "07:00", quietModeField: quietModeQueue},
+		usernameField:   "CI for $PROJECT_ID",
+	})
+	n.now = func() time.Time { return time.Date(2021, 9, 1, 23, 0, 0, 0, time.UTC) }
+	var sched scheduled
+	n.schedule = sched.schedule
+
+	ok := testBuild()
+	working := testBuild()
+	working.Status = cbpb.Build_WORKING
+	cancelled := testBuild()
+	cancelled.Id = "cancelled-build-id"
+	cancelled.Status = cbpb.Build_CANCELLED
+	for _, b := range []*cbpb.Build{working, ok, cancelled} {
+		if err := n.SendNotification(context.Background(), b); err != nil {
+			t.Fatalf("SendNotification failed: %v", err)
+		}
+	}
+	if len(reqs()) != 0 {
+		t.Fatalf("got %d webhook requests during quiet hours, want 0", len(reqs()))
+	}
+	if diff := cmp.Diff([]time.Duration{8 * time.Hour}, sched.delays); diff != "" {
+		t.Fatalf("got unexpected scheduled flush diff: %s", diff)
+	}
+
+	sched.runAll()
+	got := reqs()
+	if len(got) != 1 {
+		t.Fatalf("got %d webhook requests after quiet hours, want 1", len(got))
+	}
+	var msg discordMessage
+	if err := json.Unmarshal(got[0].body, &msg); err != nil {
+		t.Fatalf("failed to unmarshal summary: %v", err)
+	}
+	want := []embed{{
+		Title: "🌙 2 builds during quiet hours",
+		Color: summaryColor,
+		Description: "✅ my-app SUCCESS ([Build](" + buildPageURL(ok) + "))\n" +
+			"⚪ my-app CANCELLED ([Build](" + buildPageURL(cancelled) + "))",
+	}}
+	if diff := cmp.Diff(want, msg.Embeds); diff != "" {
+		t.Errorf("got unexpected summary diff: %s", diff)
+	}
+	if want := "CI for my-project-id"; msg.Username != want {
+		t.Errorf("got summary username %q, want %q", msg.Username, want)
+	}
+}
+
+func TestSummaryUsername(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		username string
+		projects []string
+		want     string
+	}{
+		{name: "one project", username: "CI for $PROJECT_ID", projects: []string{"prod", "prod"}, want: "CI for prod"},
+		{name: "several projects", username: "CI for $PROJECT_ID", projects: []string{"prod", "staging"}, want: "CI for"},
+		{name: "no placeholder", username: "CI", projects: []string{"prod", "staging"}, want: "CI"},
+		{name: "unset", projects: []string{"prod"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var lines []summaryLine
+			for _, p := range tc.projects {
+				lines = append(lines, summaryLine{app: "app", project: p})
+			}
+			n := &discordNotifier{username: tc.username}
+			if got := n.summaryUsername(lines); got != tc.want {
+				t.Errorf("summaryUsername() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetUpQuietQueueRequiresWebhook(t *testing.T) {
+	cfg := newBotTestConfig(map[string]interface{}{
+		quietHoursField:    map[interface{}]interface{}{quietDaysField: []interface{}{"sun"}, quietModeField: quietModeQueue},
+		botTokenSecretName: map[interface{}]interface{}{"secretRef": "bot-token"},
+		channelIDField:     "123456789",
+	})
+	sg := fakeSecretGetter{"projects/p/secrets/bot-token/versions/latest": "s3cret"}
+	if err := new(discordNotifier).SetUp(context.Background(), cfg, sg, nil); err == nil {
+		t.Error("SetUp succeeded, want error")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusNoContent, "")
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{
+		throttleField: map[interface{}]interface{}{throttleMaxField: 2, throttleWindowField: "10m"},
+	})
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+	var sched scheduled
+	n.schedule = sched.schedule
+	buf := captureLog(t, levelInfo)
+	skipped := notifierMetrics.notifications[notificationKey{"skipped", "SUCCESS"}]
+
+	var builds []*cbpb.Build
+	for _, id := range []string{"a", "b", "c", "d"} {
+		b := testBuild()
+		b.Id = id
+		builds = append(builds, b)
+		if err := n.SendNotification(context.Background(), b); err != nil {
+			t.Fatalf("SendNotification failed: %v", err)
+		}
+		now = now.Add(time.Minute)
+	}
+	if got := len(reqs()); got != 2 {
+		t.Fatalf("got %d webhook requests, want 2 before the summary", got)
+	}
+	if got := strings.Count(buf.String(), "reason="+string(skipThrottled)); got != 2 {
+		t.Errorf("got %d %s skips logged, want 2", got, skipThrottled)
+	}
+	if got := notifierMetrics.notifications[notificationKey{"skipped", "SUCCESS"}] - skipped; got != 2 {
+		t.Errorf("got %d skipped notifications counted, want 2", got)
+	}
+	if diff := cmp.Diff([]time.Duration{8 * time.Minute}, sched.delays); diff != "" {
+		t.Fatalf("got unexpected scheduled summary diff: %s", diff)
+	}
+
+	now = time.Date(2021, 9, 1, 12, 10, 0, 0, time.UTC)
+	sched.runAll()
+	got := reqs()
+	if len(got) != 3 {
+		t.Fatalf("got %d webhook requests, want 3 after the summary", len(got))
+	}
+	var msg discordMessage
+	if err := json.Unmarshal(got[2].body, &msg); err != nil {
+		t.Fatalf("failed to unmarshal summary: %v", err)
+	}
+	want := []embed{{
+		Title: "➕ 2 more builds",
+		Color: summaryColor,
+		Description: "✅ my-app SUCCESS ([Build](" + buildPageURL(builds[2]) + "))\n" +
+			"✅ my-app SUCCESS ([Build](" + buildPageURL(builds[3]) + "))",
+	}}
+	if diff := cmp.Diff(want, msg.Embeds); diff != "" {
+		t.Errorf("got unexpected summary diff: %s", diff)
+	}
+
+	// The summary takes the slot freed by the first message; the second leaves the window a minute later.
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := len(reqs()); got != 3 {
+		t.Fatalf("got %d webhook requests, want 3 while the window is full", got)
+	}
+	sched.funcs, sched.delays = nil, nil
+	now = now.Add(time.Minute)
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := len(reqs()); got != 4 {
+		t.Errorf("got %d webhook requests, want 4", got)
+	}
+}
+
+func TestGetThrottleErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		throttle interface{}
+	}{
+		{name: "not a map", throttle: 20},
+		{name: "unknown field", throttle: map[interface{}]interface{}{"per": "10m"}},
+		{name: "missing max", throttle: map[interface{}]interface{}{throttleWindowField: "10m"}},
+		{name: "missing window", throttle: map[interface{}]interface{}{throttleMaxField: 20}},
+		{name: "invalid window", throttle: map[interface{}]interface{}{throttleMaxField: 20, throttleWindowField: "ten"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := getThrottle(map[string]interface{}{throttleField: tc.throttle}); err == nil {
+				t.Error("getThrottle succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestSummaryMessageCapsLines(t *testing.T) {
+	lines := make([]summaryLine, maxSummaryLines+3)
+	for i := range lines {
+		lines[i] = summaryLine{app: "app", status: cbpb.Build_FAILURE}
+	}
+	msg := new(discordNotifier).summaryMessage("title", lines)
+	want := ""
+	for i := 0; i < maxSummaryLines; i++ {
+		want += "❌ app FAILURE\n"
+	}
+	want += "… and 3 more"
+	if diff := cmp.Diff(want, msg.Embeds[0].Description); diff != "" {
+		t.Errorf("got unexpected description diff: %s", diff)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// defaultEnvSubstitution is the substitution holding a Build's environment name.
+const defaultEnvSubstitution = "_ENV"
+
+// severityGate is a simpler alternative to a CEL filter: a Build is notified if its status
+// is one of statuses OR its environment is one of environments.
+type severityGate struct {
+	statuses        map[cbpb.Build_Status]bool
+	environments    map[string]bool
+	envSubstitution string
+}
+
+// allows reports whether the Build passes the gate.
+func (g *severityGate) allows(build *cbpb.Build) bool {
+	return g.statuses[build.Status] || g.environments[build.Substitutions[g.envSubstitution]]
+}
+
+// getSeverityGate reads the optional severity gate, e.g.
+//
+//	severity:
+//	  statuses: [FAILURE, INTERNAL_ERROR, TIMEOUT]
+//	  environments: [prod]
+func getSeverityGate(delivery map[string]interface{}) (*severityGate, error) {
+	v, ok := delivery[severityField]
+	if !ok {
+		return nil, nil
+	}
+	m, err := toStringMap(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delivery config field %q: %w", severityField, err)
+	}
+
+	statuses, err := getStringSlice(m, "statuses")
+	if err != nil {
+		return nil, fmt.Errorf("invalid delivery config field %q: %w", severityField, err)
+	}
+	envs, err := getStringSlice(m, "environments")
+	if err != nil {
+		return nil, fmt.Errorf("invalid delivery config field %q: %w", severityField, err)
+	}
+	envSub, err := getString(m, "envSubstitution")
+	if err != nil {
+		return nil, fmt.Errorf("invalid delivery config field %q: %w", severityField, err)
+	}
+	if len(statuses) == 0 && len(envs) == 0 {
+		return nil, errors.New("expected delivery config field \"severity\" to set statuses and/or environments")
+	}
+	if envSub == "" {
+		envSub = defaultEnvSubstitution
+	}
+
+	g := &severityGate{
+		statuses:        make(map[cbpb.Build_Status]bool, len(statuses)),
+		environments:    make(map[string]bool, len(envs)),
+		envSubstitution: envSub,
+	}
+	for _, name := range statuses {
+		st, err := parseStatus(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid delivery config field %q: %w", severityField, err)
+		}
+		g.statuses[st] = true
+	}
+	for _, env := range envs {
+		g.environments[env] = true
+	}
+	return g, nil
+}
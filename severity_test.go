@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestSeverityGate(t *testing.T) {
+	g, err := getSeverityGate(map[string]interface{}{
+		severityField: map[interface{}]interface{}{
+			"statuses":     []interface{}{"FAILURE"},
+			"environments": []interface{}{"prod"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("getSeverityGate failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		status cbpb.Build_Status
+		env    string
+		want   bool
+	}{
+		{name: "prod success", status: cbpb.Build_SUCCESS, env: "prod", want: true},
+		{name: "dev success", status: cbpb.Build_SUCCESS, env: "dev", want: false},
+		{name: "dev failure", status: cbpb.Build_FAILURE, env: "dev", want: true},
+		{name: "no env success", status: cbpb.Build_SUCCESS, want: false},
+	} {
+		b := testBuild()
+		b.Status = tc.status
+		b.Substitutions["_ENV"] = tc.env
+		if got := g.allows(b); got != tc.want {
+			t.Errorf("%s: allows got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSendNotificationBelowSeverity(t *testing.T) {
+	srv, requests := recordingServer(t, http.StatusNoContent, "")
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{
+		skipMetricsField: true,
+		severityField: map[interface{}]interface{}{
+			"statuses":        []interface{}{"FAILURE"},
+			"environments":    []interface{}{"prod"},
+			"envSubstitution": "_STAGE",
+		},
+	})
+
+	before := skipCount(skipBelowSeverity)
+	dev := testBuild()
+	dev.Substitutions["_STAGE"] = "dev"
+	if err := n.SendNotification(context.Background(), dev); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := skipCount(skipBelowSeverity) - before; got != 1 {
+		t.Errorf("got %d BELOW_SEVERITY skips for a dev success, want 1", got)
+	}
+
+	prod := testBuild()
+	prod.Substitutions["_STAGE"] = "prod"
+	if err := n.SendNotification(context.Background(), prod); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := len(requests()); got != 1 {
+		t.Errorf("got %d webhook requests, want only the prod success to be sent", got)
+	}
+}
+
+func TestGetSeverityGateErrors(t *testing.T) {
+	for name, v := range map[string]interface{}{
+		"not a map":      "FAILURE",
+		"empty":          map[interface{}]interface{}{},
+		"unknown status": map[interface{}]interface{}{"statuses": []interface{}{"EXPLODED"}},
+	} {
+		if _, err := getSeverityGate(map[string]interface{}{severityField: v}); err == nil {
+			t.Errorf("%s: getSeverityGate succeeded, want error", name)
+		}
+	}
+}
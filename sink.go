@@ -0,0 +1,481 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// sinksField lists several delivery targets, replacing the top-level webhookUrl/deliveryMode.
+	sinksField = "sinks"
+
+	// fallbackWebhookURLSecretName is an optional second webhook used when the primary one fails.
+	fallbackWebhookURLSecretName = "fallbackWebhookUrl"
+
+	sinkTypeDiscord = "discord"
+	sinkTypeSlack   = "slack"
+	sinkTypeLog     = "log"
+	sinkTypeTeams   = "teams"
+	sinkTypeWebhook = "webhook"
+
+	// mirrorField is one extra sink, in the form of a `sinks` entry, that receives every message as well.
+	mirrorField = "mirror"
+)
+
+// sink delivers a rendered message to one destination.
+type sink interface {
+	// name identifies the sink in logs and errors.
+	name() string
+	send(ctx context.Context, build *cbpb.Build, msg *discordMessage) error
+}
+
+// setUpSinks builds the configured delivery targets: either the `sinks` list or the single top-level delivery,
+// plus the optional mirror, which is nil when unset.
+func (s *discordNotifier) setUpSinks(ctx context.Context, cfg *notifiers.Config, sg notifiers.SecretGetter) ([]sink, sink, error) {
+	sinks, err := s.setUpPrimarySinks(ctx, cfg, sg)
+	if err != nil {
+		return nil, nil, err
+	}
+	raw, ok := cfg.Spec.Notification.Delivery[mirrorField]
+	if !ok {
+		return sinks, nil, nil
+	}
+	mc, err := toStringMap(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid delivery config field %q: %w", mirrorField, err)
+	}
+	mirror, err := s.setUpConfiguredSink(ctx, cfg, sg, mc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid delivery config field %q: %w", mirrorField, err)
+	}
+	return sinks, mirror, nil
+}
+
+// setUpPrimarySinks builds either the `sinks` list or the single top-level delivery.
+func (s *discordNotifier) setUpPrimarySinks(ctx context.Context, cfg *notifiers.Config, sg notifiers.SecretGetter) ([]sink, error) {
+	delivery := cfg.Spec.Notification.Delivery
+	raw, ok := delivery[sinksField]
+	if !ok {
+		dm, err := getString(delivery, deliveryModeField)
+		if err != nil {
+			return nil, err
+		}
+		switch dm {
+		case "", deliveryModeWebhook:
+			_, hasWebhook := delivery[webhookURLSecretName]
+			_, hasToken := delivery[botTokenSecretName]
+			_, hasChannel := delivery[channelIDField]
+			perApp := hasAppRouting(delivery)
+			switch {
+			case (hasWebhook || perApp) && (hasToken || hasChannel):
+				return nil, fmt.Errorf("delivery config fields %q, %q and %q are mutually exclusive with %q/%q", webhookURLSecretName, webhookURLsField, webhookURLSecretField, botTokenSecretName, channelIDField)
+			case hasToken || hasChannel:
+				bs, err := s.setUpBotSink(ctx, cfg, sg, delivery)
+				if err != nil {
+					return nil, err
+				}
+				return []sink{bs}, nil
+			case !hasWebhook && !perApp:
+				return nil, fmt.Errorf("expected delivery config to set either %q or %q and %q", webhookURLSecretName, botTokenSecretName, channelIDField)
+			}
+			var ds *discordSink
+			if hasWebhook {
+				wu, err := getSecret(ctx, sg, cfg.Spec.Secrets, delivery, webhookURLSecretName)
+				if err != nil {
+					return nil, err
+				}
+				s.webhookURL = wu
+				ds = s.newDiscordSink(wu)
+				if _, ok := delivery[fallbackWebhookURLSecretName]; ok {
+					if ds.fallback, err = getSecret(ctx, sg, cfg.Spec.Secrets, delivery, fallbackWebhookURLSecretName); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if perApp {
+				as, err := s.setUpAppSink(ctx, cfg, sg, delivery, ds)
+				if err != nil {
+					return nil, err
+				}
+				return []sink{as}, nil
+			}
+			return []sink{ds}, nil
+		case deliveryModeLog:
+			return []sink{&logSink{out: os.Stdout}}, nil
+		default:
+			return nil, fmt.Errorf("expected delivery config field %q to be one of %q or %q, got %q", deliveryModeField, deliveryModeWebhook, deliveryModeLog, dm)
+		}
+	}
+
+	list, ok := raw.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("expected delivery config field %q to be a non-empty list", sinksField)
+	}
+	var sinks []sink
+	for i, item := range list {
+		sc, err := toStringMap(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s[%d]: %w", sinksField, i, err)
+		}
+		sk, err := s.setUpConfiguredSink(ctx, cfg, sg, sc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s[%d]: %w", sinksField, i, err)
+		}
+		sinks = append(sinks, sk)
+	}
+	return sinks, nil
+}
+
+// setUpConfiguredSink builds a delivery target from a `{type: ..., webhookUrl: ..., filter: ...}` config map.
+func (s *discordNotifier) setUpConfiguredSink(ctx context.Context, cfg *notifiers.Config, sg notifiers.SecretGetter, sc map[string]interface{}) (sink, error) {
+	typ, err := getString(sc, "type")
+	if err != nil {
+		return nil, err
+	}
+	var sk sink
+	switch typ {
+	case sinkTypeDiscord, sinkTypeSlack, sinkTypeTeams, sinkTypeWebhook:
+		wu, err := getSecret(ctx, sg, cfg.Spec.Secrets, sc, webhookURLSecretName)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case sinkTypeDiscord:
+			sk = s.newDiscordSink(wu)
+		case sinkTypeSlack:
+			sk = &slackSink{n: s, url: wu}
+		case sinkTypeTeams:
+			sk = &teamsSink{n: s, url: wu}
+		default:
+			sk = &jsonWebhookSink{n: s, url: wu}
+		}
+	case sinkTypeLog:
+		sk = &logSink{out: os.Stdout}
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+
+	filter, err := getString(sc, "filter")
+	if err != nil {
+		return nil, err
+	}
+	if filter != "" {
+		prd, err := notifiers.MakeCELPredicate(filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make a CEL predicate: %w", err)
+		}
+		sk = &routedSink{sink: sk, filter: prd}
+	}
+	return sk, nil
+}
+
+// setUpBotSink builds a bot delivery target from the botToken secret and channelId in the given config.
+func (s *discordNotifier) setUpBotSink(ctx context.Context, cfg *notifiers.Config, sg notifiers.SecretGetter, delivery map[string]interface{}) (*botSink, error) {
+	channel, err := getString(delivery, channelIDField)
+	if err != nil {
+		return nil, err
+	}
+	if channel == "" {
+		return nil, fmt.Errorf("delivery config field %q requires %q to be set", botTokenSecretName, channelIDField)
+	}
+	if _, ok := delivery[botTokenSecretName]; !ok {
+		return nil, fmt.Errorf("delivery config field %q requires %q to be set", channelIDField, botTokenSecretName)
+	}
+	token, err := getSecret(ctx, sg, cfg.Spec.Secrets, delivery, botTokenSecretName)
+	if err != nil {
+		return nil, err
+	}
+	return s.newBotSink(token, channel), nil
+}
+
+// routedSink only delivers Builds matching its CEL filter, so one notifier can route Builds to different channels.
+type routedSink struct {
+	sink
+	filter notifiers.EventFilter
+}
+
+func (r *routedSink) send(ctx context.Context, build *cbpb.Build, msg *discordMessage) error {
+	if !r.filter.Apply(ctx, build) {
+		buildLog(build).Infof("not routing Build %q to %s sink: filter did not match", build.Id, r.name())
+		return nil
+	}
+	return r.sink.send(ctx, build, msg)
+}
+
+// deliver sends the message to every sink. It returns an error if any primary sink fails; mirror
+// failures are only logged.
+func (s *discordNotifier) deliver(ctx context.Context, build *cbpb.Build, msg *discordMessage) error {
+	err := s.deliverPrimary(ctx, build, msg)
+	if s.mirror != nil {
+		// The primary sinks already have the message, so a mirror failure must not get the event
+		// redelivered and posted to them twice.
+		if merr := s.mirror.send(ctx, build, msg); skipReasonOf(merr) != "" {
+			buildLog(build).Infof("%s mirror sink held back Build %q: %v", s.mirror.name(), build.Id, merr)
+		} else if merr != nil {
+			buildLog(build).Warningf("failed to mirror Build %q to %s sink: %v", build.Id, s.mirror.name(), s.redactError(build, merr))
+			notifierMetrics.mirrorFailure()
+		}
+	}
+	return err
+}
+
+// deliverPrimary sends the message to every primary sink, failing if any of them fails. A sinkSkip
+// is returned only when every sink held the message back.
+func (s *discordNotifier) deliverPrimary(ctx context.Context, build *cbpb.Build, msg *discordMessage) error {
+	if len(s.sinks) == 1 {
+		return s.sinks[0].send(ctx, build, msg)
+	}
+
+	var failed []string
+	var firstErr, firstSkip error
+	skipped := 0
+	for _, sk := range s.sinks {
+		if err := sk.send(ctx, build, msg); skipReasonOf(err) != "" {
+			buildLog(build).Infof("%s sink held back Build %q: %v", sk.name(), build.Id, err)
+			skipped++
+			if firstSkip == nil {
+				firstSkip = err
+			}
+		} else if err != nil {
+			buildLog(build).Errorf("failed to deliver Build %q to %s sink: %v", build.Id, sk.name(), s.redactError(build, err))
+			failed = append(failed, sk.name())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("failed to deliver to %d of %d sinks (%s): %w", len(failed), len(s.sinks), strings.Join(failed, ", "), firstErr)
+	}
+	if skipped == len(s.sinks) {
+		return firstSkip
+	}
+	return nil
+}
+
+// discordSink posts messages to a Discord webhook.
+type discordSink struct {
+	n       *discordNotifier
+	url     string
+	threads *idStore
+	// cards maps a Build ID to the message being edited in place for it.
+	cards *idStore
+	last  lastSent
+	// fallback receives the message when delivery to url fails.
+	fallback string
+	// throttle caps the messages posted to url; nil when unlimited.
+	throttle *throttle
+}
+
+func (s *discordNotifier) newDiscordSink(webhookURL string) *discordSink {
+	return &discordSink{n: s, url: webhookURL, threads: newIDStore(), cards: newIDStore(), throttle: s.newThrottle()}
+}
+
+func (d *discordSink) name() string {
+	return sinkTypeDiscord
+}
+
+func (d *discordSink) send(ctx context.Context, build *cbpb.Build, msg *discordMessage) error {
+	msg = withinLimits(msg)
+
+	var digest [sha256.Size]byte
+	if d.n.dedupeWindow > 0 {
+		var err error
+		if digest, err = contentDigest(msg); err != nil {
+			return err
+		}
+		if d.last.isDuplicate(digest, d.n.clock(), d.n.dedupeWindow) {
+			return &sinkSkip{skipDuplicateContent}
+		}
+	}
+
+	if d.n.editInPlace {
+		if id, ok := d.cards.get(build.Id); ok {
+			err := d.editCard(ctx, build, msg, id)
+			if err == nil {
+				if d.n.dedupeWindow > 0 {
+					d.last.record(digest, d.n.clock())
+				}
+				return nil
+			}
+			buildLog(build).Warningf("failed to edit message %q for Build %q, posting a new one: %v", id, build.Id, d.n.redactError(build, err))
+			d.cards.delete(build.Id)
+		}
+	}
+
+	// Edits don't post new messages, so only new ones count towards the throttle.
+	if d.throttled(build) {
+		return &sinkSkip{skipThrottled}
+	}
+
+	// Copy the message since thread handling is specific to this webhook.
+	m := *msg
+	query := url.Values{}
+	threadKey, createThread := d.n.prepareThread(d.threads, build, &m, query)
+	trackCard := d.n.editInPlace && !isDoneStatus(build.Status)
+	if trackCard {
+		query.Set("wait", "true")
+	}
+
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal payload %w", err)
+	}
+
+	d.n.logPayload(build, "sending payload", payload)
+	body, err := d.n.postWebhook(ctx, build, d.url, query, payload)
+	if err != nil {
+		if d.fallback == "" {
+			return err
+		}
+		return d.sendFallback(ctx, build, msg, err)
+	}
+	if createThread {
+		storeThread(d.threads, threadKey, body)
+	}
+	if trackCard {
+		storeCard(d.cards, build, body)
+	}
+	if d.n.dedupeWindow > 0 {
+		d.last.record(digest, d.n.clock())
+	}
+	return nil
+}
+
+// sendFallback delivers the message to the fallback webhook after the primary one failed with primaryErr.
+func (d *discordSink) sendFallback(ctx context.Context, build *cbpb.Build, msg *discordMessage, primaryErr error) error {
+	primaryErr = d.n.redactError(build, primaryErr)
+	buildLog(build).Warningf("primary webhook failed for Build %q, sending to fallback webhook: %v", build.Id, primaryErr)
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal payload %w", err)
+	}
+	if _, err := d.n.postWebhook(ctx, build, d.fallback, nil, payload); err != nil {
+		return fmt.Errorf("primary webhook failed (%v) and fallback webhook failed: %w", primaryErr, err)
+	}
+	return nil
+}
+
+// contentDigest hashes the message for duplicate detection. Embed timestamps are left out since
+// they default to the send time and would otherwise make every message unique.
+func contentDigest(msg *discordMessage) ([sha256.Size]byte, error) {
+	m := *msg
+	m.Embeds = append([]embed(nil), msg.Embeds...)
+	for i := range m.Embeds {
+		m.Embeds[i].Timestamp = ""
+	}
+	rendered, err := json.Marshal(m)
+	if err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("Unable to marshal payload %w", err)
+	}
+	return sha256.Sum256(rendered), nil
+}
+
+// lastSent remembers the digest of the last message a sink delivered.
+type lastSent struct {
+	mu     sync.Mutex
+	digest [sha256.Size]byte
+	at     time.Time
+}
+
+// isDuplicate reports whether the digest matches the last delivered message within the window.
+func (l *lastSent) isDuplicate(digest [sha256.Size]byte, now time.Time, window time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.at.IsZero() && l.digest == digest && now.Sub(l.at) < window
+}
+
+func (l *lastSent) record(digest [sha256.Size]byte, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.digest, l.at = digest, now
+}
+
+// slackSink translates messages into Slack incoming webhook attachments.
+type slackSink struct {
+	n   *discordNotifier
+	url string
+}
+
+type slackAttachment struct {
+	Color     string       `json:"color"`
+	Title     string       `json:"title"`
+	TitleLink string       `json:"title_link,omitempty"`
+	Text      string       `json:"text"`
+	Fields    []slackField `json:"fields,omitempty"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short,omitempty"`
+}
+
+type slackMessage struct {
+	Text        string            `json:"text,omitempty"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+// markdownLink matches a Markdown link such as `[Logs](https://...)`.
+var markdownLink = regexp.MustCompile(`\[([^\]]+)\]\((\S+?)\)`)
+
+// slackLinks rewrites Markdown links into Slack's `<url|text>` form.
+func slackLinks(s string) string {
+	return markdownLink.ReplaceAllString(s, "<$2|$1>")
+}
+
+// toSlackMessage converts a Discord message into the equivalent Slack payload.
+func toSlackMessage(msg *discordMessage) *slackMessage {
+	sm := &slackMessage{Text: msg.Content}
+	for _, e := range msg.Embeds {
+		a := slackAttachment{
+			Color:     fmt.Sprintf("#%06x", e.Color),
+			Title:     e.Title,
+			TitleLink: e.URL,
+			Text:      slackLinks(e.Description),
+		}
+		for _, f := range e.Fields {
+			a.Fields = append(a.Fields, slackField{Title: f.Name, Value: slackLinks(f.Value), Short: f.Inline})
+		}
+		sm.Attachments = append(sm.Attachments, a)
+	}
+	return sm
+}
+
+func (sl *slackSink) name() string {
+	return sinkTypeSlack
+}
+
+func (sl *slackSink) send(ctx context.Context, build *cbpb.Build, msg *discordMessage) error {
+	payload, err := json.Marshal(toSlackMessage(msg))
+	if err != nil {
+		return fmt.Errorf("failed to marshal Slack payload: %w", err)
+	}
+	_, err = sl.n.postWebhook(ctx, build, sl.url, nil, payload)
+	return err
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestFanOutToSinks(t *testing.T) {
+	discordSrv, discordReqs := recordingServer(t, http.StatusNoContent, "")
+	slackSrv, slackReqs := recordingServer(t, http.StatusOK, "ok")
+
+	cfg := &notifiers.Config{
+		Spec: &notifiers.Spec{
+			Notification: &notifiers.Notification{
+				Delivery: map[string]interface{}{
+					sinksField: []interface{}{
+						map[interface{}]interface{}{
+							"type":               sinkTypeDiscord,
+							webhookURLSecretName: map[interface{}]interface{}{"secretRef": "discord-url"},
+						},
+						map[interface{}]interface{}{
+							"type":               sinkTypeSlack,
+							webhookURLSecretName: map[interface{}]interface{}{"secretRef": "slack-url"},
+						},
+						map[interface{}]interface{}{"type": sinkTypeLog},
+					},
+				},
+			},
+			Secrets: []*notifiers.Secret{
+				{LocalName: "discord-url", ResourceName: "projects/p/secrets/discord"},
+				{LocalName: "slack-url", ResourceName: "projects/p/secrets/slack"},
+			},
+		},
+	}
+	sg := fakeSecretGetter{
+		"projects/p/secrets/discord": discordSrv.URL,
+		"projects/p/secrets/slack":   slackSrv.URL,
+	}
+	n := &discordNotifier{now: func() time.Time { return time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC) }}
+	if err := n.SetUp(context.Background(), cfg, sg, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	if len(n.sinks) != 3 {
+		t.Fatalf("got %d sinks, want 3", len(n.sinks))
+	}
+	var buf bytes.Buffer
+	n.sinks[2].(*logSink).out = &buf
+
+	b := testBuild()
+	if err := n.SendNotification(context.Background(), b); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+
+	reqs := discordReqs()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d Discord requests, want 1", len(reqs))
+	}
+	var gotDiscord discordMessage
+	if err := json.Unmarshal(reqs[0].body, &gotDiscord); err != nil {
+		t.Fatalf("failed to unmarshal Discord payload: %v", err)
+	}
+	if diff := cmp.Diff(*msg, gotDiscord); diff != "" {
+		t.Errorf("got unexpected Discord payload diff: %s", diff)
+	}
+
+	reqs = slackReqs()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d Slack requests, want 1", len(reqs))
+	}
+	var gotSlack slackMessage
+	if err := json.Unmarshal(reqs[0].body, &gotSlack); err != nil {
+		t.Fatalf("failed to unmarshal Slack payload: %v", err)
+	}
+	wantSlack := slackMessage{
+		Attachments: []slackAttachment{{
+			Color: "#1132d8",
+			Title: "✅ SUCCESS",
+			Text:  slackLinks(msg.Embeds[0].Description),
+		}},
+	}
+	if diff := cmp.Diff(wantSlack, gotSlack); diff != "" {
+		t.Errorf("got unexpected Slack payload diff: %s", diff)
+	}
+
+	var gotLog logEntry
+	if err := json.Unmarshal(buf.Bytes(), &gotLog); err != nil {
+		t.Fatalf("failed to unmarshal log output %q: %v", buf.String(), err)
+	}
+	if gotLog.BuildID != b.Id {
+		t.Errorf("got logged build_id %q, want %q", gotLog.BuildID, b.Id)
+	}
+}
+
+func TestSetUpInvalidSinks(t *testing.T) {
+	for name, sinks := range map[string]interface{}{
+		"not a list":   "discord",
+		"empty":        []interface{}{},
+		"unknown type": []interface{}{map[interface{}]interface{}{"type": "carrier-pigeon"}},
+		"no secret":    []interface{}{map[interface{}]interface{}{"type": sinkTypeSlack}},
+	} {
+		cfg := newTestConfig(map[string]interface{}{sinksField: sinks})
+		if err := new(discordNotifier).SetUp(context.Background(), cfg, fakeSecretGetter{}, nil); err == nil {
+			t.Errorf("%s: SetUp succeeded, want error", name)
+		}
+	}
+}
+
+func TestDedupeIdenticalContent(t *testing.T) {
+	srv, requests := recordingServer(t, http.StatusNoContent, "")
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{dedupeWindowField: "10m"})
+	now := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+
+	before := skipCount(skipDuplicateContent)
+	n.skipMetrics = true
+	for _, b := range []*cbpb.Build{testBuild(), testBuild()} {
+		if err := n.SendNotification(context.Background(), b); err != nil {
+			t.Fatalf("SendNotification failed: %v", err)
+		}
+	}
+	if got := len(requests()); got != 1 {
+		t.Errorf("got %d webhook requests for two identical messages, want 1", got)
+	}
+	if got := skipCount(skipDuplicateContent) - before; got != 1 {
+		t.Errorf("got %d DUPLICATE_CONTENT skips, want 1", got)
+	}
+
+	changed := testBuild()
+	changed.Status = cbpb.Build_FAILURE
+	if err := n.SendNotification(context.Background(), changed); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := len(requests()); got != 2 {
+		t.Errorf("got %d webhook requests after a changed message, want 2", got)
+	}
+
+	// The same content is sent again once the window has passed.
+	now = now.Add(11 * time.Minute)
+	if err := n.SendNotification(context.Background(), changed); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := len(requests()); got != 3 {
+		t.Errorf("got %d webhook requests after the window, want 3", got)
+	}
+}
+
+func TestFallbackWebhook(t *testing.T) {
+	primary, primaryReqs := recordingServer(t, http.StatusInternalServerError, "")
+	fallback, fallbackReqs := recordingServer(t, http.StatusNoContent, "")
+
+	cfg := newTestConfig(map[string]interface{}{
+		fallbackWebhookURLSecretName: map[interface{}]interface{}{"secretRef": "fallback-url"},
+	})
+	cfg.Spec.Secrets = append(cfg.Spec.Secrets, &notifiers.Secret{LocalName: "fallback-url", ResourceName: "projects/p/secrets/fallback"})
+	sg := fakeSecretGetter{
+		"projects/p/secrets/webhook-url/versions/latest": primary.URL,
+		"projects/p/secrets/fallback":                    fallback.URL,
+	}
+	n := new(discordNotifier)
+	if err := n.SetUp(context.Background(), cfg, sg, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	n.retryDelay = time.Millisecond
+
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := len(primaryReqs()); got != defaultMaxAttempts {
+		t.Errorf("got %d primary attempts, want %d", got, defaultMaxAttempts)
+	}
+	reqs := fallbackReqs()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d fallback requests, want 1", len(reqs))
+	}
+	var got discordMessage
+	if err := json.Unmarshal(reqs[0].body, &got); err != nil {
+		t.Fatalf("failed to unmarshal fallback payload: %v", err)
+	}
+	if got.Embeds[0].Title != "✅ SUCCESS" {
+		t.Errorf("got fallback embed title %q, want the original message", got.Embeds[0].Title)
+	}
+}
+
+func TestFallbackHidesPrimaryToken(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const token = "s3cr3t-webhook-token"
+	primaryURL := "http://" + l.Addr().String() + "/api/webhooks/123/" + token
+	l.Close()
+	fallback, fallbackReqs := recordingServer(t, http.StatusNoContent, "")
+
+	cfg := newTestConfig(map[string]interface{}{
+		fallbackWebhookURLSecretName: map[interface{}]interface{}{"secretRef": "fallback-url"},
+	})
+	cfg.Spec.Secrets = append(cfg.Spec.Secrets, &notifiers.Secret{LocalName: "fallback-url", ResourceName: "projects/p/secrets/fallback"})
+	sg := fakeSecretGetter{
+		"projects/p/secrets/webhook-url/versions/latest": primaryURL,
+		"projects/p/secrets/fallback":                    fallback.URL,
+	}
+	n := new(discordNotifier)
+	if err := n.SetUp(context.Background(), cfg, sg, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	n.retryDelay = time.Millisecond
+
+	buf := captureLog(t, levelInfo)
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := len(fallbackReqs()); got != 1 {
+		t.Fatalf("got %d fallback requests, want 1", got)
+	}
+	if !strings.Contains(buf.String(), "sending to fallback webhook") {
+		t.Errorf("got log %q, want the primary failure logged", buf.String())
+	}
+	if strings.Contains(buf.String(), token) {
+		t.Errorf("got log %q, want the primary webhook token left out", buf.String())
+	}
+}
+
+func TestDedupeIgnoresTimestamp(t *testing.T) {
+	srv, requests := recordingServer(t, http.StatusNoContent, "")
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{dedupeWindowField: "10m"})
+	now := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+
+	b := testBuild()
+	b.Status = cbpb.Build_WORKING
+	for i := 0; i < 2; i++ {
+		if err := n.SendNotification(context.Background(), b); err != nil {
+			t.Fatalf("SendNotification failed: %v", err)
+		}
+		now = now.Add(time.Minute)
+	}
+	if got := len(requests()); got != 1 {
+		t.Errorf("got %d webhook requests for messages differing only in timestamp, want 1", got)
+	}
+}
+
+func TestRoutedSinks(t *testing.T) {
+	backendSrv, backendReqs := recordingServer(t, http.StatusNoContent, "")
+	alertsSrv, alertsReqs := recordingServer(t, http.StatusNoContent, "")
+	allSrv, allReqs := recordingServer(t, http.StatusNoContent, "")
+
+	cfg := &notifiers.Config{
+		Spec: &notifiers.Spec{
+			Notification: &notifiers.Notification{
+				Delivery: map[string]interface{}{
+					sinksField: []interface{}{
+						map[interface{}]interface{}{
+							"type":               sinkTypeDiscord,
+							webhookURLSecretName: map[interface{}]interface{}{"secretRef": "backend"},
+							"filter":             `build.substitutions["_APP_NAME"] == "backend"`,
+						},
+						map[interface{}]interface{}{
+							"type":               sinkTypeDiscord,
+							webhookURLSecretName: map[interface{}]interface{}{"secretRef": "alerts"},
+							"filter":             `build.status == Build.Status.FAILURE`,
+						},
+						map[interface{}]interface{}{
+							"type":               sinkTypeDiscord,
+							webhookURLSecretName: map[interface{}]interface{}{"secretRef": "all"},
+						},
+					},
+				},
+			},
+			Secrets: []*notifiers.Secret{
+				{LocalName: "backend", ResourceName: "projects/p/secrets/backend"},
+				{LocalName: "alerts", ResourceName: "projects/p/secrets/alerts"},
+				{LocalName: "all", ResourceName: "projects/p/secrets/all"},
+			},
+		},
+	}
+	sg := fakeSecretGetter{
+		"projects/p/secrets/backend": backendSrv.URL,
+		"projects/p/secrets/alerts":  alertsSrv.URL,
+		"projects/p/secrets/all":     allSrv.URL,
+	}
+	n := new(discordNotifier)
+	if err := n.SetUp(context.Background(), cfg, sg, nil); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		app    string
+		status cbpb.Build_Status
+	}{
+		{app: "backend", status: cbpb.Build_SUCCESS},
+		{app: "frontend", status: cbpb.Build_FAILURE},
+		{app: "frontend", status: cbpb.Build_SUCCESS},
+	} {
+		b := testBuild()
+		b.Substitutions["_APP_NAME"] = tc.app
+		b.Status = tc.status
+		if err := n.SendNotification(context.Background(), b); err != nil {
+			t.Fatalf("SendNotification failed: %v", err)
+		}
+	}
+
+	if got := len(backendReqs()); got != 1 {
+		t.Errorf("got %d backend requests, want 1", got)
+	}
+	if got := len(alertsReqs()); got != 1 {
+		t.Errorf("got %d alerts requests, want 1", got)
+	}
+	if got := len(allReqs()); got != 3 {
+		t.Errorf("got %d unfiltered requests, want 3", got)
+	}
+}
+
+func TestSetUpInvalidSinkFilter(t *testing.T) {
+	cfg := newTestConfig(map[string]interface{}{
+		sinksField: []interface{}{
+			map[interface{}]interface{}{"type": sinkTypeLog, "filter": "build.status =="},
+		},
+	})
+	if err := new(discordNotifier).SetUp(context.Background(), cfg, fakeSecretGetter{}, nil); err == nil {
+		t.Error("SetUp succeeded, want error for an invalid sink filter")
+	}
+}
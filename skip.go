@@ -0,0 +1,76 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"expvar"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// skipReason is the structured reason code logged whenever a Build does not produce a notification.
+type skipReason string
+
+const (
+	// skipFiltered means the Build was rejected by the configured CEL filter.
+	skipFiltered skipReason = "FILTERED"
+	// skipMissingSubstitution means the Build does not set the substitution required by the config.
+	skipMissingSubstitution skipReason = "MISSING_SUBSTITUTION"
+	// skipStatusDisabled means the Build's status is not in the configured notifyStatuses.
+	skipStatusDisabled skipReason = "STATUS_DISABLED"
+	// skipUnhandledStatus means no message is rendered for the Build's status.
+	skipUnhandledStatus skipReason = "UNHANDLED_STATUS"
+	// skipBelowSeverity means the Build did not meet the configured severity gate.
+	skipBelowSeverity skipReason = "BELOW_SEVERITY"
+	// skipDuplicateContent means the message is identical to the last one sent to the same webhook.
+	skipDuplicateContent skipReason = "DUPLICATE_CONTENT"
+	// skipDuplicateEvent means the same Build ID and status was already notified.
+	skipDuplicateEvent skipReason = "DUPLICATE_EVENT"
+	// skipQuietHours means the Build is not a failure and arrived during the configured quiet hours.
+	skipQuietHours skipReason = "QUIET_HOURS"
+	// skipThrottled means the webhook reached its throttle cap and the Build was held for a summary.
+	skipThrottled skipReason = "THROTTLED"
+)
+
+// sinkSkip is returned by a sink that held back the message rather than delivering it.
+type sinkSkip struct {
+	reason skipReason
+}
+
+func (e *sinkSkip) Error() string {
+	return "notification skipped: " + string(e.reason)
+}
+
+// skippedNotifications counts skipped notifications keyed by skipReason when skip metrics are enabled.
+// It is published under /debug/vars via expvar.
+var skippedNotifications = expvar.NewMap("skipped_notifications")
+
+// skip records that no notification will be sent for the given Build.
+func (s *discordNotifier) skip(build *cbpb.Build, reason skipReason) {
+	buildLog(build).Infof("skipping notification for Build %q (status: %q): reason=%s", build.Id, build.Status, reason)
+	if s.skipMetrics {
+		skippedNotifications.Add(string(reason), 1)
+	}
+}
+
+// skipReasonOf returns the reason carried by a sinkSkip error, or "" for any other error.
+func skipReasonOf(err error) skipReason {
+	var skip *sinkSkip
+	if errors.As(err, &skip) {
+		return skip.reason
+	}
+	return ""
+}
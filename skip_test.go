@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func skipCount(reason skipReason) int64 {
+	if v, ok := skippedNotifications.Get(string(reason)).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestSendNotificationSkipReasons(t *testing.T) {
+	filter, err := notifiers.MakeCELPredicate(`build.status == Build.Status.QUEUED`)
+	if err != nil {
+		t.Fatalf("MakeCELPredicate failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		build *cbpb.Build
+		want  skipReason
+	}{{
+		name: "filtered",
+		build: &cbpb.Build{
+			Id:            "filtered",
+			Status:        cbpb.Build_QUEUED,
+			Substitutions: map[string]string{"_APP_NAME": "my-app"},
+		},
+		want: skipFiltered,
+	}, {
+		name: "missing required substitution",
+		build: &cbpb.Build{
+			Id:     "no-app",
+			Status: cbpb.Build_SUCCESS,
+		},
+		want: skipMissingSubstitution,
+	}, {
+		name: "unhandled status",
+		build: &cbpb.Build{
+			Id:            "unhandled",
+			Status:        cbpb.Build_STATUS_UNKNOWN,
+			Substitutions: map[string]string{"_APP_NAME": "my-app"},
+		},
+		want: skipUnhandledStatus,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := &discordNotifier{filter: filter, skipMetrics: true, requireSubstitutions: []string{"_APP_NAME"}}
+			before := skipCount(tc.want)
+			if err := n.SendNotification(context.Background(), tc.build); err != nil {
+				t.Fatalf("SendNotification failed: %v", err)
+			}
+			if got := skipCount(tc.want) - before; got != 1 {
+				t.Errorf("got %d skips with reason %s, want 1", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSkipMetricsDisabled(t *testing.T) {
+	n := &discordNotifier{requireSubstitutions: []string{"_APP_NAME"}}
+	before := skipCount(skipMissingSubstitution)
+	if err := n.SendNotification(context.Background(), &cbpb.Build{Status: cbpb.Build_SUCCESS}); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := skipCount(skipMissingSubstitution) - before; got != 0 {
+		t.Errorf("got %d skips recorded with metrics disabled, want 0", got)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// shortSHALength is the number of characters of a commit SHA shown in the Ref line.
+const shortSHALength = 7
+
+// sourceInfo describes the commit a Build was built from.
+type sourceInfo struct {
+	repo string
+	// ref is the branch or tag name.
+	ref string
+	sha string
+	// commitURL links to the commit on the repository host, if known.
+	commitURL string
+	// archive is the `gs://` URL of a StorageSource Build's source archive.
+	archive string
+}
+
+// source collects the Build's commit details from its RepoSource and SourceProvenance, falling back to the
+// REPO_NAME, BRANCH_NAME, TAG_NAME, COMMIT_SHA and SHORT_SHA substitutions set by triggers.
+func (s *discordNotifier) source(build *cbpb.Build) sourceInfo {
+	repo := build.Source.GetRepoSource()
+	subs := build.Substitutions
+	si := sourceInfo{
+		repo: firstNonEmpty(repo.GetRepoName(), subs["REPO_NAME"]),
+		ref:  firstNonEmpty(repo.GetBranchName(), repo.GetTagName(), subs["BRANCH_NAME"], subs["TAG_NAME"]),
+		sha: firstNonEmpty(build.SourceProvenance.GetResolvedRepoSource().GetCommitSha(), repo.GetCommitSha(),
+			subs["COMMIT_SHA"], subs["SHORT_SHA"]),
+	}
+	if si.sha != "" {
+		si.commitURL = s.commitURL(build, si.sha)
+	}
+	if st := build.Source.GetStorageSource(); st != nil && st.Bucket != "" {
+		si.archive = "gs://" + st.Bucket + "/" + st.Object
+	}
+	return si
+}
+
+// commitURL renders the configured commit URL template, or derives the URL from the `_HEAD_REPO_URL`
+// substitution set by GitHub pull request triggers (using GitLab's path for gitlab hosts), or from a
+// Cloud Source Repositories RepoSource. It returns "" if none is available.
+func (s *discordNotifier) commitURL(build *cbpb.Build, sha string) string {
+	if s.commitURLTemplate != nil {
+		u, err := executeTemplate(s.commitURLTemplate, build)
+		if err != nil {
+			buildLog(build).Warningf("failed to render commit URL for build %q: %v", build.Id, err)
+			return ""
+		}
+		return strings.TrimSpace(u)
+	}
+	if base := build.Substitutions["_HEAD_REPO_URL"]; base != "" {
+		base = strings.TrimSuffix(strings.TrimSuffix(base, "/"), ".git")
+		if u, err := url.Parse(base); err == nil && strings.Contains(u.Host, "gitlab") {
+			return base + "/-/commit/" + sha
+		}
+		return base + "/commit/" + sha
+	}
+	rs := build.Source.GetRepoSource()
+	if project := firstNonEmpty(rs.GetProjectId(), build.ProjectId); rs.GetRepoName() != "" && project != "" {
+		return "https://source.cloud.google.com/" + project + "/" + rs.RepoName + "/+/" + sha
+	}
+	return ""
+}
+
+// refText returns `<branch or tag> @ <short sha>`, with the SHA linked to its commit when the URL is known.
+// Either part is omitted when missing, and "" is returned if both are.
+func (si sourceInfo) refText() string {
+	sha := si.sha
+	if len(sha) > shortSHALength {
+		sha = sha[:shortSHALength]
+	}
+	if sha != "" && si.commitURL != "" {
+		sha = "[" + sha + "](" + si.commitURL + ")"
+	}
+	switch {
+	case si.ref != "" && sha != "":
+		return si.ref + " @ " + sha
+	case si.ref != "":
+		return si.ref
+	}
+	return sha
+}
+
+// sourceLines renders the source section appended to the embed description, one line per known detail.
+func (s *discordNotifier) sourceLines(si sourceInfo) []string {
+	var lines []string
+	if si.repo != "" {
+		lines = append(lines, s.detail("label.repository", si.repo))
+	}
+	if r := si.refText(); r != "" {
+		lines = append(lines, s.detail("label.ref", r))
+	}
+	if si.archive != "" {
+		lines = append(lines, s.detail("label.source", si.archive))
+	}
+	return lines
+}
+
+// firstNonEmpty returns the first of the values that isn't empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestSourceRefText(t *testing.T) {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+	for _, tc := range []struct {
+		name  string
+		build *cbpb.Build
+		want  string
+	}{{
+		name: "branch with resolved commit",
+		build: &cbpb.Build{
+			Source: &cbpb.Source{Source: &cbpb.Source_RepoSource{RepoSource: &cbpb.RepoSource{
+				RepoName: "my-repo",
+				Revision: &cbpb.RepoSource_BranchName{BranchName: "main"},
+			}}},
+			SourceProvenance: &cbpb.SourceProvenance{
+				ResolvedRepoSource: &cbpb.RepoSource{Revision: &cbpb.RepoSource_CommitSha{CommitSha: sha}},
+			},
+		},
+		want: "main @ 0123456",
+	}, {
+		name: "tag",
+		build: &cbpb.Build{
+			Source: &cbpb.Source{Source: &cbpb.Source_RepoSource{RepoSource: &cbpb.RepoSource{
+				Revision: &cbpb.RepoSource_TagName{TagName: "v1.2.0"},
+			}}},
+		},
+		want: "v1.2.0",
+	}, {
+		name: "commit only",
+		build: &cbpb.Build{
+			Source: &cbpb.Source{Source: &cbpb.Source_RepoSource{RepoSource: &cbpb.RepoSource{
+				Revision: &cbpb.RepoSource_CommitSha{CommitSha: sha},
+			}}},
+		},
+		want: "0123456",
+	}, {
+		name: "storage source",
+		build: &cbpb.Build{
+			Source: &cbpb.Source{Source: &cbpb.Source_StorageSource{StorageSource: &cbpb.StorageSource{
+				Bucket: "my-bucket",
+				Object: "source.tgz",
+			}}},
+		},
+	}, {
+		name: "trigger substitutions",
+		build: &cbpb.Build{
+			Substitutions: map[string]string{"BRANCH_NAME": "feature", "COMMIT_SHA": sha, "SHORT_SHA": "0123456"},
+		},
+		want: "feature @ 0123456",
+	}, {
+		name: "pull request head repo",
+		build: &cbpb.Build{
+			Substitutions: map[string]string{
+				"BRANCH_NAME":    "fix",
+				"SHORT_SHA":      "0123456",
+				"_HEAD_REPO_URL": "https://github.com/acme/app.git",
+			},
+		},
+		want: "fix @ [0123456](https://github.com/acme/app/commit/0123456)",
+	}, {
+		name: "gitlab head repo",
+		build: &cbpb.Build{
+			Substitutions: map[string]string{
+				"SHORT_SHA":      "0123456",
+				"_HEAD_REPO_URL": "https://gitlab.com/acme/app",
+			},
+		},
+		want: "[0123456](https://gitlab.com/acme/app/-/commit/0123456)",
+	}, {
+		name: "cloud source repository",
+		build: &cbpb.Build{
+			ProjectId: "my-project-id",
+			Source: &cbpb.Source{Source: &cbpb.Source_RepoSource{RepoSource: &cbpb.RepoSource{
+				RepoName: "my-repo",
+				Revision: &cbpb.RepoSource_CommitSha{CommitSha: sha},
+			}}},
+		},
+		want: "[0123456](https://source.cloud.google.com/my-project-id/my-repo/+/" + sha + ")",
+	}, {
+		name:  "no source",
+		build: &cbpb.Build{},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := new(discordNotifier).source(tc.build).refText(); got != tc.want {
+				t.Errorf("refText got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCommitURLTemplate(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{
+		commitURLTemplateField: "https://github.com/acme/{{.Substitutions.REPO_NAME}}/commit/{{.Substitutions.COMMIT_SHA}}",
+	})
+	b := testBuild()
+	b.Substitutions["REPO_NAME"] = "app"
+	b.Substitutions["TAG_NAME"] = "v1.0.0"
+	b.Substitutions["COMMIT_SHA"] = "0123456789abcdef"
+
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	for _, line := range []string{
+		"\nRepository: app",
+		"\nRef: v1.0.0 @ [0123456](https://github.com/acme/app/commit/0123456789abcdef)",
+	} {
+		if got := msg.Embeds[0].Description; !strings.Contains(got, line) {
+			t.Errorf("got description %q, want it to contain %q", got, line)
+		}
+	}
+
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	bad := newTestConfig(map[string]interface{}{commitURLTemplateField: "{{.Substitutions"})
+	if err := new(discordNotifier).SetUp(context.Background(), bad, sg, nil); err == nil {
+		t.Error("SetUp succeeded with an invalid commitUrlTemplate, want error")
+	}
+}
+
+func TestBuildMessageSourceSection(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		source *cbpb.Source
+		subs   map[string]string
+		want   []string
+	}{{
+		name: "repo source",
+		source: &cbpb.Source{Source: &cbpb.Source_RepoSource{RepoSource: &cbpb.RepoSource{
+			RepoName: "my-repo",
+			Revision: &cbpb.RepoSource_BranchName{BranchName: "main"},
+		}}},
+		want: []string{"Repository: my-repo", "Ref: main"},
+	}, {
+		name: "storage source",
+		source: &cbpb.Source{Source: &cbpb.Source_StorageSource{StorageSource: &cbpb.StorageSource{
+			Bucket: "my-bucket",
+			Object: "source.tgz",
+		}}},
+		want: []string{"Source: gs://my-bucket/source.tgz"},
+	}, {
+		name: "github trigger",
+		subs: map[string]string{"REPO_NAME": "app", "BRANCH_NAME": "main", "SHORT_SHA": "0123456"},
+		want: []string{"Repository: app", "Ref: main @ 0123456"},
+	}, {
+		name: "no source",
+	}} {
+		for _, status := range []cbpb.Build_Status{cbpb.Build_WORKING, cbpb.Build_SUCCESS, cbpb.Build_FAILURE} {
+			b := testBuild()
+			b.Status = status
+			b.Source = tc.source
+			for k, v := range tc.subs {
+				b.Substitutions[k] = v
+			}
+			msg, err := new(discordNotifier).buildMessage(b)
+			if err != nil {
+				t.Fatalf("%s %s: buildMessage failed: %v", tc.name, status, err)
+			}
+			desc := msg.Embeds[0].Description
+			if base := new(discordNotifier).buildDescription(b); !strings.HasPrefix(desc, base) {
+				t.Errorf("%s %s: got description %q, want it to keep the build details %q", tc.name, status, desc, base)
+			}
+			section := ""
+			for _, line := range tc.want {
+				section += "\n" + line
+			}
+			if !strings.HasSuffix(desc, section) {
+				t.Errorf("%s %s: got description %q, want it to end with %q", tc.name, status, desc, section)
+			}
+			for _, label := range []string{"Repository:", "Ref:", "Source:"} {
+				if want := strings.Contains(section, label); strings.Contains(desc, label) != want {
+					t.Errorf("%s %s: got description %q, want %q present %v", tc.name, status, desc, label, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// progressBarWidth is the number of cells in the WORKING progress bar.
+	progressBarWidth = 10
+	// maxFailedSteps caps the number of failed steps detailed in a failure notification.
+	maxFailedSteps = 3
+	// maxArgsLength caps the length, in runes, of a failed step's args summary.
+	maxArgsLength = 120
+)
+
+// isStepDone reports whether a build step has finished, successfully or not.
+func isStepDone(step *cbpb.BuildStep) bool {
+	return isDoneStatus(step.Status)
+}
+
+// isDoneStatus reports whether the status is final, i.e. the Build or step is no longer pending or running.
+func isDoneStatus(status cbpb.Build_Status) bool {
+	switch status {
+	case cbpb.Build_STATUS_UNKNOWN, cbpb.Build_PENDING, cbpb.Build_QUEUED, cbpb.Build_WORKING:
+		return false
+	}
+	return true
+}
+
+// progressLine renders finished versus total steps as a bar, e.g. `Progress: ███░░░░░░░ 4/12 steps`.
+// It returns "" for Builds without steps.
+func (s *discordNotifier) progressLine(build *cbpb.Build) string {
+	total := len(build.Steps)
+	if total == 0 {
+		return ""
+	}
+	done := 0
+	for _, st := range build.Steps {
+		if isStepDone(st) {
+			done++
+		}
+	}
+	filled := done * progressBarWidth / total
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", progressBarWidth-filled)
+	return s.text("text.progress", bar, done, total)
+}
+
+// failedStepLines details each step whose own status is a failure, e.g.
+//
+//	Failed step: test (FAILURE after 1m2s)
+//	`go test ./...`
+//
+// The args line is omitted for steps without args. It returns "" when no step is individually marked failed.
+func (s *discordNotifier) failedStepLines(build *cbpb.Build) string {
+	var lines []string
+	failed := 0
+	for _, st := range build.Steps {
+		if !isFailureStatus(st.Status) {
+			continue
+		}
+		failed++
+		if failed > maxFailedSteps {
+			continue
+		}
+		status := s.statusName(st.Status)
+		if st.Timing != nil && st.Timing.StartTime != nil && st.Timing.EndTime != nil {
+			status = s.text("text.stepFailedAfter", status, formatDuration(st.Timing.EndTime.AsTime().Sub(st.Timing.StartTime.AsTime())))
+		}
+		lines = append(lines, s.text("text.failedStep", stepLabel(st), status))
+		if args := argsSummary(st.Args); args != "" {
+			lines = append(lines, "`"+args+"`")
+		}
+	}
+	if failed > maxFailedSteps {
+		lines = append(lines, s.textN("text.moreFailedSteps", failed-maxFailedSteps))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// argsSummary joins a step's args into one line, truncated to maxArgsLength runes.
+// Backticks are replaced so the summary can be shown as inline code.
+func argsSummary(args []string) string {
+	s := strings.ReplaceAll(strings.Join(args, " "), "`", "'")
+	if r := []rune(s); len(r) > maxArgsLength {
+		s = string(r[:maxArgsLength-1]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func stepsWithStatuses(statuses ...cbpb.Build_Status) []*cbpb.BuildStep {
+	var steps []*cbpb.BuildStep
+	for _, st := range statuses {
+		steps = append(steps, &cbpb.BuildStep{Name: "gcr.io/cloud-builders/docker", Status: st})
+	}
+	return steps
+}
+
+func TestProgressLine(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		steps []*cbpb.BuildStep
+		want  string
+	}{{
+		name: "no steps",
+	}, {
+		name:  "partial",
+		steps: stepsWithStatuses(cbpb.Build_SUCCESS, cbpb.Build_SUCCESS, cbpb.Build_WORKING, cbpb.Build_QUEUED),
+		want:  "Progress: █████░░░░░ 2/4 steps",
+	}, {
+		name:  "none done",
+		steps: stepsWithStatuses(cbpb.Build_WORKING, cbpb.Build_STATUS_UNKNOWN, cbpb.Build_STATUS_UNKNOWN),
+		want:  "Progress: ░░░░░░░░░░ 0/3 steps",
+	}, {
+		name:  "all done",
+		steps: stepsWithStatuses(cbpb.Build_SUCCESS, cbpb.Build_FAILURE),
+		want:  "Progress: ██████████ 2/2 steps",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := new(discordNotifier).progressLine(&cbpb.Build{Steps: tc.steps}); got != tc.want {
+				t.Errorf("progressLine got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildMessageProgress(t *testing.T) {
+	b := testBuild()
+	b.Status = cbpb.Build_WORKING
+	b.Steps = stepsWithStatuses(cbpb.Build_SUCCESS, cbpb.Build_WORKING, cbpb.Build_QUEUED, cbpb.Build_QUEUED)
+
+	n := &discordNotifier{showProgress: true}
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if got, want := msg.Embeds[0].Description, "\nProgress: ██░░░░░░░░ 1/4 steps"; !strings.HasSuffix(got, want) {
+		t.Errorf("got description %q, want it to end with %q", got, want)
+	}
+
+	n.showProgress = false
+	msg, err = n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if got := msg.Embeds[0].Description; strings.Contains(got, "Progress:") {
+		t.Errorf("got description %q with showProgress unset, want no progress line", got)
+	}
+}
+
+func TestBuildMessageFailedStep(t *testing.T) {
+	b := testBuild()
+	b.Status = cbpb.Build_FAILURE
+	b.Steps = []*cbpb.BuildStep{
+		{Id: "build", Status: cbpb.Build_SUCCESS},
+		{Id: "test", Status: cbpb.Build_FAILURE},
+		{Id: "deploy", Status: cbpb.Build_QUEUED},
+	}
+
+	msg, err := new(discordNotifier).buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if got, want := msg.Embeds[0].Description, "\nFailed step: test (FAILURE)"; !strings.HasSuffix(got, want) {
+		t.Errorf("got description %q, want it to end with %q", got, want)
+	}
+
+	start := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	b.Steps[1] = timedStep("test", start, 62*time.Second)
+	b.Steps[1].Status = cbpb.Build_TIMEOUT
+	b.Steps[1].Args = []string{"go", "test", "./..."}
+	msg, err = new(discordNotifier).buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if got, want := msg.Embeds[0].Description, "\nFailed step: test (TIMEOUT after 1m2s)\n`go test ./...`"; !strings.HasSuffix(got, want) {
+		t.Errorf("got description %q, want it to end with %q", got, want)
+	}
+
+	b.Steps[1].Status = cbpb.Build_SUCCESS
+	msg, err = new(discordNotifier).buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if got := msg.Embeds[0].Description; got != new(discordNotifier).buildDescription(b) {
+		t.Errorf("got description %q with no failed step, want %q", got, new(discordNotifier).buildDescription(b))
+	}
+}
+
+func TestFailedStepLines(t *testing.T) {
+	var steps []*cbpb.BuildStep
+	for i := 0; i < maxFailedSteps+2; i++ {
+		steps = append(steps, &cbpb.BuildStep{Id: fmt.Sprintf("s%d", i), Status: cbpb.Build_FAILURE})
+	}
+	got := new(discordNotifier).failedStepLines(&cbpb.Build{Steps: steps})
+	want := "Failed step: s0 (FAILURE)\nFailed step: s1 (FAILURE)\nFailed step: s2 (FAILURE)\n… and 2 more failed steps"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestArgsSummary(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{args: nil, want: ""},
+		{args: []string{"-c", "echo `date`"}, want: "-c echo 'date'"},
+		{args: []string{strings.Repeat("é", maxArgsLength+5)}, want: strings.Repeat("é", maxArgsLength-1) + "…"},
+	} {
+		if got := argsSummary(tc.args); got != tc.want {
+			t.Errorf("argsSummary(%q) = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// maxColor is the largest embed color Discord accepts (0xFFFFFF).
+const maxColor = 0xFFFFFF
+
+// embedStyle overrides the presentation of an embed. Zero values leave the embed unchanged.
+type embedStyle struct {
+	title    string
+	emoji    string
+	color    int
+	hasColor bool
+}
+
+// apply overrides the embed's title and color with any that are set in the style.
+// An emoji replaces the leading emoji of the default title, or prefixes a configured one.
+func (st embedStyle) apply(e *embed) {
+	if st.title != "" {
+		e.Title = st.title
+	} else if st.emoji != "" {
+		if i := strings.Index(e.Title, " "); i >= 0 {
+			e.Title = e.Title[i+1:]
+		}
+	}
+	if st.emoji != "" {
+		e.Title = st.emoji + " " + e.Title
+	}
+	if st.hasColor {
+		e.Color = st.color
+	}
+}
+
+// parseColor accepts an integer color or a `#RRGGBB` hex string.
+func parseColor(v interface{}) (int, error) {
+	var c int
+	switch t := v.(type) {
+	case int:
+		c = t
+	case string:
+		h := strings.TrimPrefix(t, "#")
+		if h == t || len(h) != 6 {
+			return 0, fmt.Errorf("expected color %q to be of the form #RRGGBB", t)
+		}
+		n, err := strconv.ParseInt(h, 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse color %q: %w", t, err)
+		}
+		c = int(n)
+	default:
+		return 0, fmt.Errorf("expected color to be an integer or #RRGGBB string, got %T", v)
+	}
+	if c < 0 || c > maxColor {
+		return 0, fmt.Errorf("expected color %d to be between 0 and %d", c, maxColor)
+	}
+	return c, nil
+}
+
+// parseStyle reads an embed style from a `{title: ..., emoji: ..., color: ...}` config map.
+func parseStyle(v interface{}) (embedStyle, error) {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return embedStyle{}, fmt.Errorf("expected a map with title, emoji and/or color, got %T", v)
+	}
+	var st embedStyle
+	for k, v := range m {
+		switch k {
+		case "title":
+			t, ok := v.(string)
+			if !ok {
+				return embedStyle{}, fmt.Errorf("expected title to be a string, got %T", v)
+			}
+			st.title = t
+		case "emoji":
+			em, ok := v.(string)
+			if !ok {
+				return embedStyle{}, fmt.Errorf("expected emoji to be a string, got %T", v)
+			}
+			st.emoji = em
+		case "color":
+			c, err := parseColor(v)
+			if err != nil {
+				return embedStyle{}, err
+			}
+			st.color, st.hasColor = c, true
+		default:
+			return embedStyle{}, fmt.Errorf("unknown style field %v", k)
+		}
+	}
+	return st, nil
+}
+
+// getStyles returns the optional map of name to embed style from the given delivery config.
+func getStyles(delivery map[string]interface{}, field string) (map[string]embedStyle, error) {
+	v, ok := delivery[field]
+	if !ok {
+		return nil, nil
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected delivery config field %q to be a map, got %T", field, v)
+	}
+	out := make(map[string]embedStyle, len(m))
+	for k, v := range m {
+		ks, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected keys of delivery config field %q to be strings, got %T", field, k)
+		}
+		st, err := parseStyle(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid style %q in delivery config field %q: %w", ks, field, err)
+		}
+		out[ks] = st
+	}
+	return out, nil
+}
+
+// getStatusStyles returns the optional map of build status name to embed style from the given delivery config.
+func getStatusStyles(delivery map[string]interface{}, field string) (map[cbpb.Build_Status]embedStyle, error) {
+	styles, err := getStyles(delivery, field)
+	if err != nil || len(styles) == 0 {
+		return nil, err
+	}
+	out := make(map[cbpb.Build_Status]embedStyle, len(styles))
+	for name, st := range styles {
+		status, err := parseStatus(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in delivery config field %q: %w", field, err)
+		}
+		out[status] = st
+	}
+	return out, nil
+}
+
+// getStatusSet returns the optional list of build status names from the given delivery config as a set.
+// A missing field yields a nil set.
+func getStatusSet(delivery map[string]interface{}, field string) (map[cbpb.Build_Status]bool, error) {
+	names, err := getStringSlice(delivery, field)
+	if err != nil || names == nil {
+		return nil, err
+	}
+	out := make(map[cbpb.Build_Status]bool, len(names))
+	for _, name := range names {
+		status, err := parseStatus(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid delivery config field %q: %w", field, err)
+		}
+		out[status] = true
+	}
+	return out, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestParseColor(t *testing.T) {
+	for _, tc := range []struct {
+		in      interface{}
+		want    int
+		wantErr bool
+	}{
+		{in: 1127128, want: 1127128},
+		{in: "#FF0000", want: 0xFF0000},
+		{in: "#ff0000", want: 0xFF0000},
+		{in: "FF0000", wantErr: true},
+		{in: "#F00", wantErr: true},
+		{in: "#GGGGGG", wantErr: true},
+		{in: -1, wantErr: true},
+		{in: 0x1000000, wantErr: true},
+		{in: 1.5, wantErr: true},
+	} {
+		got, err := parseColor(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseColor(%v) got error %v, want error: %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseColor(%v) got %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestPhaseStyles(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{
+		phaseSubstitutionField: "_PHASE",
+		phasesField: map[interface{}]interface{}{
+			"tests":  map[interface{}]interface{}{"title": "🧪 TESTS PASSED", "color": "#FFFF00"},
+			"deploy": map[interface{}]interface{}{"title": "🚀 DEPLOYED"},
+		},
+	})
+
+	for _, tc := range []struct {
+		phase     string
+		wantTitle string
+		wantColor int
+	}{
+		{phase: "tests", wantTitle: "🧪 TESTS PASSED", wantColor: 0xFFFF00},
+		{phase: "deploy", wantTitle: "🚀 DEPLOYED", wantColor: 1127128},
+		{phase: "", wantTitle: "✅ SUCCESS", wantColor: 1127128},
+		{phase: "unknown", wantTitle: "✅ SUCCESS", wantColor: 1127128},
+	} {
+		b := testBuild()
+		b.Substitutions["_PHASE"] = tc.phase
+		msg, err := n.buildMessage(b)
+		if err != nil {
+			t.Fatalf("buildMessage failed: %v", err)
+		}
+		if got := msg.Embeds[0]; got.Title != tc.wantTitle || got.Color != tc.wantColor {
+			t.Errorf("phase %q: got (%q, %d), want (%q, %d)", tc.phase, got.Title, got.Color, tc.wantTitle, tc.wantColor)
+		}
+	}
+
+	// Phases only restyle successful builds.
+	b := testBuild()
+	b.Status = cbpb.Build_FAILURE
+	b.Substitutions["_PHASE"] = "tests"
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if got := msg.Embeds[0].Title; got != "❌ ERROR - FAILURE" {
+		t.Errorf("got FAILURE title %q, want the default", got)
+	}
+}
+
+func TestSetUpInvalidPhases(t *testing.T) {
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	for name, delivery := range map[string]map[string]interface{}{
+		"missing substitution": {
+			phasesField: map[interface{}]interface{}{"tests": map[interface{}]interface{}{"title": "t"}},
+		},
+		"bad color": {
+			phaseSubstitutionField: "_PHASE",
+			phasesField:            map[interface{}]interface{}{"tests": map[interface{}]interface{}{"color": "red"}},
+		},
+		"unknown field": {
+			phaseSubstitutionField: "_PHASE",
+			phasesField:            map[interface{}]interface{}{"tests": map[interface{}]interface{}{"icon": "🧪"}},
+		},
+	} {
+		if err := new(discordNotifier).SetUp(context.Background(), newTestConfig(delivery), sg, nil); err == nil {
+			t.Errorf("%s: SetUp succeeded, want error", name)
+		}
+	}
+}
+
+func TestStatusStyles(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{
+		statusStylesField: map[interface{}]interface{}{
+			"SUCCESS": map[interface{}]interface{}{"title": "🎉 SHIPPED", "color": 0x00FF00},
+			"FAILURE": map[interface{}]interface{}{"color": "#FF0000"},
+			"TIMEOUT": map[interface{}]interface{}{"emoji": "⏰"},
+		},
+		phaseSubstitutionField: "_PHASE",
+		phasesField: map[interface{}]interface{}{
+			"deploy": map[interface{}]interface{}{"title": "🚀 DEPLOYED"},
+		},
+	})
+
+	for _, tc := range []struct {
+		status    cbpb.Build_Status
+		phase     string
+		wantTitle string
+		wantColor int
+	}{
+		{status: cbpb.Build_SUCCESS, wantTitle: "🎉 SHIPPED", wantColor: 0x00FF00},
+		{status: cbpb.Build_SUCCESS, phase: "deploy", wantTitle: "🚀 DEPLOYED", wantColor: 0x00FF00},
+		{status: cbpb.Build_FAILURE, wantTitle: "❌ ERROR - FAILURE", wantColor: 0xFF0000},
+		{status: cbpb.Build_TIMEOUT, wantTitle: "⏰ TIMEOUT", wantColor: timeoutColor},
+		{status: cbpb.Build_WORKING, wantTitle: "🔨 BUILDING", wantColor: 1027128},
+	} {
+		b := testBuild()
+		b.Status = tc.status
+		b.Substitutions["_PHASE"] = tc.phase
+		msg, err := n.buildMessage(b)
+		if err != nil {
+			t.Fatalf("buildMessage failed: %v", err)
+		}
+		if got := msg.Embeds[0]; got.Title != tc.wantTitle || got.Color != tc.wantColor {
+			t.Errorf("%s (phase %q): got (%q, %d), want (%q, %d)", tc.status, tc.phase, got.Title, got.Color, tc.wantTitle, tc.wantColor)
+		}
+	}
+}
+
+func TestSetUpInvalidStatusStyles(t *testing.T) {
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	for name, styles := range map[string]map[interface{}]interface{}{
+		"unknown status": {"DONE": map[interface{}]interface{}{"title": "t"}},
+		"bad color":      {"SUCCESS": map[interface{}]interface{}{"color": 0x1000000}},
+		"not a map":      {"SUCCESS": "green"},
+		"bad emoji":      {"SUCCESS": map[interface{}]interface{}{"emoji": 1}},
+	} {
+		delivery := map[string]interface{}{statusStylesField: styles}
+		if err := new(discordNotifier).SetUp(context.Background(), newTestConfig(delivery), sg, nil); err == nil {
+			t.Errorf("%s: SetUp succeeded, want error", name)
+		}
+	}
+}
+
+func TestSendNotificationStatusDisabled(t *testing.T) {
+	srv, requests := recordingServer(t, http.StatusNoContent, "")
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{
+		skipMetricsField:    true,
+		notifyStatusesField: []interface{}{"SUCCESS", "FAILURE"},
+	})
+
+	before := skipCount(skipStatusDisabled)
+	working := testBuild()
+	working.Status = cbpb.Build_WORKING
+	if err := n.SendNotification(context.Background(), working); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := skipCount(skipStatusDisabled) - before; got != 1 {
+		t.Errorf("got %d STATUS_DISABLED skips for a working build, want 1", got)
+	}
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	if got := len(requests()); got != 1 {
+		t.Errorf("got %d webhook requests, want only the success to be sent", got)
+	}
+
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": srv.URL}
+	bad := newTestConfig(map[string]interface{}{notifyStatusesField: []interface{}{"DONE"}})
+	if err := new(discordNotifier).SetUp(context.Background(), bad, sg, nil); err == nil {
+		t.Error("SetUp succeeded with an unknown status in notifyStatuses, want error")
+	}
+}
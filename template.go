@@ -0,0 +1,82 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// parseStatus returns the Build status with the given name (e.g. `SUCCESS`).
+func parseStatus(name string) (cbpb.Build_Status, error) {
+	v, ok := cbpb.Build_Status_value[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown build status %q", name)
+	}
+	return cbpb.Build_Status(v), nil
+}
+
+// parseStatusTemplates compiles a map of status name to template text from the given config field.
+func parseStatusTemplates(field string, raw map[string]string) (map[cbpb.Build_Status]*template.Template, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	out := make(map[cbpb.Build_Status]*template.Template, len(raw))
+	for name, text := range raw {
+		status, err := parseStatus(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in delivery config field %q: %w", field, err)
+		}
+		tmpl, err := template.New(field + "." + name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s template for %s: %w", field, name, err)
+		}
+		out[status] = tmpl
+	}
+	return out, nil
+}
+
+// parseTemplate compiles the template text from the given config field, returning nil for empty text.
+func parseTemplate(field, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(field).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s template: %w", field, err)
+	}
+	return tmpl, nil
+}
+
+// executeTemplate renders the template against the Build.
+func executeTemplate(tmpl *template.Template, build *cbpb.Build) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, build); err != nil {
+		return "", fmt.Errorf("failed to execute template %q: %w", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// renderContent renders the configured content template for the Build's status, if any.
+func (s *discordNotifier) renderContent(build *cbpb.Build) (string, error) {
+	tmpl, ok := s.contentTemplates[build.Status]
+	if !ok {
+		return "", nil
+	}
+	return executeTemplate(tmpl, build)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestContentTemplates(t *testing.T) {
+	cts, err := parseStatusTemplates(contentTemplatesField, map[string]string{
+		"SUCCESS": "{{.Substitutions._APP_NAME}} deployed",
+		"FAILURE": "<@&123> build {{.Id}} failed",
+	})
+	if err != nil {
+		t.Fatalf("parseStatusTemplates failed: %v", err)
+	}
+	n := &discordNotifier{contentTemplates: cts}
+
+	for _, tc := range []struct {
+		status cbpb.Build_Status
+		want   string
+	}{
+		{cbpb.Build_SUCCESS, "my-app deployed"},
+		{cbpb.Build_FAILURE, "<@&123> build some-build-id failed"},
+		{cbpb.Build_WORKING, ""},
+	} {
+		msg, err := n.buildMessage(&cbpb.Build{
+			Id:            "some-build-id",
+			Status:        tc.status,
+			Substitutions: map[string]string{"_APP_NAME": "my-app"},
+		})
+		if err != nil {
+			t.Fatalf("buildMessage failed: %v", err)
+		}
+		if msg.Content != tc.want {
+			t.Errorf("status %v: got content %q, want %q", tc.status, msg.Content, tc.want)
+		}
+	}
+}
+
+func TestParseStatusTemplatesErrors(t *testing.T) {
+	for name, raw := range map[string]map[string]string{
+		"unknown status": {"EXPLODED": "boom"},
+		"bad template":   {"SUCCESS": "{{.Id"},
+	} {
+		if _, err := parseStatusTemplates(contentTemplatesField, raw); err == nil {
+			t.Errorf("%s: parseStatusTemplates succeeded, want error", name)
+		}
+	}
+}
+
+func TestDescriptionTemplate(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{
+		descriptionTemplateField: "{{.Substitutions._APP_NAME}} build {{.Id}} finished: {{.Status}}{{.Substitutions._MISSING}}",
+	})
+	for status, want := range map[cbpb.Build_Status]string{
+		cbpb.Build_SUCCESS: "my-app build some-build-id finished: SUCCESS",
+		cbpb.Build_FAILURE: "my-app build some-build-id finished: FAILURE",
+	} {
+		b := testBuild()
+		b.Status = status
+		msg, err := n.buildMessage(b)
+		if err != nil {
+			t.Fatalf("buildMessage failed: %v", err)
+		}
+		if got := msg.Embeds[0].Description; got != want {
+			t.Errorf("%s: got description %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestSetUpInvalidDescriptionTemplate(t *testing.T) {
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	cfg := newTestConfig(map[string]interface{}{descriptionTemplateField: "{{.Id"})
+	if err := new(discordNotifier).SetUp(context.Background(), cfg, sg, nil); err == nil {
+		t.Error("SetUp succeeded, want error for an invalid template")
+	}
+}
+
+func TestTitleTemplate(t *testing.T) {
+	n := setUpTestNotifier(t, "https://discord.example.com", map[string]interface{}{
+		titleTemplateField:       "{{if eq .Status.String \"SUCCESS\"}}✅{{else}}❌{{end}} {{.Substitutions._APP_NAME}} {{.Substitutions._VERSION}}",
+		descriptionTemplateField: "Logs: {{.LogUrl}}\nBuild: {{.Id}}",
+		statusStylesField: map[interface{}]interface{}{
+			"FAILURE": map[interface{}]interface{}{"title": "overridden", "color": 0xFF0000},
+		},
+	})
+	for _, tc := range []struct {
+		status    cbpb.Build_Status
+		wantTitle string
+		wantColor int
+	}{
+		{status: cbpb.Build_SUCCESS, wantTitle: "✅ my-app v1.2", wantColor: 1127128},
+		{status: cbpb.Build_FAILURE, wantTitle: "❌ my-app v1.2", wantColor: 0xFF0000},
+	} {
+		b := testBuild()
+		b.Status = tc.status
+		b.LogUrl = "https://logs.example.com"
+		b.Substitutions["_VERSION"] = "v1.2"
+		msg, err := n.buildMessage(b)
+		if err != nil {
+			t.Fatalf("buildMessage failed: %v", err)
+		}
+		e := msg.Embeds[0]
+		if e.Title != tc.wantTitle || e.Color != tc.wantColor {
+			t.Errorf("%s: got (%q, %d), want (%q, %d)", tc.status, e.Title, e.Color, tc.wantTitle, tc.wantColor)
+		}
+		if want := "Logs: https://logs.example.com\nBuild: some-build-id"; e.Description != want {
+			t.Errorf("%s: got description %q, want %q", tc.status, e.Description, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// maxThreadNameLength is Discord's limit on thread names.
+	maxThreadNameLength = 100
+	// shortBuildIDLength is the number of characters of the Build ID used in per-build thread names.
+	shortBuildIDLength = 8
+)
+
+// idStore remembers Discord IDs by key, such as the thread created for the first of a run of
+// consecutive failures of a trigger.
+type idStore struct {
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+func newIDStore() *idStore {
+	return &idStore{ids: make(map[string]string)}
+}
+
+func (f *idStore) get(key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, ok := f.ids[key]
+	return id, ok
+}
+
+func (f *idStore) set(key, id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ids[key] = id
+}
+
+func (f *idStore) delete(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.ids, key)
+}
+
+// webhookMessage is the subset of the Discord message object returned by `?wait=true` that we use.
+type webhookMessage struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+}
+
+// failureThreadKey groups Builds of the same trigger, falling back to the Build itself for manual builds.
+func failureThreadKey(build *cbpb.Build) string {
+	if build.BuildTriggerId != "" {
+		return build.BuildTriggerId
+	}
+	return build.Id
+}
+
+// validateThreading rejects thread options that would route the same message to different threads.
+func (s *discordNotifier) validateThreading() error {
+	if s.threadID != "" && !snowflakePattern.MatchString(s.threadID) {
+		return fmt.Errorf("expected delivery config field %q to be a numeric Discord thread ID, got %q", threadIDField, s.threadID)
+	}
+	set := 0
+	for _, on := range []bool{s.threadID != "", s.threadPerBuild, s.collapseFailures} {
+		if on {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("at most one of delivery config fields %q, %q and %q may be set", threadIDField, threadPerBuildField, collapseFailuresField)
+	}
+	if s.threadPerBuild && s.editInPlace {
+		return fmt.Errorf("delivery config fields %q and %q can't be combined", threadPerBuildField, editInPlaceField)
+	}
+	return nil
+}
+
+// prepareThread adjusts the message and query for the configured thread, per-build threads or failure
+// collapsing. It returns the thread key and whether the response should be stored as a new thread.
+func (s *discordNotifier) prepareThread(threads *idStore, build *cbpb.Build, msg *discordMessage, query url.Values) (string, bool) {
+	if s.threadID != "" {
+		query.Set("thread_id", s.threadID)
+		return "", false
+	}
+	if s.threadPerBuild {
+		return s.prepareBuildThread(threads, build, msg, query)
+	}
+	if !s.collapseFailures {
+		return "", false
+	}
+	key := failureThreadKey(build)
+	if !isFailureStatus(build.Status) {
+		if build.Status == cbpb.Build_SUCCESS {
+			threads.delete(key)
+		}
+		return key, false
+	}
+	if id, ok := threads.get(key); ok {
+		query.Set("thread_id", id)
+		return key, false
+	}
+
+	msg.ThreadName = threadName(s.text("thread.failures", s.appName(build)))
+	query.Set("wait", "true")
+	return key, true
+}
+
+// prepareBuildThread posts the Build's first message as a new thread and its later ones into it.
+// The thread is forgotten once the Build reaches a final status.
+func (s *discordNotifier) prepareBuildThread(threads *idStore, build *cbpb.Build, msg *discordMessage, query url.Values) (string, bool) {
+	if id, ok := threads.get(build.Id); ok {
+		query.Set("thread_id", id)
+		if isDoneStatus(build.Status) {
+			threads.delete(build.Id)
+		}
+		return build.Id, false
+	}
+	id := build.Id
+	if len(id) > shortBuildIDLength {
+		id = id[:shortBuildIDLength]
+	}
+	msg.ThreadName = threadName(s.text("thread.build", s.appName(build), id))
+	if isDoneStatus(build.Status) {
+		// Nothing follows a final status, so there is no thread ID to remember.
+		return build.Id, false
+	}
+	query.Set("wait", "true")
+	return build.Id, true
+}
+
+// threadName truncates the name to Discord's thread name limit.
+func threadName(name string) string {
+	if r := []rune(name); len(r) > maxThreadNameLength {
+		return string(r[:maxThreadNameLength])
+	}
+	return name
+}
+
+// storeThread records the thread created by a `?wait=true` webhook response.
+func storeThread(threads *idStore, key string, body []byte) {
+	var m webhookMessage
+	if err := json.Unmarshal(body, &m); err != nil || m.ChannelID == "" {
+		notifierLog.Warningf("failed to read thread from webhook response %q: %v", body, err)
+		return
+	}
+	threads.set(key, m.ChannelID)
+}
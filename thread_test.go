@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestCollapseFailuresIntoThread(t *testing.T) {
+	srv, requests := recordingServer(t, http.StatusOK, `{"id": "message-1", "channel_id": "thread-1"}`)
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{collapseFailuresField: true})
+
+	build := func(id string, status cbpb.Build_Status) *cbpb.Build {
+		return &cbpb.Build{
+			Id:             id,
+			BuildTriggerId: "some-trigger",
+			Status:         status,
+			Substitutions:  map[string]string{"_APP_NAME": "my-app"},
+		}
+	}
+	for _, b := range []*cbpb.Build{
+		build("build-1", cbpb.Build_FAILURE),
+		build("build-2", cbpb.Build_FAILURE),
+		build("build-3", cbpb.Build_SUCCESS),
+		build("build-4", cbpb.Build_FAILURE),
+	} {
+		if err := n.SendNotification(context.Background(), b); err != nil {
+			t.Fatalf("SendNotification(%q) failed: %v", b.Id, err)
+		}
+	}
+
+	reqs := requests()
+	if len(reqs) != 4 {
+		t.Fatalf("got %d webhook requests, want 4", len(reqs))
+	}
+
+	// The first failure opens a thread.
+	var first discordMessage
+	if err := json.Unmarshal(reqs[0].body, &first); err != nil {
+		t.Fatalf("failed to unmarshal first payload: %v", err)
+	}
+	if first.ThreadName != "my-app failures" || reqs[0].query["wait"][0] != "true" {
+		t.Errorf("first failure got thread_name %q and query %v, want a new thread with wait=true", first.ThreadName, reqs[0].query)
+	}
+
+	// The second failure replies in it.
+	var second discordMessage
+	if err := json.Unmarshal(reqs[1].body, &second); err != nil {
+		t.Fatalf("failed to unmarshal second payload: %v", err)
+	}
+	if got := reqs[1].query["thread_id"]; len(got) != 1 || got[0] != "thread-1" || second.ThreadName != "" {
+		t.Errorf("second failure got thread_id %v and thread_name %q, want a reply in thread-1", got, second.ThreadName)
+	}
+
+	// A success ends the run, so the next failure opens a new thread.
+	if _, ok := reqs[2].query["thread_id"]; ok {
+		t.Errorf("success got thread_id %v, want a plain channel message", reqs[2].query["thread_id"])
+	}
+	if _, ok := reqs[3].query["thread_id"]; ok {
+		t.Errorf("failure after success got thread_id %v, want a new thread", reqs[3].query["thread_id"])
+	}
+}
+
+func TestThreadPerBuild(t *testing.T) {
+	srv, requests := recordingServer(t, http.StatusOK, `{"id": "message-1", "channel_id": "thread-1"}`)
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{threadPerBuildField: true})
+
+	for _, status := range []cbpb.Build_Status{cbpb.Build_WORKING, cbpb.Build_SUCCESS} {
+		b := testBuild()
+		b.Id = "0123456789abcdef"
+		b.Status = status
+		if err := n.SendNotification(context.Background(), b); err != nil {
+			t.Fatalf("SendNotification(%s) failed: %v", status, err)
+		}
+	}
+	// A Build that is already done when first notified still gets its own thread.
+	done := testBuild()
+	done.Status = cbpb.Build_FAILURE
+	if err := n.SendNotification(context.Background(), done); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 3 {
+		t.Fatalf("got %d webhook requests, want 3", len(reqs))
+	}
+	var msgs [3]discordMessage
+	for i, r := range reqs {
+		if err := json.Unmarshal(r.body, &msgs[i]); err != nil {
+			t.Fatalf("failed to unmarshal payload %d: %v", i, err)
+		}
+	}
+	if msgs[0].ThreadName != "my-app build 01234567" || url.Values(reqs[0].query).Get("wait") != "true" {
+		t.Errorf("WORKING got thread_name %q and query %v, want a new thread with wait=true", msgs[0].ThreadName, reqs[0].query)
+	}
+	if got := url.Values(reqs[1].query).Get("thread_id"); got != "thread-1" || msgs[1].ThreadName != "" {
+		t.Errorf("SUCCESS got thread_id %q and thread_name %q, want a reply in thread-1", got, msgs[1].ThreadName)
+	}
+	if msgs[2].ThreadName != "my-app build some-bui" || url.Values(reqs[2].query).Get("wait") != "" || url.Values(reqs[2].query).Get("thread_id") != "" {
+		t.Errorf("finished build got thread_name %q and query %v, want a new thread without wait", msgs[2].ThreadName, reqs[2].query)
+	}
+	if _, ok := n.sinks[0].(*discordSink).threads.get("0123456789abcdef"); ok {
+		t.Error("thread of a finished Build is still stored, want it forgotten")
+	}
+}
+
+func TestThreadPerBuildLocale(t *testing.T) {
+	srv, requests := recordingServer(t, http.StatusOK, `{"id": "message-1", "channel_id": "thread-1"}`)
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{threadPerBuildField: true, localeField: "fr"})
+
+	b := testBuild()
+	b.Id = "0123456789abcdef"
+	b.Status = cbpb.Build_WORKING
+	if err := n.SendNotification(context.Background(), b); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	reqs := requests()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d webhook requests, want 1", len(reqs))
+	}
+	var msg discordMessage
+	if err := json.Unmarshal(reqs[0].body, &msg); err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	if want := "Build 01234567 de my-app"; msg.ThreadName != want {
+		t.Errorf("got thread_name %q, want %q", msg.ThreadName, want)
+	}
+}
+
+func TestThreadID(t *testing.T) {
+	srv, requests := recordingServer(t, http.StatusNoContent, "")
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{threadIDField: "123456"})
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	reqs := requests()
+	if len(reqs) != 1 || url.Values(reqs[0].query).Get("thread_id") != "123456" {
+		t.Errorf("got requests %+v, want one posted with thread_id=123456", reqs)
+	}
+}
+
+func TestSetUpInvalidThreading(t *testing.T) {
+	sg := fakeSecretGetter{"projects/p/secrets/webhook-url/versions/latest": "https://discord.example.com"}
+	for name, delivery := range map[string]map[string]interface{}{
+		"non-numeric thread ID":       {threadIDField: "general"},
+		"thread ID and per build":     {threadIDField: "123", threadPerBuildField: true},
+		"per build and collapsing":    {threadPerBuildField: true, collapseFailuresField: true},
+		"per build and edit-in-place": {threadPerBuildField: true, editInPlaceField: true},
+	} {
+		if err := new(discordNotifier).SetUp(context.Background(), newTestConfig(delivery), sg, nil); err == nil {
+			t.Errorf("%s: SetUp succeeded, want error", name)
+		}
+	}
+}
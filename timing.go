@@ -0,0 +1,121 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// maxTimedSteps caps the number of steps listed in a step timing breakdown.
+const maxTimedSteps = 10
+
+// formatDuration renders a duration rounded to the second without zero-valued units, e.g. `2m` or `1h3m12s`.
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d <= 0 {
+		return "0s"
+	}
+	var b strings.Builder
+	if h := d / time.Hour; h > 0 {
+		fmt.Fprintf(&b, "%dh", h)
+	}
+	if m := (d % time.Hour) / time.Minute; m > 0 {
+		fmt.Fprintf(&b, "%dm", m)
+	}
+	if sec := (d % time.Minute) / time.Second; sec > 0 {
+		fmt.Fprintf(&b, "%ds", sec)
+	}
+	return b.String()
+}
+
+// clock returns the current time, using the injected clock when one is set.
+func (s *discordNotifier) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// elapsedLine returns a `Running for` line measured from the Build's creation, or "" if it has no CreateTime.
+func (s *discordNotifier) elapsedLine(build *cbpb.Build) string {
+	if build.CreateTime == nil {
+		return ""
+	}
+	return s.text("text.runningFor", formatDuration(s.clock().Sub(build.CreateTime.AsTime())))
+}
+
+// durationLine returns a line with a finished Build's run time (StartTime to FinishTime) and queue time
+// (CreateTime to StartTime), e.g. `Duration: 4m32s, Queued: 12s`. Either part is omitted when its
+// timestamps are missing, and "" is returned if both are.
+func (s *discordNotifier) durationLine(build *cbpb.Build) string {
+	var parts []string
+	if build.StartTime != nil && build.FinishTime != nil {
+		parts = append(parts, s.detail("label.duration", formatDuration(build.FinishTime.AsTime().Sub(build.StartTime.AsTime()))))
+	}
+	if build.CreateTime != nil && build.StartTime != nil {
+		parts = append(parts, s.detail("label.queued", formatDuration(build.StartTime.AsTime().Sub(build.CreateTime.AsTime()))))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// stepLabel identifies a step by its id, falling back to its builder image name.
+func stepLabel(step *cbpb.BuildStep) string {
+	if step.Id != "" {
+		return step.Id
+	}
+	return step.Name
+}
+
+// stepTimings renders a code block listing each timed step and its duration, or "" if no step has timing.
+func (s *discordNotifier) stepTimings(build *cbpb.Build) string {
+	type timed struct {
+		label    string
+		duration string
+	}
+	var steps []timed
+	width := 0
+	for _, st := range build.Steps {
+		if st.Timing == nil || st.Timing.StartTime == nil || st.Timing.EndTime == nil {
+			continue
+		}
+		t := timed{
+			label:    stepLabel(st),
+			duration: formatDuration(st.Timing.EndTime.AsTime().Sub(st.Timing.StartTime.AsTime())),
+		}
+		if len(steps) < maxTimedSteps && len(t.label) > width {
+			width = len(t.label)
+		}
+		steps = append(steps, t)
+	}
+	if len(steps) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(s.text("heading.steps") + "\n```\n")
+	for i, t := range steps {
+		if i == maxTimedSteps {
+			b.WriteString(s.textN("text.more", len(steps)-maxTimedSteps) + "\n")
+			break
+		}
+		fmt.Fprintf(&b, "%-*s  %s\n", width, t.label, t.duration)
+	}
+	b.WriteString("```")
+	return b.String()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestFormatDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                              "0s",
+		1500 * time.Millisecond:        "2s",
+		2 * time.Minute:                "2m",
+		3*time.Minute + 42*time.Second: "3m42s",
+		time.Hour + 3*time.Minute + 12*time.Second: "1h3m12s",
+		2 * time.Hour: "2h",
+	} {
+		if got := formatDuration(d); got != want {
+			t.Errorf("formatDuration(%v) got %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestBuildMessageElapsed(t *testing.T) {
+	created := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	n := &discordNotifier{
+		showElapsed: true,
+		now:         func() time.Time { return created.Add(2 * time.Minute) },
+	}
+	b := testBuild()
+	b.Status = cbpb.Build_WORKING
+	b.CreateTime = timestamppb.New(created)
+
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if got := msg.Embeds[0].Description; !strings.HasSuffix(got, "\nRunning for 2m") {
+		t.Errorf("got description %q, want it to end with the elapsed line", got)
+	}
+
+	n.showElapsed = false
+	msg, err = n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	if got := msg.Embeds[0].Description; strings.Contains(got, "Running for") {
+		t.Errorf("got description %q with showElapsed unset, want no elapsed line", got)
+	}
+}
+
+func TestBuildMessageDuration(t *testing.T) {
+	start := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		status cbpb.Build_Status
+		finish time.Time
+		want   bool
+	}{
+		{name: "success", status: cbpb.Build_SUCCESS, finish: start.Add(3*time.Minute + 42*time.Second), want: true},
+		{name: "failure", status: cbpb.Build_FAILURE, finish: start.Add(3*time.Minute + 42*time.Second), want: true},
+		{name: "unfinished", status: cbpb.Build_SUCCESS},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := testBuild()
+			b.Status = tc.status
+			b.StartTime = timestamppb.New(start)
+			if !tc.finish.IsZero() {
+				b.FinishTime = timestamppb.New(tc.finish)
+			}
+
+			msg, err := new(discordNotifier).buildMessage(b)
+			if err != nil {
+				t.Fatalf("buildMessage failed: %v", err)
+			}
+			if got := strings.Contains(msg.Embeds[0].Description, "\nDuration: 3m42s"); got != tc.want {
+				t.Errorf("got description %q, want duration line %v", msg.Embeds[0].Description, tc.want)
+			}
+		})
+	}
+}
+
+func TestDurationLine(t *testing.T) {
+	create := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	start := create.Add(12 * time.Second)
+	finish := start.Add(4*time.Minute + 32*time.Second)
+	for _, tc := range []struct {
+		name                  string
+		create, start, finish time.Time
+		want                  string
+	}{
+		{name: "all", create: create, start: start, finish: finish, want: "Duration: 4m32s, Queued: 12s"},
+		{name: "no create time", start: start, finish: finish, want: "Duration: 4m32s"},
+		{name: "never started", create: create, finish: finish, want: ""},
+		{name: "no finish time", create: create, start: start, want: "Queued: 12s"},
+	} {
+		b := testBuild()
+		for _, ts := range []struct {
+			t   time.Time
+			dst **timestamppb.Timestamp
+		}{{tc.create, &b.CreateTime}, {tc.start, &b.StartTime}, {tc.finish, &b.FinishTime}} {
+			if !ts.t.IsZero() {
+				*ts.dst = timestamppb.New(ts.t)
+			}
+		}
+		if got := new(discordNotifier).durationLine(b); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func timedStep(id string, start time.Time, d time.Duration) *cbpb.BuildStep {
+	return &cbpb.BuildStep{
+		Id:   id,
+		Name: "gcr.io/cloud-builders/docker",
+		Timing: &cbpb.TimeSpan{
+			StartTime: timestamppb.New(start),
+			EndTime:   timestamppb.New(start.Add(d)),
+		},
+	}
+}
+
+func TestBuildMessageStepTimings(t *testing.T) {
+	start := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	b := testBuild()
+	b.Steps = []*cbpb.BuildStep{
+		timedStep("build", start, 3*time.Minute+42*time.Second),
+		timedStep("", start, 12*time.Second),
+		{Name: "untimed"},
+	}
+
+	n := &discordNotifier{showStepTimings: true}
+	msg, err := n.buildMessage(b)
+	if err != nil {
+		t.Fatalf("buildMessage failed: %v", err)
+	}
+	want := "\nSteps:\n```\n" +
+		"build                         3m42s\n" +
+		"gcr.io/cloud-builders/docker  12s\n" +
+		"```"
+	if got := msg.Embeds[0].Description; !strings.HasSuffix(got, want) {
+		t.Errorf("got description %q, want it to end with %q", got, want)
+	}
+}
+
+func TestStepTimingsTruncated(t *testing.T) {
+	start := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	b := testBuild()
+	for i := 0; i < maxTimedSteps+3; i++ {
+		b.Steps = append(b.Steps, timedStep(fmt.Sprintf("step-%02d", i), start, time.Second))
+	}
+	got := new(discordNotifier).stepTimings(b)
+	if !strings.Contains(got, "step-09") || strings.Contains(got, "step-10") {
+		t.Errorf("got %q, want only the first %d steps", got, maxTimedSteps)
+	}
+	if !strings.Contains(got, "… and 3 more") {
+		t.Errorf("got %q, want a truncation note", got)
+	}
+	if got := new(discordNotifier).stepTimings(&cbpb.Build{}); got != "" {
+		t.Errorf("got %q for a build without steps, want empty", got)
+	}
+}
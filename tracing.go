@@ -0,0 +1,91 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// traceExporterEnv enables tracing with the named exporter. Only `stdout` is supported.
+	traceExporterEnv = "TRACE_EXPORTER"
+	tracerName       = "github.com/GoogleCloudPlatform/cloud-build-notifiers/discord"
+)
+
+// noopTracer is used when tracing is disabled so that spans cost next to nothing.
+var noopTracer = trace.NewNoopTracerProvider().Tracer(tracerName)
+
+// newTracerProvider returns a TracerProvider exporting to the named exporter. Spans are exported as
+// they end, since Cloud Run can stop the instance without giving a batcher the chance to flush.
+func newTracerProvider(exporter string) (*sdktrace.TracerProvider, error) {
+	switch exporter {
+	case "stdout":
+		exp, err := stdout.NewExporter(stdout.WithoutMetricExport())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdout trace exporter: %w", err)
+		}
+		return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)), nil
+	default:
+		return nil, fmt.Errorf("unsupported %s %q", traceExporterEnv, exporter)
+	}
+}
+
+// startSpan starts a span annotated with the Build's ID and status.
+func (s *discordNotifier) startSpan(ctx context.Context, name string, build *cbpb.Build) (context.Context, trace.Span) {
+	tracer := s.tracer
+	if tracer == nil {
+		return noopTracer.Start(ctx, name)
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.String("build.id", build.Id),
+		attribute.String("build.status", build.Status.String()),
+	))
+}
+
+// endSpan records the outcome of a SendNotification call.
+func endSpan(span trace.Span, reason skipReason, err error) {
+	if !span.IsRecording() {
+		return
+	}
+	switch {
+	case err != nil:
+		span.SetAttributes(attribute.String("outcome", "error"))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case reason != "":
+		span.SetAttributes(attribute.String("outcome", "skipped"), attribute.String("skip.reason", string(reason)))
+	default:
+		span.SetAttributes(attribute.String("outcome", "sent"))
+	}
+}
+
+// recordAttempt annotates the webhook span with the latest attempt.
+func recordAttempt(span trace.Span, attempt, status int, err error) {
+	if !span.IsRecording() {
+		return
+	}
+	span.SetAttributes(attribute.Int("retries", attempt-1), attribute.Int("http.status_code", status))
+	if err != nil {
+		span.RecordError(err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttributes(span *sdktrace.SpanSnapshot) map[string]string {
+	out := make(map[string]string)
+	for _, kv := range span.Attributes {
+		out[string(kv.Key)] = kv.Value.Emit()
+	}
+	return out
+}
+
+func TestSendNotificationSpans(t *testing.T) {
+	srv, _ := sequenceServer(t, respondWith(http.StatusServiceUnavailable), respondWith(http.StatusNoContent))
+	n := setUpTestNotifier(t, srv.URL, nil)
+	n.retryDelay = time.Millisecond
+
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	n.tracer = tp.Tracer(tracerName)
+
+	if err := n.SendNotification(context.Background(), testBuild()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+
+	spans := make(map[string]map[string]string)
+	for _, s := range exp.GetSpans() {
+		spans[s.Name] = spanAttributes(s)
+	}
+	want := map[string]map[string]string{
+		"SendNotification": {
+			"build.id":     "some-build-id",
+			"build.status": "SUCCESS",
+			"outcome":      "sent",
+		},
+		"webhook POST": {
+			"build.id":         "some-build-id",
+			"build.status":     "SUCCESS",
+			"retries":          "1",
+			"http.status_code": "204",
+		},
+	}
+	if diff := cmp.Diff(want, spans); diff != "" {
+		t.Errorf("got unexpected span diff: %s", diff)
+	}
+}
+
+func TestSkippedSpan(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	n := &discordNotifier{
+		requireSubstitutions: []string{"_APP_NAME"},
+		tracer:               sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)).Tracer(tracerName),
+	}
+
+	if err := n.SendNotification(context.Background(), testBuildWithoutApp()); err != nil {
+		t.Fatalf("SendNotification failed: %v", err)
+	}
+	spans := exp.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	attrs := spanAttributes(spans[0])
+	if attrs["outcome"] != "skipped" || attrs["skip.reason"] != string(skipMissingSubstitution) {
+		t.Errorf("got span attributes %v, want a %s skip", attrs, skipMissingSubstitution)
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	n := new(discordNotifier)
+	_, span := n.startSpan(context.Background(), "SendNotification", testBuild())
+	if span.IsRecording() {
+		t.Error("got a recording span with no tracer configured")
+	}
+	span.SetAttributes(attribute.String("ignored", "value"))
+	span.End()
+}
+
+func TestSpansHideWebhookToken(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const token = "s3cr3t-webhook-token"
+	webhookURL := "http://" + l.Addr().String() + "/api/webhooks/123/" + token
+	l.Close()
+
+	n := setUpTestNotifier(t, webhookURL, nil)
+	n.retryDelay = time.Millisecond
+	exp := tracetest.NewInMemoryExporter()
+	n.tracer = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)).Tracer(tracerName)
+
+	if err := n.SendNotification(context.Background(), testBuild()); err == nil {
+		t.Fatal("SendNotification to a closed listener succeeded, want error")
+	}
+	spans := exp.GetSpans()
+	if len(spans) == 0 {
+		t.Fatal("got no spans")
+	}
+	for _, s := range spans {
+		if recorded := fmt.Sprint(s.StatusMessage, s.MessageEvents); strings.Contains(recorded, token) {
+			t.Errorf("span %q recorded %s, want the webhook token left out", s.Name, recorded)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// transitionsField enables tracking the last status of each trigger to call out fixed and repeated failures.
+	transitionsField = "transitions"
+	// transitionsGroupByField is `trigger` (the default) or `branch`, which tracks each branch of a trigger apart.
+	transitionsGroupByField = "groupBy"
+	// transitionsBucketField is an optional `gs://bucket/prefix` location persisting statuses across instances.
+	transitionsBucketField = "bucket"
+
+	groupByTrigger = "trigger"
+	groupByBranch  = "branch"
+
+	// maxTrackedStatuses bounds how many triggers the in-memory store remembers.
+	maxTrackedStatuses = 1000
+)
+
+// statusRecord is what is remembered about the last finished Build of a trigger.
+type statusRecord struct {
+	BuildID string `json:"build_id"`
+	// Failures counts the consecutive failures up to and including BuildID.
+	Failures int `json:"failures"`
+	// Fixed is set if BuildID succeeded after failures.
+	Fixed bool `json:"fixed"`
+	// FixedFailures is how many failures BuildID fixed.
+	FixedFailures int `json:"fixed_failures,omitempty"`
+}
+
+// statusStore keeps the last statusRecord per trigger.
+type statusStore interface {
+	get(ctx context.Context, key string) (statusRecord, bool, error)
+	put(ctx context.Context, key string, r statusRecord) error
+}
+
+// transitions tracks the status history of triggers.
+type transitions struct {
+	groupBy string
+	store   statusStore
+}
+
+// getTransitions returns the tracking configured in the optional transitionsField map, or nil if it is unset.
+// `transitions: true` tracks per trigger in memory.
+func getTransitions(ctx context.Context, delivery map[string]interface{}) (*transitions, error) {
+	raw, ok := delivery[transitionsField]
+	if !ok {
+		return nil, nil
+	}
+	if on, ok := raw.(bool); ok {
+		if !on {
+			return nil, nil
+		}
+		raw = map[interface{}]interface{}{}
+	}
+	cfg, err := toStringMap(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delivery config field %q: %w", transitionsField, err)
+	}
+	for k := range cfg {
+		switch k {
+		case transitionsGroupByField, transitionsBucketField:
+		default:
+			return nil, fmt.Errorf("unknown field %q in delivery config field %q", k, transitionsField)
+		}
+	}
+
+	t := new(transitions)
+	if t.groupBy, err = getString(cfg, transitionsGroupByField); err != nil {
+		return nil, err
+	}
+	switch t.groupBy {
+	case "":
+		t.groupBy = groupByTrigger
+	case groupByTrigger, groupByBranch:
+	default:
+		return nil, fmt.Errorf("expected delivery config field %q to be %q or %q, got %q", transitionsField+"."+transitionsGroupByField, groupByTrigger, groupByBranch, t.groupBy)
+	}
+
+	bucket, err := getString(cfg, transitionsBucketField)
+	if err != nil {
+		return nil, err
+	}
+	if bucket == "" {
+		t.store = newMemoryStatusStore(maxTrackedStatuses)
+		return t, nil
+	}
+	sc, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client for transitions: %w", err)
+	}
+	if t.store, err = newGCSStatusStore(sc, bucket); err != nil {
+		return nil, fmt.Errorf("invalid delivery config field %q: %w", transitionsField+"."+transitionsBucketField, err)
+	}
+	return t, nil
+}
+
+// key groups the Build with earlier ones of its trigger, and branch if grouping by branch. Builds
+// without a trigger, such as manual ones, aren't tracked.
+func (t *transitions) key(build *cbpb.Build) string {
+	if build.BuildTriggerId == "" {
+		return ""
+	}
+	if t.groupBy == groupByBranch {
+		return build.BuildTriggerId + "/" + build.Substitutions["BRANCH_NAME"]
+	}
+	return build.BuildTriggerId
+}
+
+// recordStatus updates the status history of the Build's trigger and returns the Build's record, or nil
+// if the Build isn't tracked. Failures are logged since they shouldn't hold up the notification.
+func (s *discordNotifier) recordStatus(ctx context.Context, build *cbpb.Build) *statusRecord {
+	if s.transitions == nil || !isDoneStatus(build.Status) {
+		return nil
+	}
+	fixed := build.Status == cbpb.Build_SUCCESS
+	if !fixed && !isFailureStatus(build.Status) {
+		// Cancelled and expired Builds say nothing about whether the trigger is broken.
+		return nil
+	}
+	key := s.transitions.key(build)
+	if key == "" {
+		return nil
+	}
+	prev, ok, err := s.transitions.store.get(ctx, key)
+	if err != nil {
+		buildLog(build).Warningf("failed to read the last status of %q: %v", key, err)
+		return nil
+	}
+	if ok && prev.BuildID == build.Id {
+		// A redelivered event gets the same callout as the first delivery.
+		return &prev
+	}
+	r := statusRecord{BuildID: build.Id}
+	if fixed {
+		r.Fixed = prev.Failures > 0
+		r.FixedFailures = prev.Failures
+	} else {
+		r.Failures = prev.Failures + 1
+	}
+	if err := s.transitions.store.put(ctx, key, r); err != nil {
+		buildLog(build).Warningf("failed to record the status of %q: %v", key, err)
+	}
+	return &r
+}
+
+// annotateTransition calls out a Build that fixed its trigger, or that failed again.
+func (s *discordNotifier) annotateTransition(r *statusRecord, msg *discordMessage) {
+	if r == nil || len(msg.Embeds) == 0 {
+		return
+	}
+	e := &msg.Embeds[0]
+	switch {
+	case r.Fixed:
+		e.Title = strings.Replace(e.Title, s.text("title.success"), s.text("title.fixed"), 1)
+		e.Description += "\n" + s.textN("text.fixedAfter", r.FixedFailures)
+	case r.Failures > 1:
+		e.Description += "\n" + s.text("text.stillFailing", ordinal(r.Failures), r.Failures)
+	}
+}
+
+// ordinal renders n as `1st`, `2nd`, `3rd`, `4th` and so on.
+func ordinal(n int) string {
+	suffix := "th"
+	switch n % 10 {
+	case 1:
+		suffix = "st"
+	case 2:
+		suffix = "nd"
+	case 3:
+		suffix = "rd"
+	}
+	if n%100 >= 11 && n%100 <= 13 {
+		suffix = "th"
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+// memoryStatusStore keeps statuses in memory, which is enough when a single notifier instance
+// receives every event. The least recently updated trigger is forgotten once max is reached.
+type memoryStatusStore struct {
+	mu      sync.Mutex
+	max     int
+	records map[string]statusRecord
+	order   []string
+}
+
+func newMemoryStatusStore(max int) *memoryStatusStore {
+	return &memoryStatusStore{max: max, records: make(map[string]statusRecord)}
+}
+
+func (m *memoryStatusStore) get(_ context.Context, key string) (statusRecord, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.records[key]
+	return r, ok, nil
+}
+
+func (m *memoryStatusStore) put(_ context.Context, key string, r statusRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.records[key]; ok {
+		for i, k := range m.order {
+			if k == key {
+				m.order = append(m.order[:i], m.order[i+1:]...)
+				break
+			}
+		}
+	} else if len(m.order) >= m.max {
+		delete(m.records, m.order[0])
+		m.order = m.order[1:]
+	}
+	m.records[key] = r
+	m.order = append(m.order, key)
+	return nil
+}
+
+// gcsStatusStore keeps each trigger's statusRecord as a JSON object in a GCS bucket, so that every
+// notifier instance sharing the bucket sees the same history. Concurrent Builds of a trigger race,
+// and the last one written wins.
+type gcsStatusStore struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+// newGCSStatusStore returns a store writing under a `gs://bucket/prefix` location.
+func newGCSStatusStore(client *storage.Client, location string) (*gcsStatusStore, error) {
+	bucket, prefix, err := parseGCSLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsStatusStore{bucket: client.Bucket(bucket), prefix: prefix}, nil
+}
+
+func (g *gcsStatusStore) object(key string) *storage.ObjectHandle {
+	// Branch names may contain slashes, which would otherwise nest objects.
+	return g.bucket.Object(g.prefix + url.PathEscape(key))
+}
+
+func (g *gcsStatusStore) get(ctx context.Context, key string) (statusRecord, bool, error) {
+	var r statusRecord
+	rd, err := g.object(key).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return r, false, nil
+	}
+	if err != nil {
+		return r, false, fmt.Errorf("failed to read status %q: %w", key, err)
+	}
+	defer rd.Close()
+	b, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return r, false, fmt.Errorf("failed to read status %q: %w", key, err)
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return r, false, fmt.Errorf("failed to unmarshal status %q: %w", key, err)
+	}
+	return r, true, nil
+}
+
+func (g *gcsStatusStore) put(ctx context.Context, key string, r statusRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal status %q: %w", key, err)
+	}
+	w := g.object(key).NewWriter(ctx)
+	w.ContentType = "application/json"
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write status %q: %w", key, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write status %q: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestTransitions(t *testing.T) {
+	srv, got := recordingServer(t, 204, "")
+	n := setUpTestNotifier(t, srv.URL, map[string]interface{}{transitionsField: true})
+
+	for i, st := range []cbpb.Build_Status{
+		cbpb.Build_FAILURE,
+		cbpb.Build_CANCELLED,
+		cbpb.Build_FAILURE,
+		cbpb.Build_TIMEOUT,
+		cbpb.Build_SUCCESS,
+		cbpb.Build_SUCCESS,
+	} {
+		b := testBuild()
+		b.Id = string(rune('a' + i))
+		b.BuildTriggerId = "trigger"
+		b.Status = st
+		if err := n.SendNotification(context.Background(), b); err != nil {
+			t.Fatalf("SendNotification(%v) failed: %v", st, err)
+		}
+	}
+
+	var titles, callouts []string
+	for _, r := range got() {
+		var msg discordMessage
+		if err := json.Unmarshal(r.body, &msg); err != nil {
+			t.Fatalf("failed to unmarshal payload: %v", err)
+		}
+		lines := strings.Split(msg.Embeds[0].Description, "\n")
+		last := lines[len(lines)-1]
+		if !strings.HasPrefix(last, "Still failing") && !strings.HasPrefix(last, "Fixed after") {
+			last = ""
+		}
+		titles = append(titles, msg.Embeds[0].Title)
+		callouts = append(callouts, last)
+	}
+	want := []string{"", "", "Still failing (2nd consecutive)", "Still failing (3rd consecutive)", "Fixed after 3 failed builds", ""}
+	if diff := cmp.Diff(want, callouts); diff != "" {
+		t.Errorf("callouts diff: (-want +got):\n%s", diff)
+	}
+	if titles[4] != "❌→✅ FIXED" {
+		t.Errorf("fixing message title = %q, want %q", titles[4], "❌→✅ FIXED")
+	}
+	if titles[5] != "✅ SUCCESS" {
+		t.Errorf("following message title = %q, want %q", titles[5], "✅ SUCCESS")
+	}
+}
+
+func TestRecordStatus(t *testing.T) {
+	n := &discordNotifier{transitions: &transitions{groupBy: groupByBranch, store: newMemoryStatusStore(10)}}
+	build := func(id, branch string, st cbpb.Build_Status) *cbpb.Build {
+		return &cbpb.Build{Id: id, BuildTriggerId: "t", Status: st, Substitutions: map[string]string{"BRANCH_NAME": branch}}
+	}
+	ctx := context.Background()
+
+	n.recordStatus(ctx, build("1", "main", cbpb.Build_FAILURE))
+	if r := n.recordStatus(ctx, build("2", "feature/x", cbpb.Build_SUCCESS)); r == nil || r.Fixed {
+		t.Errorf("other branch = %+v, want it tracked apart and not fixed", r)
+	}
+	r := n.recordStatus(ctx, build("3", "main", cbpb.Build_SUCCESS))
+	want := &statusRecord{BuildID: "3", Fixed: true, FixedFailures: 1}
+	if diff := cmp.Diff(want, r); diff != "" {
+		t.Errorf("recordStatus diff: (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, n.recordStatus(ctx, build("3", "main", cbpb.Build_SUCCESS))); diff != "" {
+		t.Errorf("redelivered recordStatus diff: (-want +got):\n%s", diff)
+	}
+	if r := n.recordStatus(ctx, &cbpb.Build{Id: "4", Status: cbpb.Build_FAILURE}); r != nil {
+		t.Errorf("manual Build = %+v, want it untracked", r)
+	}
+	if r := n.recordStatus(ctx, build("5", "main", cbpb.Build_WORKING)); r != nil {
+		t.Errorf("WORKING Build = %+v, want it untracked", r)
+	}
+}
+
+func TestGetTransitionsErrors(t *testing.T) {
+	for _, v := range []interface{}{
+		"yes",
+		map[interface{}]interface{}{"groupBy": "commit"},
+		map[interface{}]interface{}{"unknown": true},
+		map[interface{}]interface{}{"bucket": "my-bucket"},
+	} {
+		if _, err := getTransitions(context.Background(), map[string]interface{}{transitionsField: v}); err == nil {
+			t.Errorf("getTransitions(%v) succeeded, want error", v)
+		}
+	}
+}
+
+func TestOrdinal(t *testing.T) {
+	for n, want := range map[int]string{1: "1st", 2: "2nd", 3: "3rd", 4: "4th", 11: "11th", 12: "12th", 13: "13th", 21: "21st", 102: "102nd", 111: "111th"} {
+		if got := ordinal(n); got != want {
+			t.Errorf("ordinal(%d) = %q, want %q", n, got, want)
+		}
+	}
+}